@@ -0,0 +1,352 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.gearno.de/x/panicf"
+)
+
+type (
+	cacheRoundTripper struct {
+		store        CacheStore
+		next         http.RoundTripper
+		now          func() time.Time
+		maxBodyBytes int64
+
+		requestsTotal *prometheus.CounterVec
+	}
+
+	cacheControl map[string]string
+
+	// multiReadCloser reads from Reader and closes Closer, the body
+	// of the response Reader ends with.
+	multiReadCloser struct {
+		io.Reader
+		io.Closer
+	}
+)
+
+const (
+	cacheResultHit         = "hit"
+	cacheResultMiss        = "miss"
+	cacheResultRevalidated = "revalidated"
+)
+
+var (
+	_ http.RoundTripper = (*cacheRoundTripper)(nil)
+)
+
+func newCacheRoundTripper(next http.RoundTripper, store CacheStore, maxBodyBytes int64, registerer prometheus.Registerer) *cacheRoundTripper {
+	if maxBodyBytes <= 0 {
+		panicf.Panic("invalid cache max body size %d: must be positive", maxBodyBytes)
+	}
+
+	requestsTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http_client",
+			Name:      "cache_requests_total",
+			Help:      "Total number of cacheable HTTP requests by cache result: hit, miss or revalidated.",
+		},
+		[]string{"host", "result"},
+	)
+
+	if err := registerer.Register(requestsTotal); err != nil {
+		are := &prometheus.AlreadyRegisteredError{}
+		if errors.As(err, are) {
+			requestsTotal = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			panicf.Panic(
+				"cannot register %q prometheus metrics: %w",
+				"http_client_cache_requests_total",
+				err,
+			)
+		}
+	}
+
+	return &cacheRoundTripper{
+		store:         store,
+		next:          next,
+		now:           time.Now,
+		maxBodyBytes:  maxBodyBytes,
+		requestsTotal: requestsTotal,
+	}
+}
+
+// RoundTrip serves GET requests from the cache while the cached
+// response is fresh, revalidates it with a conditional request once
+// it is stale, and caches the cacheable responses. Requests carrying
+// their own conditional or range headers bypass the cache, and
+// successful requests with an unsafe method invalidate the cached
+// response of their URL.
+func (rt *cacheRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet {
+		resp, err := rt.next.RoundTrip(r)
+		if err == nil && r.Method != http.MethodHead && resp.StatusCode < 400 {
+			rt.store.Delete(cacheKey(r))
+		}
+
+		return resp, err
+	}
+
+	if r.Header.Get("range") != "" ||
+		r.Header.Get("if-none-match") != "" ||
+		r.Header.Get("if-modified-since") != "" {
+		return rt.next.RoundTrip(r)
+	}
+
+	reqCacheControl := parseCacheControl(r.Header)
+	if reqCacheControl.has("no-store") {
+		return rt.next.RoundTrip(r)
+	}
+
+	var (
+		key         = cacheKey(r)
+		cached, hit = rt.store.Get(key)
+	)
+
+	if hit && !varyMatches(cached.Vary, r.Header) {
+		hit = false
+	}
+
+	if hit && !reqCacheControl.has("no-cache") && rt.now().Before(cached.ExpiresAt) {
+		rt.requestsTotal.WithLabelValues(r.URL.Host, cacheResultHit).Inc()
+		return cachedHTTPResponse(r, cached), nil
+	}
+
+	etag := ""
+	lastModified := ""
+	if hit {
+		etag = cached.Header.Get("etag")
+		lastModified = cached.Header.Get("last-modified")
+	}
+
+	if etag == "" && lastModified == "" {
+		resp, err := rt.next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		rt.requestsTotal.WithLabelValues(r.URL.Host, cacheResultMiss).Inc()
+		return rt.cache(key, r, resp)
+	}
+
+	r2 := r.Clone(r.Context())
+	if etag != "" {
+		r2.Header.Set("if-none-match", etag)
+	}
+	if lastModified != "" {
+		r2.Header.Set("if-modified-since", lastModified)
+	}
+
+	resp, err := rt.next.RoundTrip(r2)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNotModified {
+		rt.requestsTotal.WithLabelValues(r.URL.Host, cacheResultMiss).Inc()
+		return rt.cache(key, r, resp)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The 304 response headers update the ones of the cached
+	// response, including its freshness.
+	header := cached.Header.Clone()
+	for k, values := range resp.Header {
+		if k != "Content-Length" {
+			header[k] = values
+		}
+	}
+
+	revalidated := &CachedResponse{
+		StatusCode: cached.StatusCode,
+		Header:     header,
+		Body:       cached.Body,
+		Vary:       cached.Vary,
+		ExpiresAt:  freshnessExpiry(header, rt.now()),
+	}
+	rt.store.Set(key, revalidated)
+
+	rt.requestsTotal.WithLabelValues(r.URL.Host, cacheResultRevalidated).Inc()
+	return cachedHTTPResponse(r, revalidated), nil
+}
+
+// cache stores resp when it is cacheable and its body is at most
+// maxBodyBytes long, returning it with its body buffered in memory.
+// Larger bodies are not cached, and the part already read is streamed
+// to the caller followed by the rest of the body.
+func (rt *cacheRoundTripper) cache(key string, r *http.Request, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || resp.Header.Get("vary") == "*" {
+		return resp, nil
+	}
+
+	expiresAt := freshnessExpiry(resp.Header, rt.now())
+	hasValidator := resp.Header.Get("etag") != "" || resp.Header.Get("last-modified") != ""
+	if !expiresAt.After(rt.now()) && !hasValidator {
+		return resp, nil
+	}
+
+	if resp.ContentLength > rt.maxBodyBytes {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, rt.maxBodyBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot read response body: %w", err)
+	}
+
+	if int64(len(body)) > rt.maxBodyBytes {
+		resp.Body = &multiReadCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+			Closer: resp.Body,
+		}
+
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	vary := make(http.Header)
+	for _, field := range resp.Header.Values("vary") {
+		for _, name := range strings.Split(field, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" {
+				vary[name] = r.Header.Values(name)
+			}
+		}
+	}
+
+	rt.store.Set(
+		key,
+		&CachedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       body,
+			Vary:       vary,
+			ExpiresAt:  expiresAt,
+		},
+	)
+
+	return resp, nil
+}
+
+func cacheKey(r *http.Request) string {
+	return http.MethodGet + " " + r.URL.String()
+}
+
+func cachedHTTPResponse(r *http.Request, cached *CachedResponse) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+		StatusCode:    cached.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cached.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       r,
+	}
+}
+
+func varyMatches(vary, header http.Header) bool {
+	for name, values := range vary {
+		if strings.Join(header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// freshnessExpiry returns the end of the freshness lifetime of a
+// response with the given header received at now, from its max-age
+// directive or, failing that, its Expires header. Responses with a
+// no-cache directive or without freshness information are stale
+// right away.
+func freshnessExpiry(header http.Header, now time.Time) time.Time {
+	cc := parseCacheControl(header)
+	if cc.has("no-cache") {
+		return now
+	}
+
+	var lifetime time.Duration
+	if v, ok := cc["max-age"]; ok {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return now
+		}
+
+		lifetime = time.Duration(seconds) * time.Second
+	} else if v := header.Get("expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return now
+		}
+
+		date := now
+		if d, err := http.ParseTime(header.Get("date")); err == nil {
+			date = d
+		}
+
+		lifetime = expires.Sub(date)
+	}
+
+	if age, err := strconv.ParseInt(header.Get("age"), 10, 64); err == nil {
+		lifetime -= time.Duration(age) * time.Second
+	}
+
+	return now.Add(lifetime)
+}
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := make(cacheControl)
+
+	for _, field := range header.Values("cache-control") {
+		for _, directive := range strings.Split(field, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.gearno.de/x/panicf"
+)
+
+type (
+	// CacheStore stores the responses cached by the transports using
+	// WithCache, keyed by request. Implementations must be safe for
+	// concurrent use, and must not modify the responses they are
+	// given nor the ones they return.
+	CacheStore interface {
+		Get(key string) (*CachedResponse, bool)
+		Set(key string, resp *CachedResponse)
+		Delete(key string)
+	}
+
+	// CachedResponse is a response stored in a CacheStore.
+	CachedResponse struct {
+		StatusCode int
+		Header     http.Header
+		Body       []byte
+
+		// Vary holds the values of the request headers listed by
+		// the Vary response header, the cached response only
+		// being used for requests with the same values.
+		Vary http.Header
+
+		// ExpiresAt is the end of the freshness lifetime of the
+		// response, after which it must be revalidated.
+		ExpiresAt time.Time
+	}
+
+	// MemoryCacheStore is a CacheStore keeping the responses in
+	// memory, evicting the least recently used ones once their total
+	// size exceeds its bound.
+	MemoryCacheStore struct {
+		mu       sync.Mutex
+		maxBytes int64
+		size     int64
+		entries  map[string]*list.Element
+		lru      *list.List
+	}
+
+	memoryCacheEntry struct {
+		key  string
+		resp *CachedResponse
+		size int64
+	}
+)
+
+var (
+	_ CacheStore = (*MemoryCacheStore)(nil)
+)
+
+// NewMemoryCacheStore returns a MemoryCacheStore holding at most
+// maxBytes of response bodies and headers. Responses larger than
+// maxBytes are not stored. It panics when maxBytes is not positive.
+func NewMemoryCacheStore(maxBytes int64) *MemoryCacheStore {
+	if maxBytes <= 0 {
+		panicf.Panic("invalid cache size %d: must be positive", maxBytes)
+	}
+
+	return &MemoryCacheStore{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get returns the response stored for key, if any.
+func (s *MemoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	s.lru.MoveToFront(e)
+
+	return e.Value.(*memoryCacheEntry).resp, true
+}
+
+// Set stores resp for key, replacing the response already stored for
+// it, and evicts the least recently used responses to stay within the
+// size bound.
+func (s *MemoryCacheStore) Set(key string, resp *CachedResponse) {
+	size := cachedResponseSize(key, resp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(key)
+
+	if size > s.maxBytes {
+		return
+	}
+
+	s.entries[key] = s.lru.PushFront(&memoryCacheEntry{key: key, resp: resp, size: size})
+	s.size += size
+
+	for s.size > s.maxBytes {
+		s.delete(s.lru.Back().Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Delete removes the response stored for key, if any.
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(key)
+}
+
+func (s *MemoryCacheStore) delete(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+
+	s.lru.Remove(e)
+	delete(s.entries, key)
+	s.size -= e.Value.(*memoryCacheEntry).size
+}
+
+func cachedResponseSize(key string, resp *CachedResponse) int64 {
+	size := len(key) + len(resp.Body)
+
+	for _, h := range []http.Header{resp.Header, resp.Vary} {
+		for k, values := range h {
+			for _, v := range values {
+				size += len(k) + len(v)
+			}
+		}
+	}
+
+	return int64(size)
+}
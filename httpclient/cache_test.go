@@ -0,0 +1,307 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	type request struct {
+		method      string
+		ifNoneMatch string
+	}
+
+	newServer := func(t *testing.T, h http.HandlerFunc) (*httptest.Server, *[]request) {
+		t.Helper()
+
+		var requests []request
+		server := httptest.NewServer(
+			http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					requests = append(requests, request{r.Method, r.Header.Get("if-none-match")})
+					h(w, r)
+				},
+			),
+		)
+		t.Cleanup(server.Close)
+
+		return server, &requests
+	}
+
+	newTransport := func(t *testing.T) (*cacheRoundTripper, *time.Time) {
+		t.Helper()
+
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		rt := newCacheRoundTripper(http.DefaultTransport, NewMemoryCacheStore(1<<20), 16, prometheus.NewRegistry())
+		rt.now = func() time.Time { return now }
+
+		return rt, &now
+	}
+
+	get := func(t *testing.T, rt http.RoundTripper, url string, header ...string) (int, string) {
+		t.Helper()
+
+		r, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+
+		resp, err := rt.RoundTrip(r)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	results := func(rt *cacheRoundTripper, host string) map[string]float64 {
+		m := make(map[string]float64)
+		for _, result := range []string{cacheResultHit, cacheResultMiss, cacheResultRevalidated} {
+			m[result] = testutil.ToFloat64(rt.requestsTotal.WithLabelValues(host, result))
+		}
+
+		return m
+	}
+
+	t.Run("fresh and revalidated", func(t *testing.T) {
+		server, requests := newServer(
+			t,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("cache-control", "max-age=60")
+				w.Header().Set("etag", `"v1"`)
+
+				if r.Header.Get("if-none-match") == `"v1"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				w.Write([]byte("hello"))
+			},
+		)
+
+		rt, now := newTransport(t)
+
+		for range 2 {
+			code, body := get(t, rt, server.URL)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, "hello", body)
+		}
+		assert.Equal(t, []request{{http.MethodGet, ""}}, *requests)
+
+		*now = now.Add(2 * time.Minute)
+
+		code, body := get(t, rt, server.URL)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "hello", body)
+		assert.Equal(t, request{http.MethodGet, `"v1"`}, (*requests)[1])
+
+		// The revalidation refreshed the response.
+		get(t, rt, server.URL)
+		assert.Len(t, *requests, 2)
+
+		host := server.Listener.Addr().String()
+		assert.Equal(
+			t,
+			map[string]float64{cacheResultHit: 2, cacheResultMiss: 1, cacheResultRevalidated: 1},
+			results(rt, host),
+		)
+	})
+
+	t.Run("not cacheable", func(t *testing.T) {
+		server, requests := newServer(
+			t,
+			func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/no-store":
+					w.Header().Set("cache-control", "no-store, max-age=60")
+				case "/error":
+					w.Header().Set("cache-control", "max-age=60")
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+
+				w.Write([]byte("hello"))
+			},
+		)
+
+		rt, _ := newTransport(t)
+
+		for _, path := range []string{"/no-store", "/no-store", "/none", "/none", "/error", "/error"} {
+			get(t, rt, server.URL+path)
+		}
+		get(t, rt, server.URL+"/none", "cache-control", "no-store")
+
+		assert.Len(t, *requests, 7)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		large := strings.Repeat("a", 32)
+
+		server, requests := newServer(
+			t,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("cache-control", "max-age=60")
+
+				// Flushing before writing the body sends it
+				// chunked, without a Content-Length.
+				if r.URL.Path == "/chunked" {
+					w.(http.Flusher).Flush()
+				}
+
+				w.Write([]byte(large))
+			},
+		)
+
+		rt, _ := newTransport(t)
+
+		for _, path := range []string{"/length", "/length", "/chunked", "/chunked"} {
+			_, body := get(t, rt, server.URL+path)
+			assert.Equal(t, large, body)
+		}
+
+		assert.Len(t, *requests, 4)
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		server, requests := newServer(
+			t,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("cache-control", "max-age=60")
+				w.Header().Set("vary", "Accept-Language")
+				w.Write([]byte(r.Header.Get("accept-language")))
+			},
+		)
+
+		rt, _ := newTransport(t)
+
+		_, body := get(t, rt, server.URL, "accept-language", "fr")
+		assert.Equal(t, "fr", body)
+		_, body = get(t, rt, server.URL, "accept-language", "en")
+		assert.Equal(t, "en", body)
+		_, body = get(t, rt, server.URL, "accept-language", "en")
+		assert.Equal(t, "en", body)
+
+		assert.Len(t, *requests, 2)
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		server, requests := newServer(
+			t,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("cache-control", "max-age=60")
+				w.Write([]byte("hello"))
+			},
+		)
+
+		rt, _ := newTransport(t)
+
+		get(t, rt, server.URL)
+		get(t, rt, server.URL)
+
+		r, err := http.NewRequest(http.MethodPost, server.URL, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(r)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		get(t, rt, server.URL)
+
+		assert.Equal(
+			t,
+			[]request{{http.MethodGet, ""}, {http.MethodPost, ""}, {http.MethodGet, ""}},
+			*requests,
+		)
+	})
+}
+
+func TestWithCache(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("cache-control", "max-age=60")
+				w.Write([]byte("hello"))
+			},
+		),
+	)
+	defer server.Close()
+
+	client := DefaultPooledClient(
+		WithRegisterer(prometheus.NewRegistry()),
+		WithCache(NewMemoryCacheStore(1<<20)),
+	)
+
+	for range 3 {
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+	}
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	s := NewMemoryCacheStore(100)
+
+	entry := func(size int) *CachedResponse {
+		return &CachedResponse{StatusCode: http.StatusOK, Body: make([]byte, size)}
+	}
+
+	s.Set("a", entry(40))
+	s.Set("b", entry(40))
+
+	// Reading a makes b the least recently used entry.
+	_, ok := s.Get("a")
+	assert.True(t, ok)
+
+	s.Set("c", entry(40))
+
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+	_, ok = s.Get("a")
+	assert.True(t, ok)
+	_, ok = s.Get("c")
+	assert.True(t, ok)
+
+	// Entries larger than the store are not stored.
+	s.Set("d", entry(200))
+	_, ok = s.Get("d")
+	assert.False(t, ok)
+
+	s.Delete("a")
+	_, ok = s.Get("a")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { NewMemoryCacheStore(0) })
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.gearno.de/x/panicf"
+)
+
+type (
+	compressionRoundTripper struct {
+		minBytes int
+		next     http.RoundTripper
+	}
+)
+
+var (
+	_ http.RoundTripper = (*compressionRoundTripper)(nil)
+)
+
+func newCompressionRoundTripper(next http.RoundTripper, minBytes int) *compressionRoundTripper {
+	if minBytes < 0 {
+		panicf.Panic("invalid request compression threshold %d: must not be negative", minBytes)
+	}
+
+	return &compressionRoundTripper{
+		minBytes: minBytes,
+		next:     next,
+	}
+}
+
+// RoundTrip gzip compresses the body of the requests which are at
+// least minBytes long and do not already have a Content-Encoding.
+func (rt *compressionRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.Header.Get("content-encoding") != "" {
+		return rt.next.RoundTrip(r)
+	}
+
+	// A zero ContentLength with a body means the length is unknown.
+	if r.ContentLength > 0 && r.ContentLength < int64(rt.minBytes) {
+		return rt.next.RoundTrip(r)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read request body: %w", err)
+	}
+
+	r2 := r.Clone(r.Context())
+	if r2.Header == nil {
+		r2.Header = make(http.Header)
+	}
+
+	if len(body) < rt.minBytes {
+		// The length was unknown and turns out to be below the
+		// threshold: send the body as read.
+		r2.Body = io.NopCloser(bytes.NewReader(body))
+		r2.ContentLength = int64(len(body))
+		return rt.next.RoundTrip(r2)
+	}
+
+	compressed, err := gzipCompress(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compress request body: %w", err)
+	}
+
+	r2.Body = io.NopCloser(bytes.NewReader(compressed))
+	r2.ContentLength = int64(len(compressed))
+	r2.Header.Set("content-encoding", "gzip")
+	r2.Header.Del("content-length")
+
+	// The transport may send the request again, for instance on a
+	// connection closed by the server: compress the original body
+	// again rather than keeping a copy of the compressed one.
+	r2.GetBody = nil
+	if r.GetBody != nil {
+		r2.GetBody = func() (io.ReadCloser, error) {
+			rc, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+
+			body, err := io.ReadAll(rc)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read request body: %w", err)
+			}
+
+			compressed, err := gzipCompress(body)
+			if err != nil {
+				return nil, fmt.Errorf("cannot compress request body: %w", err)
+			}
+
+			return io.NopCloser(bytes.NewReader(compressed)), nil
+		}
+	}
+
+	return rt.next.RoundTrip(r2)
+}
+
+func gzipCompress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
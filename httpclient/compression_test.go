@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureRoundTripper struct {
+	request *http.Request
+}
+
+func (rt *captureRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.request = r
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+func gunzip(t *testing.T, r io.Reader) string {
+	t.Helper()
+
+	zr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+
+	b, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestRequestCompression(t *testing.T) {
+	type received struct {
+		encoding      string
+		contentLength int64
+		body          string
+	}
+
+	var got received
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				got = received{
+					encoding:      r.Header.Get("content-encoding"),
+					contentLength: r.ContentLength,
+				}
+
+				if got.encoding == "gzip" {
+					got.body = gunzip(t, r.Body)
+				} else {
+					b, _ := io.ReadAll(r.Body)
+					got.body = string(b)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	client := DefaultClient(
+		WithRegisterer(prometheus.NewRegistry()),
+		WithRequestCompression(64),
+	)
+
+	large := strings.Repeat(`{"name":"kit"}`, 100)
+
+	t.Run("above threshold", func(t *testing.T) {
+		resp, err := client.Post(server.URL, "application/json", strings.NewReader(large))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "gzip", got.encoding)
+		assert.Equal(t, large, got.body)
+		assert.Less(t, got.contentLength, int64(len(large)))
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		resp, err := client.Post(server.URL, "application/json", io.NopCloser(strings.NewReader(large)))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "gzip", got.encoding)
+		assert.Equal(t, large, got.body)
+	})
+
+	t.Run("below threshold", func(t *testing.T) {
+		resp, err := client.Post(server.URL, "application/json", io.NopCloser(strings.NewReader("{}")))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Empty(t, got.encoding)
+		assert.Equal(t, "{}", got.body)
+		assert.Equal(t, int64(2), got.contentLength)
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(large))
+		require.NoError(t, err)
+		req.Header.Set("content-encoding", "identity")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "identity", got.encoding)
+		assert.Equal(t, large, got.body)
+	})
+}
+
+func TestRequestCompressionGetBody(t *testing.T) {
+	next := &captureRoundTripper{}
+	rt := newCompressionRoundTripper(next, 0)
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	sent := next.request
+	require.NotNil(t, sent.GetBody)
+	assert.Equal(t, "hello", gunzip(t, sent.Body))
+
+	body, err := sent.GetBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", gunzip(t, body))
+
+	assert.Panics(t, func() { newCompressionRoundTripper(next, -1) })
+}
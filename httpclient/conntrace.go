@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.gearno.de/x/panicf"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// connectionTracer measures the phases of a request, DNS
+	// resolution, connection, TLS handshake and time to first
+	// response byte, recording them as events of the request span
+	// and in the connection phase duration histogram.
+	connectionTracer struct {
+		span          trace.Span
+		host          string
+		start         time.Time
+		phaseDuration *prometheus.HistogramVec
+
+		mu           sync.Mutex
+		dnsStart     time.Time
+		connectStart map[string]time.Time
+		tlsStart     time.Time
+	}
+)
+
+const (
+	connectionPhaseDNS       = "dns"
+	connectionPhaseConnect   = "connect"
+	connectionPhaseTLS       = "tls"
+	connectionPhaseFirstByte = "first_byte"
+)
+
+func newConnectionPhaseDuration(registerer prometheus.Registerer, buckets []float64) *prometheus.HistogramVec {
+	phaseDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "http_client",
+			Name:      "connection_phase_duration_seconds",
+			Help:      "Duration of the phases of HTTP requests in seconds: dns, connect, tls and first_byte.",
+			Buckets:   buckets,
+		},
+		[]string{"host", "phase"},
+	)
+
+	if err := registerer.Register(phaseDuration); err != nil {
+		are := &prometheus.AlreadyRegisteredError{}
+		if errors.As(err, are) {
+			phaseDuration = are.ExistingCollector.(*prometheus.HistogramVec)
+		} else {
+			panicf.Panic(
+				"cannot register %q prometheus metrics: %w",
+				"http_client_connection_phase_duration_seconds",
+				err,
+			)
+		}
+	}
+
+	return phaseDuration
+}
+
+func newConnectionTracer(
+	span trace.Span,
+	host string,
+	start time.Time,
+	phaseDuration *prometheus.HistogramVec,
+) *connectionTracer {
+	return &connectionTracer{
+		span:          span,
+		host:          host,
+		start:         start,
+		phaseDuration: phaseDuration,
+		connectStart:  make(map[string]time.Time),
+	}
+}
+
+// clientTrace returns the hooks measuring the phases. The connect
+// hooks may be called concurrently when dialing several addresses.
+func (ct *connectionTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+
+			ct.dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			ct.mu.Lock()
+			start := ct.dnsStart
+			ct.mu.Unlock()
+
+			ct.record(connectionPhaseDNS, start, info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+
+			ct.connectStart[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.mu.Lock()
+			start := ct.connectStart[network+" "+addr]
+			ct.mu.Unlock()
+
+			ct.record(connectionPhaseConnect, start, err, attribute.String("net.peer.addr", addr))
+		},
+		TLSHandshakeStart: func() {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+
+			ct.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			ct.mu.Lock()
+			start := ct.tlsStart
+			ct.mu.Unlock()
+
+			ct.record(connectionPhaseTLS, start, err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if ct.span != nil {
+				ct.span.SetAttributes(attribute.Bool("http.connection.reused", info.Reused))
+			}
+		},
+		GotFirstResponseByte: func() {
+			ct.record(connectionPhaseFirstByte, ct.start, nil)
+		},
+	}
+}
+
+// record records the duration of phase, from start until now, unless
+// the start of the phase was not seen.
+func (ct *connectionTracer) record(phase string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	if start.IsZero() {
+		return
+	}
+
+	var (
+		now      = time.Now()
+		duration = now.Sub(start)
+	)
+
+	ct.phaseDuration.
+		With(prometheus.Labels{"host": ct.host, "phase": phase}).
+		Observe(duration.Seconds())
+
+	if ct.span == nil {
+		return
+	}
+
+	attrs = append(
+		attrs,
+		attribute.Float64("duration_ms", float64(duration)/float64(time.Millisecond)),
+	)
+
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", err.Error()))
+	}
+
+	ct.span.AddEvent(phase, trace.WithTimestamp(now), trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+const (
+	errorTypeTimeout     = "timeout"
+	errorTypeDNS         = "dns"
+	errorTypeConnRefused = "connrefused"
+	errorTypeTLS         = "tls"
+	errorTypeCanceled    = "canceled"
+	errorTypeHedgeLost   = "hedge_lost"
+	errorTypeOther       = "other"
+)
+
+// errorType classifies the error of a failed round trip, so failures
+// can be told apart in aggregate: timeout, dns, connrefused, tls,
+// canceled or other.
+func errorType(err error) string {
+	var (
+		dnsErr              *net.DNSError
+		recordHeaderErr     tls.RecordHeaderError
+		alertErr            tls.AlertError
+		certVerifyErr       *tls.CertificateVerificationError
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		certInvalidErr      x509.CertificateInvalidError
+		netErr              net.Error
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorTypeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorTypeTimeout
+	case errors.As(err, &dnsErr):
+		return errorTypeDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorTypeConnRefused
+	case errors.As(err, &recordHeaderErr),
+		errors.As(err, &alertErr),
+		errors.As(err, &certVerifyErr),
+		errors.As(err, &unknownAuthorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certInvalidErr):
+		return errorTypeTLS
+	case os.IsTimeout(err), errors.As(err, &netErr) && netErr.Timeout():
+		return errorTypeTimeout
+	default:
+		return errorTypeOther
+	}
+}
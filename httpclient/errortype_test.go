@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{context.Canceled, errorTypeCanceled},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), errorTypeTimeout},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{Err: "no such host", Name: "x", IsNotFound: true}}, errorTypeDNS},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, errorTypeConnRefused},
+		{&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, errorTypeTLS},
+		{x509.HostnameError{Host: "x"}, errorTypeTLS},
+		{tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, errorTypeTLS},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, errorTypeTimeout},
+		{errors.New("boom"), errorTypeOther},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, errorType(tt.err), "%v", tt.err)
+	}
+}
+
+func TestRoundTripErrorType(t *testing.T) {
+	// A closed listener gives an address refusing connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusedAddr := l.Addr().String()
+	l.Close()
+
+	slow := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+		),
+	)
+	defer slow.Close()
+
+	var (
+		registry = prometheus.NewRegistry()
+		recorder = tracetest.NewSpanRecorder()
+		tp       = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		client   = DefaultClient(WithRegisterer(registry), WithTracerProvider(tp))
+	)
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+refusedAddr, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	req, err = http.NewRequestWithContext(timeoutCtx, http.MethodGet, slow.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+
+	root.End()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	errorTypes := map[string]string{}
+	for _, family := range families {
+		if family.GetName() != "http_client_requests_total" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			assert.Empty(t, labels["status_code"])
+			errorTypes[labels["host"]] = labels["error_type"]
+		}
+	}
+	assert.Equal(
+		t,
+		map[string]string{
+			refusedAddr:                   errorTypeConnRefused,
+			slow.Listener.Addr().String(): errorTypeTimeout,
+		},
+		errorTypes,
+	)
+
+	count, err := testutil.GatherAndCount(registry, "http_client_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	var spanErrorTypes []string
+	for _, span := range recorder.Ended() {
+		if span.SpanKind() != trace.SpanKindClient {
+			continue
+		}
+
+		for _, attr := range span.Attributes() {
+			if attr.Key == semconv.ErrorTypeKey {
+				spanErrorTypes = append(spanErrorTypes, attr.Value.AsString())
+			}
+		}
+	}
+	assert.Equal(t, []string{errorTypeConnRefused, errorTypeTimeout}, spanErrorTypes)
+}
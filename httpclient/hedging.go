@@ -0,0 +1,269 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.gearno.de/kit/internal/version"
+	"go.gearno.de/x/panicf"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// HedgeConfig configures hedged requests, see WithHedging.
+	HedgeConfig struct {
+		// Delay is the time to wait for a response before
+		// sending another attempt.
+		Delay time.Duration
+
+		// MaxAttempts is the maximum number of attempts sent
+		// for a request, including the first one. It defaults to
+		// 2.
+		MaxAttempts int
+
+		// Methods are the methods of the hedged requests. They
+		// must be idempotent and default to GET and HEAD.
+		Methods []string
+	}
+
+	hedgingRoundTripper struct {
+		delay       time.Duration
+		maxAttempts int
+		methods     []string
+		tracer      trace.Tracer
+		next        http.RoundTripper
+	}
+
+	hedgeResult struct {
+		attempt int
+		resp    *http.Response
+		err     error
+	}
+
+	cancelBody struct {
+		io.ReadCloser
+		cancel context.CancelCauseFunc
+	}
+)
+
+var (
+	_ http.RoundTripper = (*hedgingRoundTripper)(nil)
+
+	// errHedgeLost is the cause of the cancellation of the attempts
+	// losing to another one, telling them apart from the attempts of
+	// cancelled requests.
+	errHedgeLost = errors.New("another hedged attempt won")
+
+	idempotentMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete,
+	}
+)
+
+func newHedgingRoundTripper(next http.RoundTripper, cfg HedgeConfig, tp trace.TracerProvider) *hedgingRoundTripper {
+	if cfg.Delay <= 0 {
+		panicf.Panic("cannot hedge requests: invalid delay %s", cfg.Delay)
+	}
+
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 2
+	}
+
+	if maxAttempts < 1 {
+		panicf.Panic("cannot hedge requests: invalid max attempts %d", maxAttempts)
+	}
+
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	for _, method := range methods {
+		if !slices.Contains(idempotentMethods, method) {
+			panicf.Panic("cannot hedge requests: %s is not idempotent", method)
+		}
+	}
+
+	return &hedgingRoundTripper{
+		delay:       cfg.Delay,
+		maxAttempts: maxAttempts,
+		methods:     methods,
+		tracer: tp.Tracer(
+			tracerName,
+			trace.WithInstrumentationVersion(
+				version.New(0).Alpha(1),
+			),
+		),
+		next: next,
+	}
+}
+
+// RoundTrip sends the request, then another attempt each time the
+// delay elapses without response, up to the maximum number of
+// attempts. The first response is returned and the other attempts are
+// cancelled. An attempt failing before the delay elapses starts the
+// next one immediately; the last error is returned when all attempts
+// fail.
+func (rt *hedgingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !rt.hedgeable(r) {
+		return rt.next.RoundTrip(r)
+	}
+
+	var (
+		results = make(chan hedgeResult, rt.maxAttempts)
+		cancels = make([]context.CancelCauseFunc, 0, rt.maxAttempts)
+		timer   = time.NewTimer(rt.delay)
+		pending = 0
+		lastErr error
+	)
+	defer timer.Stop()
+
+	launch := func() {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		cancels = append(cancels, cancel)
+		pending++
+
+		go rt.attempt(ctx, r, len(cancels), results)
+	}
+
+	launch()
+
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) < rt.maxAttempts {
+				launch()
+				timer.Reset(rt.delay)
+			}
+
+		case res := <-results:
+			pending--
+
+			if res.err != nil {
+				cancels[res.attempt-1](nil)
+				lastErr = res.err
+
+				if pending > 0 {
+					continue
+				}
+
+				if len(cancels) == rt.maxAttempts || r.Context().Err() != nil {
+					return nil, lastErr
+				}
+
+				launch()
+				timer.Reset(rt.delay)
+				continue
+			}
+
+			for i, cancel := range cancels {
+				if i != res.attempt-1 {
+					cancel(errHedgeLost)
+				}
+			}
+
+			// The cancelled attempts return shortly, their
+			// responses are discarded so their connections
+			// are released.
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if loser := <-results; loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}
+			}(pending)
+
+			res.resp.Body = &cancelBody{res.resp.Body, cancels[res.attempt-1]}
+
+			return res.resp, nil
+		}
+	}
+}
+
+func (rt *hedgingRoundTripper) hedgeable(r *http.Request) bool {
+	if !slices.Contains(rt.methods, r.Method) {
+		return false
+	}
+
+	// A request body can only be sent again if it can be
+	// obtained again.
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+func (rt *hedgingRoundTripper) attempt(
+	ctx context.Context,
+	r *http.Request,
+	attempt int,
+	results chan<- hedgeResult,
+) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = rt.tracer.Start(
+			ctx,
+			"HedgedAttempt",
+			trace.WithAttributes(
+				attribute.Int("http.hedge.attempt", attempt),
+			),
+		)
+		defer span.End()
+	}
+
+	r2 := r.Clone(ctx)
+	if attempt > 1 && r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			results <- hedgeResult{attempt: attempt, err: fmt.Errorf("cannot get request body: %w", err)}
+			return
+		}
+
+		r2.Body = body
+	}
+
+	resp, err := rt.next.RoundTrip(r2)
+	if err != nil && rootSpan.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	results <- hedgeResult{attempt: attempt, resp: resp, err: err}
+}
+
+// Close closes the response body and releases the context of the
+// attempt which produced it.
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type hedgeFunc func(r *http.Request, attempt int32) (*http.Response, error)
+
+func newTestHedgingRoundTripper(cfg HedgeConfig, f hedgeFunc) (*hedgingRoundTripper, *atomic.Int32) {
+	var attempts atomic.Int32
+
+	next := roundTripperFunc(
+		func(r *http.Request) (*http.Response, error) {
+			return f(r, attempts.Add(1))
+		},
+	)
+
+	return newHedgingRoundTripper(next, cfg, noop.NewTracerProvider()), &attempts
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func newTestResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestHedging(t *testing.T) {
+	t.Run("second attempt wins", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		rt, attempts := newTestHedgingRoundTripper(
+			HedgeConfig{Delay: 10 * time.Millisecond},
+			func(r *http.Request, attempt int32) (*http.Response, error) {
+				if attempt == 1 {
+					<-r.Context().Done()
+					close(cancelled)
+					return nil, r.Context().Err()
+				}
+
+				return newTestResponse(strconv.Itoa(int(attempt))), nil
+			},
+		)
+
+		resp, err := rt.RoundTrip(mustNewRequest(t, http.MethodGet, nil))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "2", string(body))
+		assert.Equal(t, int32(2), attempts.Load())
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("the losing attempt was not cancelled")
+		}
+	})
+
+	t.Run("fast response", func(t *testing.T) {
+		rt, attempts := newTestHedgingRoundTripper(
+			HedgeConfig{Delay: time.Second},
+			func(r *http.Request, attempt int32) (*http.Response, error) {
+				return newTestResponse("ok"), nil
+			},
+		)
+
+		resp, err := rt.RoundTrip(mustNewRequest(t, http.MethodGet, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("failed attempts", func(t *testing.T) {
+		rt, attempts := newTestHedgingRoundTripper(
+			HedgeConfig{Delay: time.Second, MaxAttempts: 3},
+			func(r *http.Request, attempt int32) (*http.Response, error) {
+				return nil, errors.New("connection refused")
+			},
+		)
+
+		_, err := rt.RoundTrip(mustNewRequest(t, http.MethodGet, nil))
+		assert.EqualError(t, err, "connection refused")
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("body replayed", func(t *testing.T) {
+		rt, _ := newTestHedgingRoundTripper(
+			HedgeConfig{Delay: 10 * time.Millisecond, Methods: []string{http.MethodPut}},
+			func(r *http.Request, attempt int32) (*http.Response, error) {
+				if attempt == 1 {
+					<-r.Context().Done()
+					return nil, r.Context().Err()
+				}
+
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					return nil, err
+				}
+
+				return newTestResponse(string(body)), nil
+			},
+		)
+
+		resp, err := rt.RoundTrip(mustNewRequest(t, http.MethodPut, []byte("payload")))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(body))
+	})
+
+	t.Run("method not hedged", func(t *testing.T) {
+		rt, attempts := newTestHedgingRoundTripper(
+			HedgeConfig{Delay: time.Millisecond},
+			func(r *http.Request, attempt int32) (*http.Response, error) {
+				time.Sleep(20 * time.Millisecond)
+				return newTestResponse("ok"), nil
+			},
+		)
+
+		resp, err := rt.RoundTrip(mustNewRequest(t, http.MethodPost, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("non idempotent method", func(t *testing.T) {
+		assert.Panics(
+			t,
+			func() {
+				DefaultClient(WithHedging(HedgeConfig{Delay: time.Millisecond, Methods: []string{http.MethodPost}}))
+			},
+		)
+	})
+}
+
+func mustNewRequest(t *testing.T, method string, body []byte) *http.Request {
+	t.Helper()
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, "http://example.com/", r)
+	require.NoError(t, err)
+
+	return req
+}
+
+func TestHedgingTelemetry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					<-r.Context().Done()
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+	)
+	defer server.Close()
+
+	var (
+		logs     bytes.Buffer
+		registry = prometheus.NewRegistry()
+		client   = DefaultClient(
+			WithRegisterer(registry),
+			WithLogger(log.NewLogger(log.WithOutput(&logs), log.WithLevel(log.LevelWarn))),
+			WithHedging(HedgeConfig{Delay: 10 * time.Millisecond}),
+		)
+	)
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	errorTypes := func() map[string]float64 {
+		families, err := registry.Gather()
+		require.NoError(t, err)
+
+		m := make(map[string]float64)
+		for _, family := range families {
+			if family.GetName() != "http_client_requests_total" {
+				continue
+			}
+
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "error_type" {
+						m[label.GetValue()] += metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+
+		return m
+	}
+
+	// The losing attempt is counted once it is cancelled, after
+	// the response of the winning one is returned.
+	assert.Eventually(
+		t,
+		func() bool { return errorTypes()[errorTypeHedgeLost] == 1 },
+		time.Second,
+		10*time.Millisecond,
+	)
+	assert.Equal(t, map[string]float64{"": 1, errorTypeHedgeLost: 1}, errorTypes())
+	assert.Empty(t, logs.String())
+}
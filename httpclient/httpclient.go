@@ -18,13 +18,13 @@ package httpclient
 
 import (
 	"crypto/tls"
-	"io"
 	"net"
 	"net/http"
 	"runtime"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.gearno.de/kit/internal/version"
 	"go.gearno.de/kit/log"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
@@ -40,6 +40,22 @@ type (
 	// configurations.
 	Options struct {
 		tlsConfig *tls.Config
+		userAgent string
+
+		idempotencyKeyHeader  string
+		idempotencyKeyMethods []string
+
+		urlTemplateFunc   func(*http.Request) string
+		durationBuckets   []float64
+		connectionTracing bool
+
+		hedge *HedgeConfig
+
+		compressionMinBytes int
+		compression         bool
+
+		cacheStore        CacheStore
+		cacheMaxBodyBytes int64
 
 		tracerProvider trace.TracerProvider
 		logger         *log.Logger
@@ -49,6 +65,14 @@ type (
 
 const (
 	tracerName = "go.gearno.de/kit/httpclient"
+
+	defaultCacheMaxBodyBytes = 1 << 20
+)
+
+var (
+	// DefaultUserAgent is the User-Agent header sent by the
+	// transports of this package unless WithUserAgent is used.
+	DefaultUserAgent = "kit-httpclient/" + version.New(0).Alpha(1)
 )
 
 // WithTLSConfig is an option setter for setting TLS configurations on
@@ -59,6 +83,138 @@ func WithTLSConfig(c *tls.Config) Option {
 	}
 }
 
+// WithUserAgent is an option setter for the User-Agent header sent
+// with requests which do not already set one. It defaults to
+// DefaultUserAgent.
+func WithUserAgent(ua string) Option {
+	return func(o *Options) {
+		o.userAgent = ua
+	}
+}
+
+// WithIdempotencyKey is an option setter generating an idempotency
+// key, a UUID sent in the given header, for the requests using one of
+// the given methods, POST and PATCH by default. Requests already
+// carrying the header are left untouched.
+//
+// The key is generated once per request before it enters the
+// transport chain, so retries performed within the transport reuse
+// it. A request sent again by the caller gets a new key unless the
+// caller sets the header itself.
+func WithIdempotencyKey(header string, methods ...string) Option {
+	return func(o *Options) {
+		o.idempotencyKeyHeader = header
+		o.idempotencyKeyMethods = methods
+	}
+}
+
+// WithURLTemplateFunc is an option setter for the function mapping a
+// request to its URL template, e.g. "/users/{id}" for "/users/123",
+// used as the path label of the metrics. It must return a bounded set
+// of values. By default, the path label is empty.
+func WithURLTemplateFunc(f func(*http.Request) string) Option {
+	return func(o *Options) {
+		o.urlTemplateFunc = f
+	}
+}
+
+// WithDurationBuckets is an option setter for the buckets of the
+// request duration histogram, in seconds. It defaults to
+// prometheus.DefBuckets. The buckets are set when the histogram is
+// registered, transports sharing a registerer share the buckets of the
+// first one created.
+func WithDurationBuckets(buckets []float64) Option {
+	return func(o *Options) {
+		o.durationBuckets = buckets
+	}
+}
+
+// WithConnectionTracing measures the phases of each request: the DNS
+// resolution, the connection, the TLS handshake and the time to the
+// first response byte. They are recorded as events of the request
+// span, and observed in the http_client_connection_phase_duration_seconds
+// histogram labeled with the host and the phase. Phases skipped
+// because the connection is reused are not recorded.
+func WithConnectionTracing() Option {
+	return func(o *Options) {
+		o.connectionTracing = true
+	}
+}
+
+// WithHedging is an option setter sending hedged requests to reduce
+// tail latency: when no response is received within the configured
+// delay, another attempt of the request is sent, and the first
+// response wins while the other attempts are cancelled. Each attempt
+// is traced as its own span, child of the request span. The cancelled
+// attempts are not reported as failures: they are logged at the debug
+// level and counted in http_client_requests_total with the hedge_lost
+// error type.
+//
+// Only requests using one of the configured methods, which must be
+// idempotent, and whose body can be obtained again through
+// http.Request.GetBody are hedged. It panics when the configuration is
+// invalid.
+func WithHedging(cfg HedgeConfig) Option {
+	return func(o *Options) {
+		o.hedge = &cfg
+	}
+}
+
+// WithRequestCompression is an option setter gzip compressing the
+// body of the requests at least minBytes long, setting the
+// Content-Encoding header and the length of the compressed body.
+// Requests already carrying a Content-Encoding header are sent as is.
+// The server must accept gzip encoded request bodies.
+//
+// The body is compressed for each attempt, including the hedged ones,
+// and the transport compresses it again from http.Request.GetBody when
+// it must resend the request. It panics when minBytes is negative.
+func WithRequestCompression(minBytes int) Option {
+	return func(o *Options) {
+		o.compression = true
+		o.compressionMinBytes = minBytes
+	}
+}
+
+// WithCache is an option setter caching the responses to GET requests
+// in store, following a subset of the HTTP caching rules of RFC 9111
+// for a private cache. Responses with a 200 status code are cached
+// unless their Cache-Control header has the no-store directive. They
+// are served from the cache while fresh, according to the max-age
+// directive or the Expires header. Once stale, they are revalidated
+// with a conditional request using their ETag or Last-Modified header.
+// The Vary header is honored, and requests with an unsafe method
+// invalidate the cached response of their URL.
+//
+// Responses with a body larger than 1 MiB, unless changed with
+// WithCacheMaxBodySize, are not cached. Their body is streamed to the
+// caller rather than buffered in memory.
+//
+// Cache hits do not reach the server and are not counted in the
+// request metrics, but in http_client_cache_requests_total, labeled
+// with the cache result: hit, miss or revalidated.
+//
+// Example:
+//
+//	client := httpclient.DefaultPooledClient(
+//	    httpclient.WithCache(httpclient.NewMemoryCacheStore(64 << 20)),
+//	)
+func WithCache(store CacheStore) Option {
+	return func(o *Options) {
+		o.cacheStore = store
+	}
+}
+
+// WithCacheMaxBodySize is an option setter for the size, in bytes, of
+// the largest response body cached by WithCache. It defaults to 1 MiB.
+// The transports buffer at most maxBytes of a response body in memory
+// to cache it. It panics when maxBytes is not positive.
+func WithCacheMaxBodySize(maxBytes int64) Option {
+	return func(o *Options) {
+		o.cacheMaxBodyBytes = maxBytes
+	}
+}
+
 // WithLogger is an option setter for specifying a logger for HTTP
 // telemetry and error logging.
 func WithLogger(l *log.Logger) Option {
@@ -93,7 +249,7 @@ func DefaultTransport(options ...Option) http.RoundTripper {
 	transport.MaxIdleConnsPerHost = -1
 	transport.TLSClientConfig = opts.tlsConfig
 
-	return NewTelemetryRoundTripper(transport, opts.logger, opts.tracerProvider, opts.registerer)
+	return wrapTransport(transport, opts)
 }
 
 // DefaultPooledTransport returns a new http.Transport with similar
@@ -108,7 +264,7 @@ func DefaultPooledTransport(options ...Option) http.RoundTripper {
 	transport.MaxIdleConnsPerHost = runtime.GOMAXPROCS(0) + 1
 	transport.TLSClientConfig = opts.tlsConfig
 
-	return NewTelemetryRoundTripper(transport, opts.logger, opts.tracerProvider, opts.registerer)
+	return wrapTransport(transport, opts)
 }
 
 // DefaultClient returns a new http.Client with similar default values
@@ -131,6 +287,55 @@ func DefaultPooledClient(options ...Option) *http.Client {
 	}
 }
 
+// WrapClient returns a copy of c whose transport is wrapped with the
+// telemetry and the other round trippers configured by the options,
+// keeping the rest of the client, such as its cookie jar, redirect
+// policy and timeout. A nil transport is replaced with
+// http.DefaultTransport. WithTLSConfig has no effect, the TLS
+// configuration being the one of the wrapped transport.
+func WrapClient(c *http.Client, options ...Option) *http.Client {
+	opts := configureOptions(options)
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	c2 := *c
+	c2.Transport = wrapTransport(transport, opts)
+
+	return &c2
+}
+
+func wrapTransport(transport http.RoundTripper, opts *Options) http.RoundTripper {
+	rt := http.RoundTripper(
+		newTelemetryRoundTripper(transport, opts),
+	)
+
+	if opts.compression {
+		rt = newCompressionRoundTripper(rt, opts.compressionMinBytes)
+	}
+
+	if opts.hedge != nil {
+		rt = newHedgingRoundTripper(rt, *opts.hedge, opts.tracerProvider)
+	}
+
+	if opts.cacheStore != nil {
+		rt = newCacheRoundTripper(rt, opts.cacheStore, opts.cacheMaxBodyBytes, opts.registerer)
+	}
+
+	if opts.idempotencyKeyHeader != "" {
+		methods := opts.idempotencyKeyMethods
+		if len(methods) == 0 {
+			methods = []string{http.MethodPost, http.MethodPatch}
+		}
+
+		rt = newIdempotencyKeyRoundTripper(rt, opts.idempotencyKeyHeader, methods)
+	}
+
+	return newUserAgentRoundTripper(rt, opts.userAgent)
+}
+
 func createBaseTransport() *http.Transport {
 	dial := &net.Dialer{
 		Timeout:   30 * time.Second,
@@ -150,9 +355,12 @@ func createBaseTransport() *http.Transport {
 
 func configureOptions(options []Option) *Options {
 	opts := &Options{
-		logger:         log.NewLogger(log.WithOutput(io.Discard)),
+		logger:         log.NewNopLogger(),
 		tracerProvider: otel.GetTracerProvider(),
 		registerer:     prometheus.DefaultRegisterer,
+		userAgent:      DefaultUserAgent,
+
+		cacheMaxBodyBytes: defaultCacheMaxBodyBytes,
 	}
 
 	for _, o := range options {
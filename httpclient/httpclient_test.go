@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapClient(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+		),
+	)
+	defer server.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	var calls int
+	transport := roundTripperFunc(
+		func(r *http.Request) (*http.Response, error) {
+			calls++
+			return http.DefaultTransport.RoundTrip(r)
+		},
+	)
+
+	original := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		Timeout:   5 * time.Second,
+	}
+
+	registry := prometheus.NewRegistry()
+	client := WrapClient(original, WithRegisterer(registry))
+
+	assert.NotSame(t, original, client)
+	assert.Equal(t, jar, client.Jar)
+	assert.Equal(t, 5*time.Second, client.Timeout)
+	assert.IsType(t, roundTripperFunc(nil), original.Transport, "the original client is left untouched")
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, 1, calls)
+	count, err := testutil.GatherAndCount(registry, "http_client_requests_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	t.Run("nil transport", func(t *testing.T) {
+		client := WrapClient(&http.Client{}, WithRegisterer(prometheus.NewRegistry()))
+
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclienttest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type (
+	// Response is a canned response replayed by a RecordingTransport.
+	// When Err is set, the round trip fails with it instead. The
+	// status code defaults to 200.
+	Response struct {
+		StatusCode int
+		Header     http.Header
+		Body       string
+		Err        error
+	}
+
+	// Request is a request received by a RecordingTransport, with its
+	// body fully read.
+	Request struct {
+		Method string
+		URL    *url.URL
+		Header http.Header
+		Body   []byte
+	}
+
+	// RecordingTransport is an http.RoundTripper replaying the
+	// response registered for the method and URL of each request and
+	// recording the request. A request without a registered response
+	// fails. It is safe for concurrent use.
+	//
+	// Example:
+	//
+	//	rt := httpclienttest.NewRecordingTransport()
+	//	rt.Handle("GET", "https://api.example.com/users/1", httpclienttest.Response{
+	//	    Body: `{"id":1}`,
+	//	})
+	//
+	//	client := httpclient.WrapClient(rt.Client())
+	//	...
+	//	rt.AssertCalled(t, "GET", "https://api.example.com/users/1")
+	RecordingTransport struct {
+		mu        sync.Mutex
+		responses map[string]Response
+		requests  []Request
+	}
+)
+
+var (
+	_ http.RoundTripper = (*RecordingTransport)(nil)
+)
+
+// NewRecordingTransport returns a RecordingTransport without any
+// registered response.
+func NewRecordingTransport() *RecordingTransport {
+	return &RecordingTransport{
+		responses: make(map[string]Response),
+	}
+}
+
+// Handle registers the response replayed for the requests with the
+// given method and URL, replacing any previous one. The URL is
+// compared with the one of the request as a whole, query string
+// included.
+func (rt *RecordingTransport) Handle(method, url string, resp Response) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.responses[key(method, url)] = resp
+}
+
+// Client returns an http.Client using rt as its transport.
+func (rt *RecordingTransport) Client() *http.Client {
+	return &http.Client{Transport: rt}
+}
+
+// RoundTrip records r and replays the response registered for its
+// method and URL.
+func (rt *RecordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		defer r.Body.Close()
+
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read request body: %w", err)
+		}
+	}
+
+	u := *r.URL
+
+	rt.mu.Lock()
+	rt.requests = append(
+		rt.requests,
+		Request{
+			Method: r.Method,
+			URL:    &u,
+			Header: r.Header.Clone(),
+			Body:   body,
+		},
+	)
+	resp, ok := rt.responses[key(r.Method, u.String())]
+	rt.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no response registered for %s %s", r.Method, u.String())
+	}
+
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+
+	statusCode := resp.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	header := resp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(resp.Body))),
+		ContentLength: int64(len(resp.Body)),
+		Request:       r,
+	}, nil
+}
+
+// Requests returns a copy of the recorded requests, in order.
+func (rt *RecordingTransport) Requests() []Request {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return slices.Clone(rt.requests)
+}
+
+// Reset discards the recorded requests, keeping the registered
+// responses.
+func (rt *RecordingTransport) Reset() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.requests = nil
+}
+
+// Calls returns the number of recorded requests with the given method
+// and URL.
+func (rt *RecordingTransport) Calls(method, url string) int {
+	var n int
+	for _, r := range rt.Requests() {
+		if key(r.Method, r.URL.String()) == key(method, url) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// AssertCalled reports an error on t unless a request with the given
+// method and URL was recorded, and returns whether there is one.
+func (rt *RecordingTransport) AssertCalled(t testing.TB, method, url string) bool {
+	t.Helper()
+
+	if rt.Calls(method, url) > 0 {
+		return true
+	}
+
+	t.Errorf("no %s %s request, got:\n%s", method, url, rt)
+
+	return false
+}
+
+// AssertNotCalled reports an error on t if a request with the given
+// method and URL was recorded, and returns whether there is none.
+func (rt *RecordingTransport) AssertNotCalled(t testing.TB, method, url string) bool {
+	t.Helper()
+
+	if rt.Calls(method, url) == 0 {
+		return true
+	}
+
+	t.Errorf("unexpected %s %s request", method, url)
+
+	return false
+}
+
+// String returns the recorded requests, one per line.
+func (rt *RecordingTransport) String() string {
+	var b strings.Builder
+	for _, r := range rt.Requests() {
+		fmt.Fprintf(&b, "%s %s\n", r.Method, r.URL)
+	}
+
+	return b.String()
+}
+
+func key(method, url string) string {
+	return strings.ToUpper(method) + " " + url
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclienttest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/httpclient"
+)
+
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordingTransport(t *testing.T) {
+	rt := NewRecordingTransport()
+	rt.Handle(
+		"GET",
+		"https://api.example.com/users/1",
+		Response{
+			Header: http.Header{"Content-Type": []string{"application/json"}},
+			Body:   `{"id":1}`,
+		},
+	)
+	rt.Handle("POST", "https://api.example.com/users", Response{StatusCode: http.StatusCreated})
+	errUnavailable := errors.New("unavailable")
+	rt.Handle("DELETE", "https://api.example.com/users/1", Response{Err: errUnavailable})
+
+	client := httpclient.WrapClient(
+		rt.Client(),
+		httpclient.WithRegisterer(prometheus.NewRegistry()),
+	)
+
+	resp, err := client.Get("https://api.example.com/users/1")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"id":1}`, string(body))
+
+	resp, err = client.Post("https://api.example.com/users", "application/json", strings.NewReader(`{"name":"alice"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	req, err := http.NewRequest("DELETE", "https://api.example.com/users/1", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, errUnavailable)
+
+	_, err = client.Get("https://api.example.com/users/2")
+	assert.ErrorContains(t, err, "no response registered for GET https://api.example.com/users/2")
+
+	requests := rt.Requests()
+	require.Len(t, requests, 4)
+	assert.Equal(t, "POST", requests[1].Method)
+	assert.Equal(t, "/users", requests[1].URL.Path)
+	assert.Equal(t, `{"name":"alice"}`, string(requests[1].Body))
+	assert.Equal(t, "application/json", requests[1].Header.Get("Content-Type"))
+
+	assert.Equal(t, 1, rt.Calls("get", "https://api.example.com/users/1"))
+	assert.True(t, rt.AssertCalled(t, "POST", "https://api.example.com/users"))
+	assert.True(t, rt.AssertNotCalled(t, "PUT", "https://api.example.com/users/1"))
+
+	ft := &fakeT{}
+	assert.False(t, rt.AssertCalled(ft, "PUT", "https://api.example.com/users/1"))
+	assert.False(t, rt.AssertNotCalled(ft, "GET", "https://api.example.com/users/1"))
+	require.Len(t, ft.errors, 2)
+	assert.Contains(t, ft.errors[0], "no PUT https://api.example.com/users/1 request")
+	assert.Contains(t, ft.errors[0], "POST https://api.example.com/users\n")
+
+	rt.Reset()
+	assert.Empty(t, rt.Requests())
+}
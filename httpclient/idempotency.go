@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"go.gearno.de/crypto/uuid"
+)
+
+type (
+	idempotencyKeyRoundTripper struct {
+		header  string
+		methods []string
+		next    http.RoundTripper
+	}
+)
+
+var (
+	_ http.RoundTripper = (*idempotencyKeyRoundTripper)(nil)
+)
+
+func newIdempotencyKeyRoundTripper(next http.RoundTripper, header string, methods []string) *idempotencyKeyRoundTripper {
+	return &idempotencyKeyRoundTripper{
+		header:  header,
+		methods: methods,
+		next:    next,
+	}
+}
+
+// RoundTrip sets a new idempotency key on the requests using one of
+// the configured methods and not already carrying one.
+func (rt *idempotencyKeyRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !slices.Contains(rt.methods, r.Method) || r.Header.Get(rt.header) != "" {
+		return rt.next.RoundTrip(r)
+	}
+
+	key, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate idempotency key: %w", err)
+	}
+
+	r2 := r.Clone(r.Context())
+	if r2.Header == nil {
+		r2.Header = make(http.Header)
+	}
+	r2.Header.Set(rt.header, key.String())
+
+	return rt.next.RoundTrip(r2)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type retryRoundTripper struct {
+	attempts int
+	next     http.RoundTripper
+}
+
+func (rt *retryRoundTripper) RoundTrip(r *http.Request) (resp *http.Response, err error) {
+	for i := 0; i < rt.attempts; i++ {
+		resp, err = rt.next.RoundTrip(r)
+		if err == nil && i < rt.attempts-1 {
+			resp.Body.Close()
+		}
+	}
+
+	return resp, err
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				keys = append(keys, r.Header.Get("Idempotency-Key"))
+			},
+		),
+	)
+	defer server.Close()
+
+	client := DefaultClient(
+		WithRegisterer(prometheus.NewRegistry()),
+		WithIdempotencyKey("Idempotency-Key"),
+	)
+
+	do := func(method string, header string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader("{}"))
+		require.NoError(t, err)
+		if header != "" {
+			req.Header.Set("Idempotency-Key", header)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	do(http.MethodPost, "")
+	do(http.MethodPost, "")
+	do(http.MethodGet, "")
+	do(http.MethodPatch, "caller-key")
+
+	require.Len(t, keys, 4)
+	assert.NotEmpty(t, keys[0])
+	assert.NotEqual(t, keys[0], keys[1], "each request must get its own key")
+	assert.Empty(t, keys[2])
+	assert.Equal(t, "caller-key", keys[3])
+}
+
+func TestIdempotencyKeyStableAcrossRetries(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				keys = append(keys, r.Header.Get("Idempotency-Key"))
+			},
+		),
+	)
+	defer server.Close()
+
+	rt := newIdempotencyKeyRoundTripper(
+		&retryRoundTripper{attempts: 3, next: http.DefaultTransport},
+		"Idempotency-Key",
+		[]string{http.MethodPost},
+	)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, keys[0], keys[2])
+	assert.Empty(t, req.Header.Get("Idempotency-Key"), "the original request must not be modified")
+}
@@ -17,9 +17,12 @@
 package httpclient
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptrace"
+	"slices"
 	"strconv"
 	"time"
 
@@ -47,6 +50,9 @@ type (
 
 		requestsTotal          *prometheus.CounterVec
 		requestDurationSeconds *prometheus.HistogramVec
+		connectionPhaseSeconds *prometheus.HistogramVec
+
+		urlTemplateFunc func(*http.Request) string
 
 		next http.RoundTripper
 	}
@@ -59,20 +65,67 @@ var (
 // NewTelemetryRoundTripper creates a new TelemetryRoundTripper with
 // the provided next RoundTripper, logger, and metric meter. It
 // initializes and registers telemetry instruments for counting
-// requests and measuring request latency.  It uses fallbacks for the
-// logger and meter if nil references are provided.
+// requests and measuring request latency. It falls back to
+// http.DefaultTransport, a discarding logger, the global tracer
+// provider and the default Prometheus registerer when nil references
+// are provided.
+//
+// Among the options, only WithURLTemplateFunc, WithDurationBuckets and
+// WithConnectionTracing apply to the TelemetryRoundTripper.
 func NewTelemetryRoundTripper(
 	next http.RoundTripper,
 	logger *log.Logger,
 	tp trace.TracerProvider,
 	registerer prometheus.Registerer,
+	options ...Option,
 ) *TelemetryRoundTripper {
+	opts := &Options{}
+	for _, o := range options {
+		o(opts)
+	}
+
+	opts.logger = logger
+	opts.tracerProvider = tp
+	opts.registerer = registerer
+
+	return newTelemetryRoundTripper(next, opts)
+}
+
+func newTelemetryRoundTripper(next http.RoundTripper, opts *Options) *TelemetryRoundTripper {
+	var (
+		logger     = opts.logger
+		tp         = opts.tracerProvider
+		registerer = opts.registerer
+		buckets    = opts.durationBuckets
+	)
+
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
 	metricLabels := []string{
 		"method",
 		"host",
 		"flavor",
 		"scheme",
 		"status_code",
+		"path",
 	}
 
 	requestsTotal := prometheus.NewCounterVec(
@@ -81,7 +134,7 @@ func NewTelemetryRoundTripper(
 			Name:      "requests_total",
 			Help:      "Total number of HTTP requests made.",
 		},
-		metricLabels,
+		append(slices.Clip(metricLabels), "error_type"),
 	)
 
 	if err := registerer.Register(requestsTotal); err != nil {
@@ -102,7 +155,7 @@ func NewTelemetryRoundTripper(
 			Subsystem: "http_client",
 			Name:      "request_duration_seconds",
 			Help:      "Duration of HTTP requests in seconds.",
-			Buckets:   prometheus.DefBuckets,
+			Buckets:   buckets,
 		},
 		metricLabels,
 	)
@@ -119,6 +172,11 @@ func NewTelemetryRoundTripper(
 		}
 	}
 
+	var connectionPhaseSeconds *prometheus.HistogramVec
+	if opts.connectionTracing {
+		connectionPhaseSeconds = newConnectionPhaseDuration(registerer, buckets)
+	}
+
 	return &TelemetryRoundTripper{
 		next:   next,
 		logger: logger,
@@ -130,6 +188,8 @@ func NewTelemetryRoundTripper(
 		),
 		requestsTotal:          requestsTotal,
 		requestDurationSeconds: requestDurationSeconds,
+		connectionPhaseSeconds: connectionPhaseSeconds,
+		urlTemplateFunc:        opts.urlTemplateFunc,
 	}
 }
 
@@ -138,6 +198,14 @@ func NewTelemetryRoundTripper(
 // measures the request latency, and counts the request based on the
 // response status. It sanitizes URLs to exclude query parameters and
 // fragments for logging and tracing.
+//
+// Failed round trips are counted as well, without a status code and
+// with an error_type label classifying the error as timeout, dns,
+// connrefused, tls, canceled or other; the span records it in the
+// error.type attribute. Attempts cancelled because another hedged
+// attempt won, see WithHedging, are expected: they are logged at the
+// debug level and counted with the hedge_lost error type, and their
+// span is not marked as failed.
 func (rt *TelemetryRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	var (
 		r2        = r.Clone(r.Context())
@@ -198,15 +266,52 @@ func (rt *TelemetryRoundTripper) RoundTrip(r *http.Request) (*http.Response, err
 		propagator.Inject(ctx, propagation.HeaderCarrier(r2.Header))
 	}
 
+	if rt.connectionPhaseSeconds != nil {
+		tracer := newConnectionTracer(span, r2.URL.Host, start, rt.connectionPhaseSeconds)
+		r2 = r2.WithContext(httptrace.WithClientTrace(r2.Context(), tracer.clientTrace()))
+	}
+
 	resp, err := rt.next.RoundTrip(r2)
 	if err != nil {
-		rt.logger.ErrorCtx(ctx, "cannot execute http transaction", log.Error(err))
+		var (
+			errType   = errorType(err)
+			hedgeLost = errors.Is(context.Cause(ctx), errHedgeLost)
+			logLevel  = log.LevelError
+		)
+
+		if hedgeLost {
+			errType = errorTypeHedgeLost
+			logLevel = log.LevelDebug
+		}
+
+		rt.logger.Log(
+			ctx,
+			logLevel,
+			"cannot execute http transaction",
+			log.Error(err),
+			log.String("http_error_type", errType),
+		)
 
-		if span.IsRecording() {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
+		if rootSpan.IsRecording() {
+			if !hedgeLost {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.SetAttributes(semconv.ErrorTypeKey.String(errType))
 		}
 
+		rt.requestsTotal.With(
+			prometheus.Labels{
+				"method":      r2.Method,
+				"host":        r2.URL.Host,
+				"flavor":      r2.Proto,
+				"scheme":      r2.URL.Scheme,
+				"status_code": "",
+				"path":        rt.path(r2),
+				"error_type":  errType,
+			},
+		).Inc()
+
 		return nil, err
 	}
 
@@ -225,11 +330,14 @@ func (rt *TelemetryRoundTripper) RoundTrip(r *http.Request) (*http.Response, err
 		"flavor":      r2.Proto,
 		"scheme":      r2.URL.Scheme,
 		"status_code": strconv.Itoa(resp.StatusCode),
+		"path":        rt.path(r2),
 	}
 
-	rt.requestsTotal.With(metricLabels).Inc()
 	rt.requestDurationSeconds.With(metricLabels).Observe(duration.Seconds())
 
+	metricLabels["error_type"] = ""
+	rt.requestsTotal.With(metricLabels).Inc()
+
 	logLevel := log.LevelInfo
 	logMessage := fmt.Sprintf("%s %s %d %s", r2.Method, r.URL.String(), resp.StatusCode, duration)
 	if resp.StatusCode >= http.StatusInternalServerError {
@@ -241,6 +349,16 @@ func (rt *TelemetryRoundTripper) RoundTrip(r *http.Request) (*http.Response, err
 	return resp, nil
 }
 
+// path returns the path label of the metrics of r, empty without an
+// URL template function.
+func (rt *TelemetryRoundTripper) path(r *http.Request) string {
+	if rt.urlTemplateFunc == nil {
+		return ""
+	}
+
+	return rt.urlTemplateFunc(r)
+}
+
 func atoi(s string) int {
 	v, err := strconv.Atoi(s)
 	if err != nil {
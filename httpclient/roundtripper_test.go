@@ -18,15 +18,22 @@ package httpclient
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.gearno.de/kit/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MockRoundTripper struct {
@@ -79,3 +86,157 @@ func TestRoundTrip(t *testing.T) {
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 	mockRT.AssertExpectations(t)
 }
+
+func TestUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				userAgent = r.UserAgent()
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+	)
+	defer server.Close()
+
+	t.Run("default", func(t *testing.T) {
+		client := DefaultClient(WithRegisterer(prometheus.NewRegistry()))
+
+		resp, err := client.Get(server.URL)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+
+		assert.Equal(t, DefaultUserAgent, userAgent)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		client := DefaultClient(
+			WithRegisterer(prometheus.NewRegistry()),
+			WithUserAgent("my-service/1.0"),
+		)
+
+		resp, err := client.Get(server.URL)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+
+		assert.Equal(t, "my-service/1.0", userAgent)
+	})
+
+	t.Run("request header", func(t *testing.T) {
+		client := DefaultClient(
+			WithRegisterer(prometheus.NewRegistry()),
+			WithUserAgent("my-service/1.0"),
+		)
+
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("User-Agent", "caller/2.0")
+
+		resp, err := client.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+
+		assert.Equal(t, "caller/2.0", userAgent)
+		assert.Equal(t, "caller/2.0", req.Header.Get("User-Agent"))
+	})
+}
+
+func TestRoundTripURLTemplateAndBuckets(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+	)
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	client := DefaultClient(
+		WithRegisterer(registry),
+		WithURLTemplateFunc(
+			func(r *http.Request) string {
+				return "/users/{id}"
+			},
+		),
+		WithDurationBuckets([]float64{0.001, 0.01}),
+	)
+
+	resp, err := client.Get(server.URL + "/users/123")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, "/users/{id}", labels["path"])
+
+		if family.GetName() == "http_client_request_duration_seconds" {
+			buckets := metric.GetHistogram().GetBucket()
+			require.Len(t, buckets, 2)
+			assert.Equal(t, 0.001, buckets[0].GetUpperBound())
+			assert.Equal(t, 0.01, buckets[1].GetUpperBound())
+		}
+	}
+}
+
+func TestRoundTripConnectionTracing(t *testing.T) {
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+	)
+	defer server.Close()
+
+	var (
+		registry = prometheus.NewRegistry()
+		recorder = tracetest.NewSpanRecorder()
+		tp       = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	)
+
+	client := DefaultClient(
+		WithRegisterer(registry),
+		WithTracerProvider(tp),
+		WithTLSConfig(server.Client().Transport.(*http.Transport).TLSClientConfig),
+		WithConnectionTracing(),
+	)
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	root.End()
+
+	var events []string
+	for _, span := range recorder.Ended() {
+		if span.SpanKind() != trace.SpanKindClient {
+			continue
+		}
+
+		for _, event := range span.Events() {
+			events = append(events, event.Name)
+		}
+	}
+
+	// The server address is an IP, there is no DNS resolution.
+	assert.Equal(t, []string{"connect", "tls", "first_byte"}, events)
+
+	count, err := testutil.GatherAndCount(registry, "http_client_connection_phase_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
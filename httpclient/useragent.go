@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpclient
+
+import (
+	"net/http"
+)
+
+type (
+	userAgentRoundTripper struct {
+		userAgent string
+		next      http.RoundTripper
+	}
+)
+
+var (
+	_ http.RoundTripper = (*userAgentRoundTripper)(nil)
+)
+
+func newUserAgentRoundTripper(next http.RoundTripper, ua string) *userAgentRoundTripper {
+	return &userAgentRoundTripper{
+		userAgent: ua,
+		next:      next,
+	}
+}
+
+// RoundTrip sets the User-Agent header on requests which do not
+// already have one. A request explicitly setting an empty User-Agent
+// is left untouched, as net/http then omits the header.
+func (rt *userAgentRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if _, ok := r.Header["User-Agent"]; ok {
+		return rt.next.RoundTrip(r)
+	}
+
+	r2 := r.Clone(r.Context())
+	if r2.Header == nil {
+		r2.Header = make(http.Header)
+	}
+	r2.Header.Set("User-Agent", rt.userAgent)
+
+	return rt.next.RoundTrip(r2)
+}
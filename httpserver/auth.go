@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type (
+	// authenticator authenticates the request, answering it with a
+	// 401 when it fails. It returns the request to serve, which may
+	// carry a context enriched by the authentication.
+	authenticator func(w http.ResponseWriter, r *http.Request) (*http.Request, error)
+)
+
+var (
+	unauthorizedResponse = map[string]string{
+		"error": "unauthorized",
+	}
+
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// BasicAuthCredentials returns a verify function for WithBasicAuth
+// accepting the given user and password only. The comparison runs in
+// constant time, regardless of the length of the credentials.
+func BasicAuthCredentials(user, password string) func(user, password string) bool {
+	var (
+		expectedUser     = sha256.Sum256([]byte(user))
+		expectedPassword = sha256.Sum256([]byte(password))
+	)
+
+	return func(user, password string) bool {
+		var (
+			u = sha256.Sum256([]byte(user))
+			p = sha256.Sum256([]byte(password))
+		)
+
+		userMatch := subtle.ConstantTimeCompare(u[:], expectedUser[:])
+		passwordMatch := subtle.ConstantTimeCompare(p[:], expectedPassword[:])
+
+		return userMatch&passwordMatch == 1
+	}
+}
+
+func newBasicAuthenticator(realm string, verify func(user, password string) bool) authenticator {
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)
+
+	return func(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			writeUnauthorized(w, challenge)
+			return nil, errMissingCredentials
+		}
+
+		if !verify(user, password) {
+			writeUnauthorized(w, challenge)
+			return nil, errInvalidCredentials
+		}
+
+		return r, nil
+	}
+}
+
+func newBearerAuthenticator(verify func(ctx context.Context, token string) (context.Context, error)) authenticator {
+	return func(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+		scheme, token, ok := strings.Cut(r.Header.Get("authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+			writeUnauthorized(w, "Bearer")
+			return nil, errMissingCredentials
+		}
+
+		ctx, err := verify(r.Context(), token)
+		if err != nil {
+			writeUnauthorized(w, `Bearer error="invalid_token"`)
+			return nil, fmt.Errorf("%w: %w", errInvalidCredentials, err)
+		}
+
+		return r.WithContext(ctx), nil
+	}
+}
+
+func writeUnauthorized(w http.ResponseWriter, challenge string) {
+	w.Header().Set("www-authenticate", challenge)
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(unauthorizedResponse)
+}
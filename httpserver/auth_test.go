@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type subjectKey struct{}
+
+func TestBasicAuth(t *testing.T) {
+	hw := newTestHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+		),
+	)
+	hw.authenticate = newBasicAuthenticator("admin", BasicAuthCredentials("root", "s3cret"))
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		noAuth   bool
+		status   int
+	}{
+		{name: "valid", user: "root", password: "s3cret", status: http.StatusNoContent},
+		{name: "invalid password", user: "root", password: "s3cre", status: http.StatusUnauthorized},
+		{name: "invalid user", user: "admin", password: "s3cret", status: http.StatusUnauthorized},
+		{name: "missing", noAuth: true, status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name,
+			func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+				if !tt.noAuth {
+					r.SetBasicAuth(tt.user, tt.password)
+				}
+
+				w := httptest.NewRecorder()
+				hw.ServeHTTP(w, r)
+
+				assert.Equal(t, tt.status, w.Code)
+				if tt.status == http.StatusUnauthorized {
+					assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, w.Header().Get("www-authenticate"))
+				}
+			},
+		)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	hw := newTestHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Context().Value(subjectKey{}).(string)))
+			},
+		),
+	)
+	hw.authenticate = newBearerAuthenticator(
+		func(ctx context.Context, token string) (context.Context, error) {
+			if token != "valid-token" {
+				return nil, errors.New("unknown token")
+			}
+
+			return context.WithValue(ctx, subjectKey{}, "user-42"), nil
+		},
+	)
+
+	r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	r.Header.Set("authorization", "Bearer valid-token")
+	w := httptest.NewRecorder()
+	hw.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-42", w.Body.String())
+
+	r = httptest.NewRequest(http.MethodGet, "/admin", nil)
+	r.Header.Set("authorization", "Bearer other-token")
+	w = httptest.NewRecorder()
+	hw.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("www-authenticate"))
+
+	r = httptest.NewRequest(http.MethodGet, "/admin", nil)
+	r.SetBasicAuth("root", "s3cret")
+	w = httptest.NewRecorder()
+	hw.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("www-authenticate"))
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.gearno.de/x/panicf"
+)
+
+type (
+	// concurrencyLimiter bounds the number of requests served at the
+	// same time with a semaphore, the requests exceeding it waiting
+	// for a slot up to the queue timeout.
+	concurrencyLimiter struct {
+		slots            chan struct{}
+		queueTimeout     time.Duration
+		requestsInFlight prometheus.Gauge
+		rejectionsTotal  prometheus.Counter
+	}
+)
+
+var (
+	serviceUnavailableResponse = map[string]string{
+		"error": "too many concurrent requests",
+	}
+
+	errTooManyConcurrentRequests = errors.New("too many concurrent requests")
+)
+
+func newConcurrencyLimiter(
+	n int,
+	queueTimeout time.Duration,
+	registerer prometheus.Registerer,
+) *concurrencyLimiter {
+	if n <= 0 {
+		panicf.Panic("invalid max concurrent requests %d: must be positive", n)
+	}
+
+	if queueTimeout < 0 {
+		panicf.Panic("invalid concurrent requests queue timeout %s: must not be negative", queueTimeout)
+	}
+
+	requestsInFlight := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "http_server",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests being served.",
+		},
+	)
+	registerer.MustRegister(requestsInFlight)
+
+	rejectionsTotal := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "http_server",
+			Name:      "concurrency_rejections_total",
+			Help:      "Total number of HTTP requests rejected because of the concurrent requests limit.",
+		},
+	)
+	registerer.MustRegister(rejectionsTotal)
+
+	return &concurrencyLimiter{
+		slots:            make(chan struct{}, n),
+		queueTimeout:     queueTimeout,
+		requestsInFlight: requestsInFlight,
+		rejectionsTotal:  rejectionsTotal,
+	}
+}
+
+// acquire waits for a slot for r, answering it with a 503 if none is
+// released within the queue timeout or if r is cancelled meanwhile.
+// The returned function releases the slot.
+func (cl *concurrencyLimiter) acquire(w http.ResponseWriter, r *http.Request) (func(), error) {
+	select {
+	case cl.slots <- struct{}{}:
+		return cl.acquired(), nil
+	default:
+	}
+
+	if cl.queueTimeout > 0 {
+		timer := time.NewTimer(cl.queueTimeout)
+		defer timer.Stop()
+
+		select {
+		case cl.slots <- struct{}{}:
+			return cl.acquired(), nil
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+	}
+
+	cl.rejectionsTotal.Inc()
+	RenderJSONErr(w, http.StatusServiceUnavailable, serviceUnavailableResponse)
+
+	return nil, errTooManyConcurrentRequests
+}
+
+func (cl *concurrencyLimiter) acquired() func() {
+	cl.requestsInFlight.Inc()
+
+	return func() {
+		cl.requestsInFlight.Dec()
+		<-cl.slots
+	}
+}
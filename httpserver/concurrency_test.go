@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestHandlerWrapperMaxConcurrentRequests(t *testing.T) {
+	var (
+		registry = prometheus.NewRegistry()
+		started  = make(chan struct{})
+		release  = make(chan struct{})
+	)
+
+	hw := newHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					started <- struct{}{}
+					<-release
+				}
+
+				w.Write([]byte("ok"))
+			},
+		),
+		log.NewLogger(log.WithOutput(io.Discard)),
+		noop.NewTracerProvider(),
+		registry,
+	)
+	hw.limitConcurrency = newConcurrencyLimiter(1, 20*time.Millisecond, registry)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- w
+	}()
+	<-started
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(hw.limitConcurrency.requestsInFlight))
+
+	t.Run("rejected after the queue timeout", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"error":"too many concurrent requests"}`, w.Body.String())
+		assert.Equal(t, 1.0, testutil.ToFloat64(hw.limitConcurrency.rejectionsTotal))
+	})
+
+	t.Run("health checks are not limited", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("queued until a slot is released", func(t *testing.T) {
+		hw.limitConcurrency.queueTimeout = 5 * time.Second
+
+		queued := make(chan *httptest.ResponseRecorder)
+		go func() {
+			w := httptest.NewRecorder()
+			hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+			queued <- w
+		}()
+
+		close(release)
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+		assert.Equal(t, http.StatusOK, (<-queued).Code)
+		assert.Equal(t, 0.0, testutil.ToFloat64(hw.limitConcurrency.requestsInFlight))
+		assert.Equal(t, 1.0, testutil.ToFloat64(hw.limitConcurrency.rejectionsTotal))
+	})
+
+	count, err := testutil.GatherAndCount(registry, "http_server_concurrency_rejections_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestNewConcurrencyLimiterInvalid(t *testing.T) {
+	assert.Panics(t, func() { newConcurrencyLimiter(0, 0, prometheus.NewRegistry()) })
+	assert.Panics(t, func() { newConcurrencyLimiter(1, -time.Second, prometheus.NewRegistry()) })
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.gearno.de/x/panicf"
+)
+
+type (
+	// requestDecompressor decompresses the body of the request
+	// according to its Content-Encoding header, answering it with an
+	// error status when it cannot. It returns the request to serve.
+	requestDecompressor func(w http.ResponseWriter, r *http.Request) (*http.Request, error)
+
+	decompressedBody struct {
+		io.Reader
+		decoder io.Closer
+		body    io.Closer
+	}
+)
+
+const (
+	supportedContentEncodings = "gzip, deflate"
+)
+
+var (
+	unsupportedMediaTypeResponse = map[string]string{
+		"error": "unsupported content encoding",
+	}
+
+	badRequestResponse = map[string]string{
+		"error": "bad request",
+	}
+
+	errUnsupportedContentEncoding = errors.New("unsupported content encoding")
+)
+
+func newRequestDecompressor(maxSize int64) requestDecompressor {
+	if maxSize <= 0 {
+		panicf.Panic("invalid decompressed request body max size %d: must be positive", maxSize)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("content-encoding")))
+
+		var (
+			decoder io.ReadCloser
+			err     error
+		)
+
+		switch encoding {
+		case "", "identity":
+			return r, nil
+		case "gzip", "x-gzip":
+			decoder, err = gzip.NewReader(r.Body)
+		case "deflate":
+			decoder, err = zlib.NewReader(r.Body)
+		default:
+			w.Header().Set("accept-encoding", supportedContentEncodings)
+			RenderJSONErr(w, http.StatusUnsupportedMediaType, unsupportedMediaTypeResponse)
+			return nil, fmt.Errorf("%w %q", errUnsupportedContentEncoding, encoding)
+		}
+
+		if err != nil {
+			RenderJSONErr(w, http.StatusBadRequest, badRequestResponse)
+			return nil, fmt.Errorf("cannot read %s request body: %w", encoding, err)
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.Header.Del("content-encoding")
+		r2.Header.Del("content-length")
+		r2.ContentLength = -1
+		r2.Body = http.MaxBytesReader(
+			w,
+			&decompressedBody{
+				Reader:  decoder,
+				decoder: decoder,
+				body:    r.Body,
+			},
+			maxSize,
+		)
+
+		return r2, nil
+	}
+}
+
+func (b *decompressedBody) Close() error {
+	return errors.Join(b.decoder.Close(), b.body.Close())
+}
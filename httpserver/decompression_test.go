@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDecompression(t *testing.T) {
+	hw := newTestHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					var maxBytesErr *http.MaxBytesError
+					if errors.As(err, &maxBytesErr) {
+						w.WriteHeader(http.StatusRequestEntityTooLarge)
+						return
+					}
+
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				w.Header().Set("x-content-encoding", r.Header.Get("content-encoding"))
+				w.Write(body)
+			},
+		),
+	)
+	hw.decompress = newRequestDecompressor(1024)
+
+	compress := func(t *testing.T, encoding string, data []byte) []byte {
+		t.Helper()
+
+		var (
+			buf bytes.Buffer
+			w   io.WriteCloser
+		)
+
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		}
+
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		return buf.Bytes()
+	}
+
+	serve := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			r.Header.Set("content-encoding", encoding)
+		}
+
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("identity", func(t *testing.T) {
+		w := serve("", []byte("hello"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+	})
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			w := serve(encoding, compress(t, encoding, []byte("hello")))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "hello", w.Body.String())
+			assert.Empty(t, w.Header().Get("x-content-encoding"))
+		})
+	}
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		w := serve("br", []byte("hello"))
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		assert.Equal(t, "gzip, deflate", w.Header().Get("accept-encoding"))
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		w := serve("gzip", []byte("hello"))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("decompression bomb", func(t *testing.T) {
+		// 1MiB of zeros compresses to about 1KiB.
+		body := compress(t, "gzip", []byte(strings.Repeat("\x00", 1<<20)))
+		require.Less(t, len(body), 1<<20)
+
+		w := serve("gzip", body)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func TestWithRequestDecompression_InvalidMaxSize(t *testing.T) {
+	assert.Panics(t, func() { NewServer(":0", http.NotFoundHandler(), WithRequestDecompression(0)) })
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.gearno.de/x/panicf"
+)
+
+// ServeWithETag serves the representation returned by body with an
+// ETag header, answering conditional requests. The ETag is the given
+// one, quoted when it is not already, or, when etag is empty, a hash
+// of the body.
+//
+// When the If-None-Match header of the request matches the ETag, it
+// writes 304 Not Modified without a body for GET and HEAD requests,
+// and 412 Precondition Failed for the other methods. The body function
+// is only called when the ETag is given and the request does not
+// match, or when the ETag must be computed. Otherwise, the body is
+// written with a 200 status code and the headers already set on w,
+// such as the content type. An error returned by body is returned
+// before anything is written, so the caller can render it.
+//
+// Example:
+//
+//	w.Header().Set("content-type", "text/csv")
+//	err := httpserver.ServeWithETag(w, r, report.Version, func() ([]byte, error) {
+//	    return report.CSV()
+//	})
+//
+// The 304 responses are counted in the server metrics with their own
+// status_code label value.
+func ServeWithETag(w http.ResponseWriter, r *http.Request, etag string, body func() ([]byte, error)) error {
+	var (
+		b   []byte
+		err error
+	)
+
+	if etag == "" {
+		b, err = body()
+		if err != nil {
+			return err
+		}
+
+		etag = computeETag(b)
+	} else {
+		etag = quoteETag(etag)
+	}
+
+	w.Header().Set("etag", etag)
+
+	if matchETag(r.Header.Get("if-none-match"), etag) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h := w.Header()
+			h.Del("content-type")
+			h.Del("content-length")
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+
+		return nil
+	}
+
+	if b == nil {
+		b, err = body()
+		if err != nil {
+			w.Header().Del("etag")
+			return err
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(b)
+	return err
+}
+
+// RenderJSONCached writes v encoded as JSON like RenderJSON, with an
+// ETag computed from the encoded value, and answers the conditional
+// requests matching it as ServeWithETag does. It panics when v cannot
+// be encoded or written.
+func RenderJSONCached(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	err := ServeWithETag(
+		w,
+		r,
+		"",
+		func() ([]byte, error) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(b, '\n'), nil
+		},
+	)
+	if err != nil {
+		panicf.Panic("cannot json encode value: %w", err)
+	}
+}
+
+func computeETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+
+	return `"` + etag + `"`
+}
+
+// matchETag reports whether the If-None-Match header value matches
+// etag, using the weak comparison of RFC 9110 section 13.1.2.
+func matchETag(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return false
+	}
+
+	if header == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
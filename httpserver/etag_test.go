@@ -0,0 +1,171 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestServeWithETag(t *testing.T) {
+	serve := func(t *testing.T, method, ifNoneMatch, etag string) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, "/", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("if-none-match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("content-type", "text/plain")
+
+		var calls int
+		err := ServeWithETag(
+			w,
+			r,
+			etag,
+			func() ([]byte, error) {
+				calls++
+				return []byte("hello"), nil
+			},
+		)
+		require.NoError(t, err)
+
+		return w, calls
+	}
+
+	t.Run("no condition", func(t *testing.T) {
+		w, calls := serve(t, http.MethodGet, "", "v1")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"v1"`, w.Header().Get("etag"))
+		assert.Equal(t, "text/plain", w.Header().Get("content-type"))
+		assert.Equal(t, "hello", w.Body.String())
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("match", func(t *testing.T) {
+		w, calls := serve(t, http.MethodGet, `"v0", W/"v1"`, "v1")
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, `"v1"`, w.Header().Get("etag"))
+		assert.Empty(t, w.Header().Get("content-type"))
+		assert.Empty(t, w.Body.String())
+		assert.Zero(t, calls)
+	})
+
+	t.Run("wildcard", func(t *testing.T) {
+		w, _ := serve(t, http.MethodHead, "*", "v1")
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		w, _ := serve(t, http.MethodGet, `"v0"`, `W/"v1"`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `W/"v1"`, w.Header().Get("etag"))
+		assert.Equal(t, "hello", w.Body.String())
+	})
+
+	t.Run("unsafe method", func(t *testing.T) {
+		w, calls := serve(t, http.MethodPut, `"v1"`, "v1")
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Zero(t, calls)
+	})
+
+	t.Run("computed", func(t *testing.T) {
+		w, calls := serve(t, http.MethodGet, "", "")
+		etag := w.Header().Get("etag")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+		assert.Equal(t, 1, calls)
+
+		w, _ = serve(t, http.MethodGet, etag, "")
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("body error", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		err := ServeWithETag(
+			w,
+			httptest.NewRequest(http.MethodGet, "/", nil),
+			"v1",
+			func() ([]byte, error) {
+				return nil, errors.New("boom")
+			},
+		)
+
+		assert.EqualError(t, err, "boom")
+		assert.Empty(t, w.Header().Get("etag"))
+	})
+}
+
+func TestRenderJSONCached(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	hw := newHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				RenderJSONCached(w, r, renderPayload{Name: "kit"})
+			},
+		),
+		log.NewLogger(log.WithOutput(io.Discard)),
+		noop.NewTracerProvider(),
+		registry,
+	)
+
+	w := httptest.NewRecorder()
+	hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("content-type"))
+	assert.Equal(t, "{\"name\":\"kit\"}\n", w.Body.String())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("if-none-match", w.Header().Get("etag"))
+	w = httptest.NewRecorder()
+	hw.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	for _, code := range []string{"200", "304"} {
+		count := testutil.ToFloat64(
+			hw.requestsTotal.With(
+				prometheus.Labels{
+					"method":      http.MethodGet,
+					"host":        "example.com",
+					"flavor":      "HTTP/1.1",
+					"status_code": code,
+					"path":        unknownRoutePattern,
+				},
+			),
+		)
+		assert.Equal(t, float64(1), count, code)
+	}
+}
@@ -19,10 +19,14 @@ package httpserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -30,6 +34,7 @@ import (
 	"go.gearno.de/crypto/uuid"
 	"go.gearno.de/kit/internal/version"
 	"go.gearno.de/kit/log"
+	"go.gearno.de/kit/otelutils"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -47,11 +52,27 @@ type (
 		responseSize    *prometheus.HistogramVec
 		tracer          trace.Tracer
 		logger          *log.Logger
+
+		slowRequestThreshold time.Duration
+		requestTimeout       time.Duration
+		samplingRoutes       *http.ServeMux
+		authenticate         authenticator
+		decompress           requestDecompressor
+		accessLogFormat      AccessLogFormat
+		limitConcurrency     *concurrencyLimiter
+		autoOptions          bool
+		routeMiddlewares     *http.ServeMux
 	}
+
+	// samplingOverrideHandler is registered in the sampling routes
+	// mux to retrieve the sampling override of a route.
+	samplingOverrideHandler otelutils.SamplingOverride
 )
 
 const (
 	tracerName = "go.gearno.de/kit/httpserver"
+
+	unknownRoutePattern = "unknown"
 )
 
 var (
@@ -138,6 +159,10 @@ func (hw *handlerWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Bypass for OPTIONS request to avoid telemetry, metrics and
 	// logging noise.
 	if r.Method == http.MethodOptions {
+		if hw.autoOptions && serveOptions(hw.next, w, r) {
+			return
+		}
+
 		hw.next.ServeHTTP(w, r)
 		return
 	}
@@ -180,9 +205,17 @@ func (hw *handlerWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var (
 		rootSpan = trace.SpanFromContext(ctx)
 		span     trace.Span
+		sampling = hw.samplingOverride(r2)
 	)
 
-	if rootSpan.IsRecording() {
+	if sampling != otelutils.SampleDefault {
+		ctx = otelutils.ContextWithSamplingOverride(ctx, sampling)
+	}
+
+	// Force sampled routes are traced even without a parent span.
+	traced := rootSpan.IsRecording() || sampling == otelutils.SampleAlways
+
+	if traced {
 		propagator := otel.GetTextMapPropagator()
 		ctx = propagator.Extract(ctx, propagation.HeaderCarrier(r2.Header))
 
@@ -210,24 +243,44 @@ func (hw *handlerWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Hack to get route pattern from Chi. As today using the STD
 	// router will require to much works to have proper sub router
-	// support, a task for later.
+	// support, a task for later. The standard library mux sets the
+	// pattern on the request instead, see routePattern.
 	ctx = context.WithValue(ctx, chi.RouteCtxKey, chi.NewRouteContext())
+	r3 := r2.WithContext(ctx)
+
+	// Set when the request timed out, as the router state of r3 is
+	// then still in use by the handler.
+	var timeoutRoute string
 
 	defer func() {
 		duration := time.Since(start)
+
+		route := timeoutRoute
+		if route == "" {
+			route = routePattern(r3)
+		}
+
 		hasPanic := false
 		rvr := recover()
+		if err, ok := rvr.(error); ok && isClientDisconnected(err) {
+			// The client went away while the response was being
+			// written, there is nothing left to answer and this
+			// is not a server failure.
+			logger.DebugCtx(ctx, "client disconnected", log.Error(err))
+			rvr = nil
+		}
+
 		if rvr != nil {
 			hasPanic = true
 
 			if err, ok := rvr.(error); ok {
-				if rootSpan.IsRecording() {
+				if traced {
 					span.RecordError(err)
 					span.SetStatus(codes.Error, err.Error())
 				}
 
 			} else {
-				if rootSpan.IsRecording() {
+				if traced {
 					span.SetStatus(codes.Error, fmt.Sprintf("%v", rvr))
 				}
 			}
@@ -246,12 +299,23 @@ func (hw *handlerWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// The route is only known once the request is routed: name
+		// the span after it rather than the raw path, so the span
+		// names stay bounded.
+		if traced {
+			if route != unknownRoutePattern {
+				template := routeTemplate(route)
+				span.SetName(r2.Method + " " + template)
+				span.SetAttributes(semconv.HTTPRoute(template))
+			}
+		}
+
 		metricLabels := prometheus.Labels{
 			"method":      r2.Method,
 			"host":        r2.Host,
 			"flavor":      r2.Proto,
 			"status_code": strconv.Itoa(ww.Status()),
-			"path":        chi.RouteContext(ctx).RoutePattern(),
+			"path":        route,
 		}
 
 		hw.requestsTotal.With(metricLabels).Inc()
@@ -259,43 +323,182 @@ func (hw *handlerWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		hw.requestSize.With(metricLabels).Observe(estimateRequestSize(r))
 		hw.responseSize.With(metricLabels).Observe(float64(ww.BytesWritten()))
 
-		var resSizeString string
-		if ww.BytesWritten() < 1000 {
-			resSizeString = fmt.Sprintf("%dB", ww.BytesWritten())
-		} else if ww.BytesWritten() < 1_000_000 {
-			resSizeString = fmt.Sprintf("%.1fkB", float64(ww.BytesWritten())/1e3)
-		} else if ww.BytesWritten() < 1_000_000_000 {
-			resSizeString = fmt.Sprintf("%.1fMB", float64(ww.BytesWritten())/1e6)
-		} else {
-			resSizeString = fmt.Sprintf("%.1fGB", float64(ww.BytesWritten())/1e9)
-		}
-
-		msg := fmt.Sprintf(
-			"%s %s %d %s %s",
-			r2.Method,
-			r2.URL.Path,
-			ww.Status(),
-			resSizeString,
-			duration,
-		)
-
-		logger.With(
+		logger = logger.With(
 			log.Int("http_reponse_size", ww.BytesWritten()),
 			log.Int("http_response_status", ww.Status()),
 		)
 
-		if ww.Status() > 499 && !hasPanic {
+		slow := hw.slowRequestThreshold > 0 && duration > hw.slowRequestThreshold
+		if slow {
+			logger = logger.With(log.Bool("slow", true))
+		}
+
+		if ww.Status() > 499 && !hasPanic && traced {
 			span.SetStatus(codes.Error, fmt.Sprintf("%d status code", ww.Status()))
 		}
 
-		if ww.Status() > 499 || hasPanic {
+		failed := ww.Status() > 499 || hasPanic
+		if hw.accessLogFormat == AccessLogOff && !failed {
+			return
+		}
+
+		var msg string
+		if hw.accessLogFormat == AccessLogStructured {
+			logger = logger.With(
+				log.String("http_request_route", route),
+				log.Float64("http_request_duration_ms", float64(duration)/float64(time.Millisecond)),
+			)
+		} else {
+			msg = accessLogMessage(r2, ww, duration)
+		}
+
+		if failed {
 			logger.ErrorCtx(ctx, msg)
+		} else if slow {
+			logger.WarnCtx(ctx, msg)
 		} else {
 			logger.InfoCtx(ctx, msg)
 		}
 	}()
 
-	hw.next.ServeHTTP(ww, r2.WithContext(ctx))
+	// The concurrency limit is checked first so the rejected requests
+	// cost as little as possible.
+	if hw.limitConcurrency != nil {
+		release, err := hw.limitConcurrency.acquire(ww, r3)
+		if err != nil {
+			logger = logger.With(log.String("http_concurrency_error", err.Error()))
+			return
+		}
+		defer release()
+	}
+
+	if hw.authenticate != nil {
+		r4, err := hw.authenticate(ww, r3)
+		if err != nil {
+			logger = logger.With(log.String("http_auth_error", err.Error()))
+			return
+		}
+
+		r3 = r4
+	}
+
+	if hw.decompress != nil {
+		r4, err := hw.decompress(ww, r3)
+		if err != nil {
+			logger = logger.With(log.String("http_decompression_error", err.Error()))
+			return
+		}
+
+		r3 = r4
+	}
+
+	next, r4, copyPattern := hw.routeHandlerFor(r3)
+	r3 = r4
+
+	if hw.requestTimeout > 0 {
+		timeoutRoute = hw.serveWithTimeout(next, ww, r3)
+		if timeoutRoute == "" {
+			copyPattern()
+		}
+
+		return
+	}
+
+	next.ServeHTTP(ww, r3)
+	copyPattern()
+}
+
+// accessLogMessage returns the human readable message of the access
+// log entry of r, e.g. "GET /x 200 1.2kB 3ms".
+func accessLogMessage(r *http.Request, ww WrapResponseWriter, duration time.Duration) string {
+	var resSizeString string
+	if ww.BytesWritten() < 1000 {
+		resSizeString = fmt.Sprintf("%dB", ww.BytesWritten())
+	} else if ww.BytesWritten() < 1_000_000 {
+		resSizeString = fmt.Sprintf("%.1fkB", float64(ww.BytesWritten())/1e3)
+	} else if ww.BytesWritten() < 1_000_000_000 {
+		resSizeString = fmt.Sprintf("%.1fMB", float64(ww.BytesWritten())/1e6)
+	} else {
+		resSizeString = fmt.Sprintf("%.1fGB", float64(ww.BytesWritten())/1e9)
+	}
+
+	return fmt.Sprintf(
+		"%s %s %d %s %s",
+		r.Method,
+		r.URL.Path,
+		ww.Status(),
+		resSizeString,
+		duration,
+	)
+}
+
+// samplingOverride returns the sampling override of the route matched
+// by r among the sampling routes.
+func (hw *handlerWrapper) samplingOverride(r *http.Request) otelutils.SamplingOverride {
+	if hw.samplingRoutes == nil {
+		return otelutils.SampleDefault
+	}
+
+	h, _ := hw.samplingRoutes.Handler(r)
+	if o, ok := h.(samplingOverrideHandler); ok {
+		return otelutils.SamplingOverride(o)
+	}
+
+	return otelutils.SampleDefault
+}
+
+// newSamplingRoutes returns a mux matching the force sampled and never
+// sampled routes, or nil when there are none.
+func newSamplingRoutes(force, never []string) *http.ServeMux {
+	if len(force) == 0 && len(never) == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	for _, pattern := range force {
+		mux.Handle(pattern, samplingOverrideHandler(otelutils.SampleAlways))
+	}
+
+	for _, pattern := range never {
+		mux.Handle(pattern, samplingOverrideHandler(otelutils.SampleNever))
+	}
+
+	return mux
+}
+
+func (samplingOverrideHandler) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// routePattern returns the route pattern matched by the router, so the
+// path label of the metrics stays bounded. It supports chi and the
+// standard library mux, and returns unknownRoutePattern when no route
+// was matched or the router is not supported.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+
+	return unknownRoutePattern
+}
+
+// routeTemplate returns the path template of a route pattern, without
+// the method and host the standard library mux patterns may hold, e.g.
+// "/users/{id}" for "GET example.com/users/{id}".
+func routeTemplate(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = strings.TrimLeft(path, " \t")
+	}
+
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+
+	return pattern
 }
 
 func atoi(s string) int {
@@ -307,6 +510,12 @@ func atoi(s string) int {
 	return v
 }
 
+func isClientDisconnected(err error) bool {
+	return errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
 func estimateRequestSize(r *http.Request) float64 {
 	s := 0
 	if r.URL != nil {
@@ -0,0 +1,363 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	"go.gearno.de/kit/otelutils"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestHandlerWrapper(h http.Handler) *handlerWrapper {
+	return newHandlerWrapper(
+		h,
+		log.NewLogger(log.WithOutput(io.Discard)),
+		noop.NewTracerProvider(),
+		prometheus.NewRegistry(),
+	)
+}
+
+func TestHandlerWrapperPanic(t *testing.T) {
+	hw := newTestHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+		),
+	)
+
+	w := httptest.NewRecorder()
+	hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestHandlerWrapperClientDisconnected(t *testing.T) {
+	hw := newTestHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				panic(fmt.Errorf("cannot json encode value: %w", syscall.EPIPE))
+			},
+		),
+	)
+
+	w := httptest.NewRecorder()
+	hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.NotEqual(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestHandlerWrapperSlowRequest(t *testing.T) {
+	var buf bytes.Buffer
+	hw := newHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					time.Sleep(20 * time.Millisecond)
+				}
+
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+		log.NewLogger(log.WithOutput(&buf)),
+		noop.NewTracerProvider(),
+		prometheus.NewRegistry(),
+	)
+	hw.slowRequestThreshold = 10 * time.Millisecond
+
+	for _, tc := range []struct {
+		path  string
+		level string
+		slow  any
+	}{
+		{"/fast", "INFO", nil},
+		{"/slow", "WARN", true},
+	} {
+		buf.Reset()
+		hw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, tc.level, entry["level"], tc.path)
+		assert.Equal(t, tc.slow, entry["slow"], tc.path)
+		assert.Equal(t, float64(http.StatusOK), entry["http_response_status"], tc.path)
+	}
+}
+
+func TestHandlerWrapperAccessLogFormat(t *testing.T) {
+	var buf bytes.Buffer
+	hw := newHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/error":
+					w.WriteHeader(http.StatusBadGateway)
+				case "/panic":
+					panic("boom")
+				default:
+					w.Write([]byte("hello"))
+				}
+			},
+		),
+		log.NewLogger(log.WithOutput(&buf)),
+		noop.NewTracerProvider(),
+		prometheus.NewRegistry(),
+	)
+
+	serve := func(path string) map[string]any {
+		buf.Reset()
+		hw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+		if buf.Len() == 0 {
+			return nil
+		}
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+
+		return entry
+	}
+
+	t.Run("message", func(t *testing.T) {
+		hw.accessLogFormat = AccessLogMessage
+
+		entry := serve("/x")
+		require.NotNil(t, entry)
+		assert.Regexp(t, `^GET /x 200 5B \S+$`, entry["msg"])
+		assert.NotContains(t, entry, "http_request_duration_ms")
+	})
+
+	t.Run("structured", func(t *testing.T) {
+		hw.accessLogFormat = AccessLogStructured
+
+		entry := serve("/x")
+		require.NotNil(t, entry)
+		assert.Equal(t, "", entry["msg"])
+		assert.Equal(t, "GET", entry["http_request_method"])
+		assert.Equal(t, "/x", entry["http_request_path"])
+		assert.Equal(t, "unknown", entry["http_request_route"])
+		assert.Equal(t, float64(http.StatusOK), entry["http_response_status"])
+		assert.Equal(t, 5.0, entry["http_reponse_size"])
+		assert.IsType(t, 0.0, entry["http_request_duration_ms"])
+	})
+
+	t.Run("off", func(t *testing.T) {
+		hw.accessLogFormat = AccessLogOff
+
+		assert.Nil(t, serve("/x"))
+
+		entry := serve("/error")
+		require.NotNil(t, entry)
+		assert.Equal(t, "ERROR", entry["level"])
+		assert.Equal(t, float64(http.StatusBadGateway), entry["http_response_status"])
+
+		entry = serve("/panic")
+		require.NotNil(t, entry)
+		assert.Equal(t, "ERROR", entry["level"])
+		assert.Equal(t, "boom", entry["error"])
+	})
+}
+
+func TestHandlerWrapperRoutePattern(t *testing.T) {
+	requestPath := func(t *testing.T, h http.Handler, timeout time.Duration, target string) string {
+		t.Helper()
+
+		registry := prometheus.NewRegistry()
+		hw := newHandlerWrapper(
+			h,
+			log.NewLogger(log.WithOutput(io.Discard)),
+			noop.NewTracerProvider(),
+			registry,
+		)
+		hw.requestTimeout = timeout
+
+		hw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+
+		families, err := registry.Gather()
+		require.NoError(t, err)
+
+		for _, family := range families {
+			if family.GetName() != "http_server_requests_total" {
+				continue
+			}
+
+			for _, label := range family.GetMetric()[0].GetLabel() {
+				if label.GetName() == "path" {
+					return label.GetValue()
+				}
+			}
+		}
+
+		t.Fatal("cannot find the path label")
+		return ""
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /users/{id}",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+
+	router := chi.NewRouter()
+	router.Get(
+		"/users/{id}",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+
+	assert.Equal(t, "/users/{id}", requestPath(t, router, 0, "/users/42"))
+	assert.Equal(t, "GET /users/{id}", requestPath(t, mux, 0, "/users/42"))
+	assert.Equal(t, "GET /users/{id}", requestPath(t, mux, time.Second, "/users/42"))
+	assert.Equal(t, unknownRoutePattern, requestPath(t, mux, 0, "/unknown"))
+	assert.Equal(
+		t,
+		unknownRoutePattern,
+		requestPath(t, http.NotFoundHandler(), 0, "/users/42"),
+	)
+}
+
+func TestHandlerWrapperSamplingRoutes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(
+			otelutils.NewOverrideSampler(
+				sdktrace.ParentBased(sdktrace.NeverSample()),
+			),
+		),
+		sdktrace.WithSpanProcessor(recorder),
+	)
+
+	hw := newHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+		log.NewLogger(log.WithOutput(io.Discard)),
+		tp,
+		prometheus.NewRegistry(),
+	)
+	hw.samplingRoutes = newSamplingRoutes(
+		[]string{"POST /checkout"},
+		[]string{"/health-details"},
+	)
+
+	hw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/checkout", nil))
+	hw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/checkout", nil))
+	hw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "POST  /checkout", spans[0].Name())
+
+	// Within a sampled trace, the never sampled routes are dropped
+	// while the other routes follow the parent decision.
+	ctx, parent := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+
+	for _, target := range []string{"/health-details", "/users"} {
+		r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		hw.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	spans = recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET  /users", spans[1].Name())
+}
+
+func TestHandlerWrapperSpanName(t *testing.T) {
+	spanFor := func(t *testing.T, h http.Handler, target string) sdktrace.ReadOnlySpan {
+		t.Helper()
+
+		recorder := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+		hw := newHandlerWrapper(
+			h,
+			log.NewLogger(log.WithOutput(io.Discard)),
+			tp,
+			prometheus.NewRegistry(),
+		)
+
+		ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+		defer parent.End()
+
+		r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		hw.ServeHTTP(httptest.NewRecorder(), r)
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 1)
+
+		return spans[0]
+	}
+
+	route := func(t *testing.T, span sdktrace.ReadOnlySpan) string {
+		t.Helper()
+
+		for _, attr := range span.Attributes() {
+			if attr.Key == "http.route" {
+				return attr.Value.AsString()
+			}
+		}
+
+		return ""
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/users/{id}", ok)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET example.com/users/{id}", ok)
+
+	span := spanFor(t, router, "/users/42")
+	assert.Equal(t, "GET /users/{id}", span.Name())
+	assert.Equal(t, "/users/{id}", route(t, span))
+
+	span = spanFor(t, mux, "http://example.com/users/42")
+	assert.Equal(t, "GET /users/{id}", span.Name())
+	assert.Equal(t, "/users/{id}", route(t, span))
+
+	// Without a matched route, the span keeps the raw path.
+	span = spanFor(t, router, "/unknown")
+	assert.Equal(t, "GET  /unknown", span.Name())
+	assert.Empty(t, route(t, span))
+}
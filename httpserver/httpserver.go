@@ -17,7 +17,7 @@
 package httpserver
 
 import (
-	"io"
+	"context"
 	stdlog "log"
 	"net/http"
 	"time"
@@ -31,13 +31,47 @@ import (
 type (
 	Option func(o *Options)
 
+	// AccessLogFormat is the format of the log entry written for each
+	// request.
+	AccessLogFormat int
+
 	Options struct {
 		tracerProvider trace.TracerProvider
 		logger         *log.Logger
 		registerer     prometheus.Registerer
+
+		slowRequestThreshold time.Duration
+		requestTimeout       time.Duration
+		forceSampleRoutes    []string
+		neverSampleRoutes    []string
+		authenticate         authenticator
+		decompress           requestDecompressor
+		accessLogFormat      AccessLogFormat
+
+		autoOptions           bool
+		routeMiddlewares      []routeMiddleware
+		limitConcurrency      bool
+		maxConcurrentRequests int
+		queueTimeout          time.Duration
 	}
 )
 
+const (
+	// AccessLogMessage logs each request with a human readable
+	// message such as "GET /x 200 1.2kB 3ms" along with the request
+	// attributes.
+	AccessLogMessage AccessLogFormat = iota
+
+	// AccessLogStructured logs each request with an empty message,
+	// the request attributes holding the route pattern and the
+	// duration in milliseconds as well.
+	AccessLogStructured
+
+	// AccessLogOff does not log the requests, except the ones failing
+	// with a 5xx status code or a panic.
+	AccessLogOff
+)
+
 // WithLogger is an option setter for specifying a logger for HTTP
 // telemetry and error logging.
 func WithLogger(l *log.Logger) Option {
@@ -61,9 +95,158 @@ func WithRegisterer(r prometheus.Registerer) Option {
 	}
 }
 
+// WithSlowRequestThreshold logs the requests taking longer than d at
+// the warning level with a slow attribute, even when they succeed. It
+// is disabled by default.
+func WithSlowRequestThreshold(d time.Duration) Option {
+	return func(o *Options) {
+		o.slowRequestThreshold = d
+	}
+}
+
+// WithRequestTimeout cancels the request context after d. If the
+// handler has not started to write the response by then, the request
+// is answered with a 504 and the handler writes are discarded. It is
+// disabled by default.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.requestTimeout = d
+	}
+}
+
+// WithForceSampleRoutes records and samples the spans of the requests
+// matching one of the given routes, e.g. "POST /checkout" or
+// "/orders/{id}", using the http.ServeMux pattern syntax. Such
+// requests are traced even without a parent span, and their sampling
+// decision overrides the parent one. The override is applied by the
+// sampler returned by otelutils.NewOverrideSampler, which must wrap the
+// sampler of the tracer provider.
+func WithForceSampleRoutes(routes []string) Option {
+	return func(o *Options) {
+		o.forceSampleRoutes = routes
+	}
+}
+
+// WithNeverSampleRoutes drops the spans of the requests matching one
+// of the given routes, using the http.ServeMux pattern syntax, even
+// when the parent span is sampled. Spans started by the handler from
+// the request context are dropped as well. As for
+// WithForceSampleRoutes, the tracer provider sampler must be wrapped
+// with otelutils.NewOverrideSampler.
+func WithNeverSampleRoutes(routes []string) Option {
+	return func(o *Options) {
+		o.neverSampleRoutes = routes
+	}
+}
+
+// WithBasicAuth requires every request to carry HTTP basic auth
+// credentials accepted by verify, answering the others with a 401
+// challenging the client for the given realm. Use
+// BasicAuthCredentials to compare the credentials in constant time. It
+// replaces any authentication set with WithBearerAuth.
+func WithBasicAuth(realm string, verify func(user, password string) bool) Option {
+	return func(o *Options) {
+		o.authenticate = newBasicAuthenticator(realm, verify)
+	}
+}
+
+// WithBearerAuth requires every request to carry a bearer token in the
+// Authorization header accepted by verify, answering the others with a
+// 401. The context returned by verify, which may carry the
+// authenticated subject, is the one of the request passed to the
+// handler. It replaces any authentication set with WithBasicAuth.
+func WithBearerAuth(verify func(ctx context.Context, token string) (context.Context, error)) Option {
+	return func(o *Options) {
+		o.authenticate = newBearerAuthenticator(verify)
+	}
+}
+
+// WithRequestDecompression decompresses the body of the requests
+// encoded with gzip or deflate according to their Content-Encoding
+// header, answering the requests using another encoding with a 415.
+// Reading more than maxSize bytes from a decompressed body fails with a
+// *http.MaxBytesError, which guards against decompression bombs. It
+// panics if maxSize is not positive.
+func WithRequestDecompression(maxSize int64) Option {
+	return func(o *Options) {
+		o.decompress = newRequestDecompressor(maxSize)
+	}
+}
+
+// WithAccessLogFormat sets the format of the log entry written for
+// each request. It defaults to AccessLogMessage.
+func WithAccessLogFormat(f AccessLogFormat) Option {
+	return func(o *Options) {
+		o.accessLogFormat = f
+	}
+}
+
+// WithAutomaticOptions answers the OPTIONS requests with a 204 and an
+// Allow header listing the methods routed for the request path, which
+// requires the handler passed to NewServer to be a chi router or an
+// http.ServeMux. CORS preflight requests, paths without any route and
+// requests to other handlers are still passed to the handler. Without
+// it, all the OPTIONS requests are passed to the handler, which is
+// needed when it answers them itself.
+func WithAutomaticOptions() Option {
+	return func(o *Options) {
+		o.autoOptions = true
+	}
+}
+
+// WithMaxConcurrentRequests bounds the number of requests served at
+// the same time to n. The requests beyond it wait up to queueTimeout
+// for another one to complete, and are answered with a 503 if none
+// does; they are rejected immediately when queueTimeout is zero. The
+// health check requests are not limited.
+//
+// The number of requests being served is exposed as the
+// http_server_requests_in_flight gauge, and the rejected ones are
+// counted in http_server_concurrency_rejections_total. It panics if n
+// is not positive or queueTimeout is negative.
+func WithMaxConcurrentRequests(n int, queueTimeout time.Duration) Option {
+	return func(o *Options) {
+		o.limitConcurrency = true
+		o.maxConcurrentRequests = n
+		o.queueTimeout = queueTimeout
+	}
+}
+
+// WithRouteMiddleware wraps the handler with the given middlewares for
+// the requests matching pattern, using the http.ServeMux pattern
+// syntax, e.g. "/admin/" for a subtree or "POST /orders". The first
+// middleware is the outermost one. The option may be used several
+// times; as with http.ServeMux, a request gets the middlewares of the
+// most specific pattern it matches only, and the ones of a pattern
+// given several times are chained in order.
+//
+// Unlike the middlewares wrapping the handler given to NewServer, the
+// route middlewares run within the server telemetry, the request
+// context holding its span. They run after its built-in checks, the
+// concurrency limit, the authentication and the request
+// decompression, within the request timeout, and before the handler,
+// and so before the middlewares of a router given as handler. NewServer
+// panics if a pattern is invalid or conflicts with another one.
+//
+// Example:
+//
+//	httpserver.NewServer(
+//	    ":8080",
+//	    router,
+//	    httpserver.WithRouteMiddleware("/admin/", requireAdmin, auditLog),
+//	)
+func WithRouteMiddleware(pattern string, mw ...func(http.Handler) http.Handler) Option {
+	return func(o *Options) {
+		o.routeMiddlewares = append(
+			o.routeMiddlewares,
+			routeMiddleware{pattern: pattern, middlewares: mw},
+		)
+	}
+}
+
 func NewServer(addr string, h http.Handler, options ...Option) *http.Server {
 	opts := &Options{
-		logger:         log.NewLogger(log.WithOutput(io.Discard)),
+		logger:         log.NewNopLogger(),
 		tracerProvider: otel.GetTracerProvider(),
 		registerer:     prometheus.DefaultRegisterer,
 	}
@@ -79,6 +262,24 @@ func NewServer(addr string, h http.Handler, options ...Option) *http.Server {
 		opts.tracerProvider,
 		opts.registerer,
 	)
+	handler.slowRequestThreshold = opts.slowRequestThreshold
+	handler.requestTimeout = opts.requestTimeout
+	handler.authenticate = opts.authenticate
+	handler.decompress = opts.decompress
+	handler.accessLogFormat = opts.accessLogFormat
+	handler.autoOptions = opts.autoOptions
+	if opts.limitConcurrency {
+		handler.limitConcurrency = newConcurrencyLimiter(
+			opts.maxConcurrentRequests,
+			opts.queueTimeout,
+			opts.registerer,
+		)
+	}
+	handler.routeMiddlewares = newRouteMiddlewares(h, opts.routeMiddlewares)
+	handler.samplingRoutes = newSamplingRoutes(
+		opts.forceSampleRoutes,
+		opts.neverSampleRoutes,
+	)
 
 	return &http.Server{
 		Addr:              addr,
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// probedMethods are the methods looked up in the router to build
+	// the Allow header of the OPTIONS responses.
+	probedMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+		http.MethodConnect,
+		http.MethodTrace,
+	}
+)
+
+// allowedMethods returns the methods routed by h for the path of r,
+// OPTIONS included, or nil when none is or h is neither a chi router
+// nor an http.ServeMux.
+func allowedMethods(h http.Handler, r *http.Request) []string {
+	var match func(method string) bool
+
+	switch router := h.(type) {
+	case chi.Routes:
+		match = func(method string) bool {
+			return router.Match(chi.NewRouteContext(), method, r.URL.Path)
+		}
+	case *http.ServeMux:
+		match = func(method string) bool {
+			r2 := r.Clone(r.Context())
+			r2.Method = method
+
+			// The mux returns an empty pattern when no route
+			// matches, including for a method not allowed.
+			_, pattern := router.Handler(r2)
+			return pattern != ""
+		}
+	default:
+		return nil
+	}
+
+	var methods []string
+	for _, method := range probedMethods {
+		if match(method) {
+			methods = append(methods, method)
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil
+	}
+
+	return append(methods, http.MethodOptions)
+}
+
+// serveOptions answers r with a 204 and the Allow header listing the
+// methods routed by h for its path. It returns false, writing
+// nothing, for CORS preflight requests and paths h does not route, so
+// they are served by h.
+func serveOptions(h http.Handler, w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("origin") != "" && r.Header.Get("access-control-request-method") != "" {
+		return false
+	}
+
+	methods := allowedMethods(h, r)
+	if methods == nil {
+		return false
+	}
+
+	w.Header().Set("allow", strings.Join(methods, ", "))
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
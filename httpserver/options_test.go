@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.gearno.de/kit/log"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestHandlerWrapperAutomaticOptions(t *testing.T) {
+	empty := func(w http.ResponseWriter, r *http.Request) {}
+
+	chiRouter := chi.NewRouter()
+	chiRouter.Get("/users/{id}", empty)
+	chiRouter.Post("/users/{id}", empty)
+	chiRouter.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	chiRouter.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	stdMux := http.NewServeMux()
+	stdMux.HandleFunc("GET /items/{id}", empty)
+	stdMux.HandleFunc("DELETE /items/{id}", empty)
+	stdMux.HandleFunc("OPTIONS /", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		path    string
+		header  http.Header
+		code    int
+		allow   string
+	}{
+		{
+			name:    "chi",
+			handler: chiRouter,
+			path:    "/users/42",
+			code:    http.StatusNoContent,
+			allow:   "GET, POST, OPTIONS",
+		},
+		{
+			name:    "std mux",
+			handler: stdMux,
+			path:    "/items/42",
+			code:    http.StatusNoContent,
+			allow:   "GET, HEAD, DELETE, OPTIONS",
+		},
+		{
+			name:    "unknown path",
+			handler: chiRouter,
+			path:    "/unknown",
+			code:    http.StatusTeapot,
+		},
+		{
+			name:    "cors preflight",
+			handler: stdMux,
+			path:    "/items/42",
+			header: http.Header{
+				"Origin":                        {"https://example.com"},
+				"Access-Control-Request-Method": {"DELETE"},
+			},
+			code: http.StatusTeapot,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hw := newHandlerWrapper(
+				tt.handler,
+				log.NewLogger(log.WithOutput(io.Discard)),
+				noop.NewTracerProvider(),
+				prometheus.NewRegistry(),
+			)
+			hw.autoOptions = true
+
+			r := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+			hw.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.allow, w.Header().Get("allow"))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		hw := newHandlerWrapper(
+			stdMux,
+			log.NewLogger(log.WithOutput(io.Discard)),
+			noop.NewTracerProvider(),
+			prometheus.NewRegistry(),
+		)
+
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/items/42", nil))
+
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
+}
@@ -18,18 +18,93 @@ package httpserver
 
 import (
 	"encoding/json"
+	"encoding/xml"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"go.gearno.de/x/panicf"
 )
 
+// RenderJSON writes v encoded as JSON with the given status code. It
+// panics when v cannot be encoded or written; use RenderJSONErr to
+// handle the error instead.
 func RenderJSON(w http.ResponseWriter, statusCode int, v any) {
+	if err := RenderJSONErr(w, statusCode, v); err != nil {
+		panicf.Panic("cannot json encode value: %w", err)
+	}
+}
+
+// RenderJSONErr writes v encoded as JSON with the given status code
+// and returns the encoding or write error, if any.
+func RenderJSONErr(w http.ResponseWriter, statusCode int, v any) error {
 	w.Header().Set("content-type", "application/json; charset=utf-8")
 	w.WriteHeader(statusCode)
-	if err := json.NewEncoder(w).Encode(v); err != nil {
-		panicf.Panic("cannot json encode value: %w", err)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// RenderXML writes v encoded as XML with the given status code. Like
+// RenderJSON, it panics when v cannot be encoded or written; use
+// RenderXMLErr to handle the error instead.
+func RenderXML(w http.ResponseWriter, statusCode int, v any) {
+	if err := RenderXMLErr(w, statusCode, v); err != nil {
+		panicf.Panic("cannot xml encode value: %w", err)
+	}
+}
+
+// RenderXMLErr writes v encoded as XML with the given status code and
+// returns the encoding or write error, if any.
+func RenderXMLErr(w http.ResponseWriter, statusCode int, v any) error {
+	w.Header().Set("content-type", "application/xml; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// Render writes v encoded in the format preferred by the client
+// according to the request Accept header. XML is used when the client
+// prefers application/xml or text/xml, JSON otherwise. The response
+// varies on the Accept header, so caches key it accordingly.
+func Render(w http.ResponseWriter, r *http.Request, statusCode int, v any) {
+	w.Header().Add("vary", "Accept")
+
+	if negotiateXML(r.Header.Get("accept")) {
+		RenderXML(w, statusCode, v)
+		return
+	}
+
+	RenderJSON(w, statusCode, v)
+}
+
+func negotiateXML(accept string) bool {
+	var jsonQ, xmlQ float64
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		}
 	}
+
+	return xmlQ > jsonQ
 }
 
 func RenderText(w http.ResponseWriter, statusCode int, v string) {
@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type renderPayload struct {
+	Name string `json:"name" xml:"name"`
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "application/json; charset=utf-8", "{\"name\":\"kit\"}\n"},
+		{"*/*", "application/json; charset=utf-8", "{\"name\":\"kit\"}\n"},
+		{"application/xml", "application/xml; charset=utf-8", "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<renderPayload><name>kit</name></renderPayload>"},
+		{"application/json;q=0.5, text/xml", "application/xml; charset=utf-8", "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<renderPayload><name>kit</name></renderPayload>"},
+		{"application/xml;q=0.5, application/json", "application/json; charset=utf-8", "{\"name\":\"kit\"}\n"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("accept", tt.accept)
+		w := httptest.NewRecorder()
+
+		Render(w, r, http.StatusOK, renderPayload{Name: "kit"})
+
+		assert.Equal(t, http.StatusOK, w.Code, tt.accept)
+		assert.Equal(t, tt.contentType, w.Header().Get("content-type"), tt.accept)
+		assert.Equal(t, []string{"Accept"}, w.Header().Values("vary"), tt.accept)
+		assert.Equal(t, tt.body, w.Body.String(), tt.accept)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"net/http"
+)
+
+type (
+	routeMiddleware struct {
+		pattern     string
+		middlewares []func(http.Handler) http.Handler
+	}
+
+	// routeHandler is registered in the route middlewares mux,
+	// holding the handler wrapped with the middlewares of the route.
+	routeHandler struct {
+		http.Handler
+	}
+
+	routePatternKey struct{}
+)
+
+// newRouteMiddlewares returns a mux matching the routes having
+// middlewares, each one serving next wrapped with them, or nil when
+// there are none. The middlewares of a pattern registered several
+// times are chained in registration order.
+func newRouteMiddlewares(next http.Handler, routes []routeMiddleware) *http.ServeMux {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	var (
+		patterns    []string
+		middlewares = make(map[string][]func(http.Handler) http.Handler)
+	)
+
+	for _, route := range routes {
+		if _, ok := middlewares[route.pattern]; !ok {
+			patterns = append(patterns, route.pattern)
+		}
+
+		middlewares[route.pattern] = append(middlewares[route.pattern], route.middlewares...)
+	}
+
+	// The middlewares may pass a request of their own to the router:
+	// the pattern the standard library mux sets on it is copied back
+	// for the metrics, see routeHandlerFor.
+	inner := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			if pattern, ok := r.Context().Value(routePatternKey{}).(*string); ok {
+				*pattern = r.Pattern
+			}
+		},
+	)
+
+	mux := http.NewServeMux()
+	for _, pattern := range patterns {
+		var (
+			h   http.Handler = inner
+			mws              = middlewares[pattern]
+		)
+
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+
+		mux.Handle(pattern, routeHandler{h})
+	}
+
+	return mux
+}
+
+// routeHandlerFor returns the handler serving r, wrapped with the
+// middlewares of the route it matches if any, along with the request
+// to serve it with and a function copying the pattern matched by the
+// standard library mux back to r once served.
+func (hw *handlerWrapper) routeHandlerFor(r *http.Request) (http.Handler, *http.Request, func()) {
+	if hw.routeMiddlewares == nil {
+		return hw.next, r, func() {}
+	}
+
+	h, _ := hw.routeMiddlewares.Handler(r)
+	route, ok := h.(routeHandler)
+	if !ok {
+		return hw.next, r, func() {}
+	}
+
+	var (
+		pattern = new(string)
+		r2      = r.WithContext(context.WithValue(r.Context(), routePatternKey{}, pattern))
+	)
+
+	return route.Handler, r2, func() { r2.Pattern = *pattern }
+}
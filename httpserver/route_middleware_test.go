@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type middlewareKey struct{}
+
+func TestWithRouteMiddleware(t *testing.T) {
+	tag := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Add("x-middleware", name)
+
+					// Pass a request of its own, as middlewares
+					// adding values to the context do.
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middlewareKey{}, name)))
+				},
+			)
+		}
+	}
+
+	var (
+		registry = prometheus.NewRegistry()
+		tp       = sdktrace.NewTracerProvider()
+		traced   bool
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /admin/users",
+		func(w http.ResponseWriter, r *http.Request) {
+			traced = trace.SpanFromContext(r.Context()).IsRecording()
+			w.Write([]byte(r.Context().Value(middlewareKey{}).(string)))
+		},
+	)
+	mux.HandleFunc(
+		"GET /public",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("public"))
+		},
+	)
+
+	server := NewServer(
+		"",
+		mux,
+		WithRegisterer(registry),
+		WithTracerProvider(tp),
+		WithRouteMiddleware("/admin/", tag("auth")),
+		WithRouteMiddleware("/admin/", tag("audit")),
+	)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		ctx, span := tp.Tracer("test").Start(context.Background(), "root")
+		defer span.End()
+
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+
+		return w
+	}
+
+	w := serve("/admin/users")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"auth", "audit"}, w.Header().Values("x-middleware"))
+	assert.Equal(t, "audit", w.Body.String())
+	assert.True(t, traced, "middlewares run within the server span")
+
+	w = serve("/public")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Values("x-middleware"))
+
+	// The route pattern set on the request of the middlewares is
+	// still used for the metrics.
+	expected := `
+		# HELP http_server_requests_total Total number of HTTP requests made.
+		# TYPE http_server_requests_total counter
+		http_server_requests_total{flavor="HTTP/1.1",host="example.com",method="GET",path="GET /admin/users",status_code="200"} 1
+		http_server_requests_total{flavor="HTTP/1.1",host="example.com",method="GET",path="GET /public",status_code="200"} 1
+	`
+	require.NoError(
+		t,
+		testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_server_requests_total"),
+	)
+}
+
+func TestWithRouteMiddlewareInvalidPattern(t *testing.T) {
+	assert.Panics(
+		t,
+		func() {
+			NewServer(
+				"",
+				http.NotFoundHandler(),
+				WithRegisterer(prometheus.NewRegistry()),
+				WithRouteMiddleware("GET", func(h http.Handler) http.Handler { return h }),
+			)
+		},
+	)
+}
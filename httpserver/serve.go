@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Serve serves s on l until ctx is done, then shuts s down gracefully:
+// l stops accepting connections and the requests in progress are
+// waited for, at most for shutdownTimeout when it is positive. Once
+// the timeout elapses, the remaining connections are closed and an
+// error is returned. Serve returns nil after a graceful shutdown,
+// including one started by calling s.Shutdown directly.
+//
+// It serves on listeners the server did not create, such as the one
+// inherited from systemd socket activation or one bound to an
+// ephemeral port in tests. The address given to NewServer is then
+// only used in the logs and may be empty.
+//
+// Example:
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:0")
+//	if err != nil {
+//	    return err
+//	}
+//
+//	server := httpserver.NewServer(l.Addr().String(), router)
+//	return httpserver.Serve(ctx, server, l, 10*time.Second)
+func Serve(ctx context.Context, s *http.Server, l net.Listener, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(l)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+
+		return fmt.Errorf("cannot serve http requests: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx := context.WithoutCancel(ctx)
+	if shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, shutdownTimeout)
+		defer cancel()
+	}
+
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		s.Close()
+		return fmt.Errorf("cannot shutdown http server: %w", err)
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("cannot serve http requests: %w", err)
+	}
+
+	return nil
+}
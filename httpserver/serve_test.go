@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	newServer := func(t *testing.T, h http.Handler) (*http.Server, net.Listener) {
+		t.Helper()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		return NewServer(l.Addr().String(), h, WithRegisterer(prometheus.NewRegistry())), l
+	}
+
+	t.Run("graceful shutdown", func(t *testing.T) {
+		var (
+			started = make(chan struct{})
+			release = make(chan struct{})
+		)
+
+		s, l := newServer(
+			t,
+			http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					<-release
+					w.Write([]byte("ok"))
+				},
+			),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- Serve(ctx, s, l, time.Second) }()
+
+		type result struct {
+			body string
+			err  error
+		}
+		responses := make(chan result, 1)
+		go func() {
+			resp, err := http.Get("http://" + l.Addr().String() + "/")
+			if err != nil {
+				responses <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			responses <- result{string(body), err}
+		}()
+
+		<-started
+		cancel()
+
+		// The in-flight request is waited for while the listener no
+		// longer accepts connections.
+		require.Eventually(
+			t,
+			func() bool {
+				conn, err := net.Dial("tcp", l.Addr().String())
+				if err == nil {
+					conn.Close()
+				}
+				return err != nil
+			},
+			time.Second,
+			5*time.Millisecond,
+		)
+
+		close(release)
+
+		r := <-responses
+		require.NoError(t, r.err)
+		assert.Equal(t, "ok", r.body)
+		assert.NoError(t, <-served)
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		var (
+			started = make(chan struct{})
+			release = make(chan struct{})
+		)
+		defer close(release)
+
+		s, l := newServer(
+			t,
+			http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					<-release
+				},
+			),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- Serve(ctx, s, l, 20*time.Millisecond) }()
+
+		go func() {
+			resp, err := http.Get("http://" + l.Addr().String() + "/")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+
+		<-started
+		cancel()
+
+		assert.ErrorIs(t, <-served, context.DeadlineExceeded)
+	})
+
+	t.Run("closed listener", func(t *testing.T) {
+		s, l := newServer(t, http.NotFoundHandler())
+		l.Close()
+
+		err := Serve(context.Background(), s, l, time.Second)
+		assert.ErrorContains(t, err, "cannot serve http requests")
+	})
+}
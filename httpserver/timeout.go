@@ -0,0 +1,193 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	// timeoutWriter guards the response writer shared between the
+	// handler goroutine and the request goroutine, so the handler
+	// cannot write anything once the timeout response has been sent.
+	timeoutWriter struct {
+		mu          sync.Mutex
+		w           WrapResponseWriter
+		h           http.Header
+		wroteHeader bool
+		timedOut    bool
+	}
+)
+
+var (
+	timeoutErrorResponse = map[string]string{
+		"error": "request timeout",
+	}
+)
+
+// serveWithTimeout runs next with a request context cancelled
+// after the request timeout. If the handler has not started to write
+// the response by then, a 504 is sent and the handler writes are
+// discarded from that point. A panic in the handler is propagated to
+// the calling goroutine. The pattern matched by the standard library
+// mux is copied back to r once the handler returns.
+//
+// When the request times out, the handler may still be running and
+// routing r: serveWithTimeout then returns the route pattern matched
+// without serving the request, see matchRoute, and r must not be
+// inspected any further. It returns an empty string otherwise.
+func (hw *handlerWrapper) serveWithTimeout(next http.Handler, w WrapResponseWriter, r *http.Request) string {
+	ctx, cancel := context.WithTimeout(r.Context(), hw.requestTimeout)
+	defer cancel()
+
+	var (
+		tw     = &timeoutWriter{w: w, h: make(http.Header)}
+		r2     = r.WithContext(ctx)
+		done   = make(chan struct{})
+		panics = make(chan any, 1)
+	)
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panics <- p
+			}
+		}()
+
+		next.ServeHTTP(tw, r2)
+		close(done)
+	}()
+
+	select {
+	case p := <-panics:
+		panic(p)
+	case <-done:
+		r.Pattern = r2.Pattern
+		return ""
+	case <-ctx.Done():
+	}
+
+	// The request was cancelled, most likely because the client went
+	// away: there is no one to answer, let the handler finish.
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		select {
+		case p := <-panics:
+			panic(p)
+		case <-done:
+			r.Pattern = r2.Pattern
+			return ""
+		}
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.timedOut = true
+	if !tw.wroteHeader {
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(timeoutErrorResponse)
+	}
+
+	return hw.matchRoute(r)
+}
+
+// matchRoute returns the route pattern the router matches for r
+// without serving it, or unknownRoutePattern when no route matches or
+// the router is neither chi nor the standard library mux.
+func (hw *handlerWrapper) matchRoute(r *http.Request) string {
+	switch router := hw.next.(type) {
+	case chi.Routes:
+		path := r.URL.RawPath
+		if path == "" {
+			path = r.URL.Path
+		}
+
+		rctx := chi.NewRouteContext()
+		if router.Match(rctx, r.Method, path) {
+			return rctx.RoutePattern()
+		}
+	case *http.ServeMux:
+		if _, pattern := router.Handler(r); pattern != "" {
+			return pattern
+		}
+	}
+
+	return unknownRoutePattern
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+
+	if fl, ok := tw.w.(http.Flusher); ok {
+		tw.writeHeader(http.StatusOK)
+		fl.Flush()
+	}
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+
+	tw.wroteHeader = true
+
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+
+	tw.w.WriteHeader(code)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestHandlerWrapperRequestTimeout(t *testing.T) {
+	var (
+		buf     bytes.Buffer
+		release = make(chan struct{})
+		written = make(chan error, 1)
+	)
+
+	hw := newHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/slow":
+					<-r.Context().Done()
+					<-release
+					_, err := w.Write([]byte("too late"))
+					written <- err
+				case "/panic":
+					panic("boom")
+				default:
+					w.Write([]byte("ok"))
+				}
+			},
+		),
+		log.NewLogger(log.WithOutput(&buf)),
+		noop.NewTracerProvider(),
+		prometheus.NewRegistry(),
+	)
+	hw.requestTimeout = 10 * time.Millisecond
+
+	t.Run("fast", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ok", w.Body.String())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(release)
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.JSONEq(t, `{"error":"request timeout"}`, w.Body.String())
+		assert.ErrorIs(t, <-written, http.ErrHandlerTimeout)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, float64(http.StatusGatewayTimeout), entry["http_response_status"])
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestHandlerWrapperRequestTimeoutRoute(t *testing.T) {
+	// The handlers keep running after the timeout response, while
+	// the metrics are recorded: run with -race.
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("too late"))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", slow)
+
+	users := chi.NewRouter()
+	users.Get("/{id}", slow)
+	router := chi.NewRouter()
+	router.Mount("/users", users)
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		path    string
+	}{
+		{"chi", router, "/users/{id}"},
+		{"mux", mux, "GET /users/{id}"},
+	} {
+		t.Run(
+			tc.name,
+			func(t *testing.T) {
+				registry := prometheus.NewRegistry()
+				hw := newHandlerWrapper(
+					tc.handler,
+					log.NewLogger(log.WithOutput(io.Discard)),
+					noop.NewTracerProvider(),
+					registry,
+				)
+				hw.requestTimeout = 10 * time.Millisecond
+
+				w := httptest.NewRecorder()
+				hw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+				assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+				expected := `
+					# HELP http_server_requests_total Total number of HTTP requests made.
+					# TYPE http_server_requests_total counter
+					http_server_requests_total{flavor="HTTP/1.1",host="example.com",method="GET",path="` + tc.path + `",status_code="504"} 1
+				`
+				require.NoError(
+					t,
+					testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_server_requests_total"),
+				)
+
+				// Let the handler finish before the next test.
+				time.Sleep(50 * time.Millisecond)
+			},
+		)
+	}
+}
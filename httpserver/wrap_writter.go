@@ -164,7 +164,7 @@ type flushWriter struct {
 }
 
 func (f *flushWriter) Flush() {
-	f.wroteHeader = true
+	f.maybeWriteHeader()
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
 	fl.Flush()
 }
@@ -189,7 +189,7 @@ type flushHijackWriter struct {
 }
 
 func (f *flushHijackWriter) Flush() {
-	f.wroteHeader = true
+	f.maybeWriteHeader()
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
 	fl.Flush()
 }
@@ -211,7 +211,7 @@ type httpFancyWriter struct {
 }
 
 func (f *httpFancyWriter) Flush() {
-	f.wroteHeader = true
+	f.maybeWriteHeader()
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
 	fl.Flush()
 }
@@ -252,7 +252,7 @@ type http2FancyWriter struct {
 }
 
 func (f *http2FancyWriter) Flush() {
-	f.wroteHeader = true
+	f.maybeWriteHeader()
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
 	fl.Flush()
 }
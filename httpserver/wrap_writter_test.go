@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapResponseWriterStreaming(t *testing.T) {
+	var (
+		next   = make(chan struct{})
+		status = make(chan int, 1)
+	)
+
+	hw := newTestHandlerWrapper(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				_, ok := w.(http.Hijacker)
+				assert.True(t, ok, "response writer must implement http.Hijacker")
+
+				w.Header().Set("content-type", "text/event-stream")
+				for i := 0; i < 3; i++ {
+					fmt.Fprintf(w, "data: %d\n\n", i)
+					w.(http.Flusher).Flush()
+
+					select {
+					case <-next:
+					case <-time.After(5 * time.Second):
+						return
+					}
+				}
+
+				status <- w.(WrapResponseWriter).Status()
+			},
+		),
+	)
+
+	srv := httptest.NewServer(hw)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("content-type"))
+
+	// Each event must reach the client before the handler is allowed
+	// to write the next one, otherwise the read would block until the
+	// handler gives up.
+	br := bufio.NewReader(resp.Body)
+	for i := 0; i < 3; i++ {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("data: %d\n", i), line)
+
+		_, err = br.ReadString('\n')
+		require.NoError(t, err)
+
+		next <- struct{}{}
+	}
+
+	assert.Equal(t, http.StatusOK, <-status)
+}
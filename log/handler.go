@@ -0,0 +1,168 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package log
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"unicode/utf8"
+
+	"go.gearno.de/kit/otelutils"
+)
+
+type (
+	// leveledHandler is a slog.Handler filtering the records below
+	// level before passing them to the wrapped handler.
+	leveledHandler struct {
+		slog.Handler
+		level slog.Leveler
+	}
+
+	// levelRangeHandler is a slog.Handler passing the records with a
+	// level in [min, max) to the wrapped handler.
+	levelRangeHandler struct {
+		slog.Handler
+		min slog.Level
+		max slog.Level
+	}
+
+	// utf8Handler is a slog.Handler replacing the invalid UTF-8 of
+	// the records before passing them to the wrapped handler.
+	utf8Handler struct {
+		slog.Handler
+	}
+)
+
+var (
+	_ slog.Handler = (*leveledHandler)(nil)
+	_ slog.Handler = (*levelRangeHandler)(nil)
+	_ slog.Handler = (*utf8Handler)(nil)
+)
+
+func (h *leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// newLevelOutputsHandler returns a handler writing the records as JSON
+// to the output of the highest level not above theirs.
+func newLevelOutputsHandler(outputs map[Level]io.Writer, options *slog.HandlerOptions) slog.Handler {
+	levels := slices.Sorted(maps.Keys(outputs))
+
+	handlers := make(teeHandler, len(levels))
+	for i, level := range levels {
+		h := &levelRangeHandler{
+			Handler: slog.NewJSONHandler(outputs[level], options),
+			min:     level,
+			max:     slog.Level(math.MaxInt),
+		}
+
+		if i+1 < len(levels) {
+			h.max = levels[i+1]
+		}
+
+		handlers[i] = h
+	}
+
+	return handlers
+}
+
+func (h *levelRangeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.min && level < h.max && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelRangeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelRangeHandler{Handler: h.Handler.WithAttrs(attrs), min: h.min, max: h.max}
+}
+
+func (h *levelRangeHandler) WithGroup(name string) slog.Handler {
+	return &levelRangeHandler{Handler: h.Handler.WithGroup(name), min: h.min, max: h.max}
+}
+
+func (h *utf8Handler) Handle(ctx context.Context, r slog.Record) error {
+	r2 := slog.NewRecord(r.Time, r.Level, otelutils.ToValidUTF8(r.Message), r.PC)
+	r.Attrs(
+		func(a slog.Attr) bool {
+			r2.AddAttrs(validUTF8Attr(a))
+			return true
+		},
+	)
+
+	return h.Handler.Handle(ctx, r2)
+}
+
+func (h *utf8Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sanitized := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		sanitized[i] = validUTF8Attr(a)
+	}
+
+	return &utf8Handler{Handler: h.Handler.WithAttrs(sanitized)}
+}
+
+func (h *utf8Handler) WithGroup(name string) slog.Handler {
+	return &utf8Handler{Handler: h.Handler.WithGroup(otelutils.ToValidUTF8(name))}
+}
+
+func validUTF8Attr(a slog.Attr) slog.Attr {
+	a.Key = otelutils.ToValidUTF8(a.Key)
+	a.Value = validUTF8Value(a.Value.Resolve())
+
+	return a
+}
+
+func validUTF8Value(v slog.Value) slog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.StringValue(otelutils.ToValidUTF8(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		sanitized := make([]slog.Attr, len(attrs))
+		for i, a := range attrs {
+			sanitized[i] = validUTF8Attr(a)
+		}
+
+		return slog.GroupValue(sanitized...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case []string:
+			sanitized := make([]string, len(x))
+			for i, s := range x {
+				sanitized[i] = otelutils.ToValidUTF8(s)
+			}
+
+			return slog.AnyValue(sanitized)
+		case error:
+			if msg := x.Error(); !utf8.ValidString(msg) {
+				return slog.StringValue(otelutils.ToValidUTF8(msg))
+			}
+		}
+	}
+
+	return v
+}
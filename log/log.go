@@ -18,11 +18,14 @@ package log
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
+	"slices"
 	"time"
 
+	otellog "go.opentelemetry.io/otel/log"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -32,9 +35,42 @@ type (
 	Logger struct {
 		logger     *slog.Logger
 		output     io.Writer
+		outputs    map[Level]io.Writer
 		path       string
 		level      *slog.LevelVar
 		attributes []Attr
+		groups     []group
+		keyNames   KeyNames
+		timeFormat string
+		omitTime   bool
+		validUTF8  bool
+		nop        bool
+
+		replaceAttrFunc func([]string, slog.Attr) slog.Attr
+
+		loggerProvider otellog.LoggerProvider
+		handler        slog.Handler
+		contextAttrs   func(context.Context) []Attr
+	}
+
+	// group is a group opened with WithGroup and the attributes added
+	// to it with With.
+	group struct {
+		name       string
+		attributes []Attr
+	}
+
+	// KeyNames holds the keys used for the built-in attributes of
+	// each log entry. An empty name keeps the default key.
+	KeyNames struct {
+		Time    string
+		Level   string
+		Message string
+		Source  string
+
+		// Name is the key holding the logger name set with
+		// WithName or Named. It defaults to DefaultNameKey.
+		Name string
 	}
 
 	// Option configures Logger during initialization.
@@ -46,6 +82,18 @@ type (
 	// Attr represents an attribute (key-value pair) added to log
 	// entries for structured logging.
 	Attr = slog.Attr
+
+	// StackTracer is implemented by errors carrying the stack trace
+	// of where they were created.
+	StackTracer interface {
+		StackTrace() string
+	}
+)
+
+const (
+	// DefaultNameKey is the key holding the logger name in log
+	// entries unless KeyNames.Name is set.
+	DefaultNameKey = "logger"
 )
 
 var (
@@ -70,8 +118,32 @@ func WithOutput(w io.Writer) Option {
 
 }
 
+// WithLevelOutputs routes the log entries to a writer according to
+// their level, replacing the output set with WithOutput. Each key is
+// the lowest level written to its writer: an entry is written once, to
+// the writer with the highest key not above its level, and the entries
+// below the lowest key are discarded. The level of the Logger still
+// applies.
+//
+// Example:
+//
+//	logger := log.NewLogger(
+//	    log.WithLevelOutputs(
+//	        map[log.Level]io.Writer{
+//	            log.LevelDebug: os.Stdout,
+//	            log.LevelWarn:  os.Stderr,
+//	        },
+//	    ),
+//	)
+func WithLevelOutputs(outputs map[Level]io.Writer) Option {
+	return func(l *Logger) {
+		l.outputs = outputs
+	}
+}
+
 // WithName assigns a name to the Logger, useful for identifying the
-// logging source in a multi-module setup.
+// logging source in a multi-module setup. The name is added to every
+// log entry under the KeyNames.Name key.
 func WithName(name string) Option {
 	return func(l *Logger) {
 		l.path = name
@@ -86,6 +158,125 @@ func WithAttributes(attrs ...Attr) Option {
 	}
 }
 
+// WithHandler writes the log entries to the given slog.Handler
+// instead of the JSON output, for instance to capture them in tests.
+// The level of the Logger still applies, but the handler is in charge
+// of the formatting: WithOutput, WithLevelOutputs, WithKeyNames,
+// WithTimeFormat, WithoutTime and WithReplaceAttr have no effect.
+func WithHandler(h slog.Handler) Option {
+	return func(l *Logger) {
+		l.handler = h
+	}
+}
+
+// WithTimeFormat formats the time of the log entries with the given
+// layout, such as time.RFC3339Nano, instead of the default RFC 3339
+// format with millisecond precision.
+func WithTimeFormat(layout string) Option {
+	return func(l *Logger) {
+		l.timeFormat = layout
+	}
+}
+
+// WithoutTime drops the time of the log entries, for environments such
+// as container runtimes already timestamping each line.
+func WithoutTime() Option {
+	return func(l *Logger) {
+		l.omitTime = true
+	}
+}
+
+// WithAttrsFromContext sets a function extracting attributes from the
+// context of each log entry, such as the tenant or user of the request
+// stored there by a middleware, so every entry logged with the request
+// context carries them. The attributes are added at the top level,
+// before the ones given to the log call, which take precedence for
+// the same key, and derived loggers inherit the function. It is only
+// called for the entries enabled by the level, and must return quickly.
+func WithAttrsFromContext(extractor func(ctx context.Context) []Attr) Option {
+	return func(l *Logger) {
+		l.contextAttrs = extractor
+	}
+}
+
+// WithReplaceAttr sets a function rewriting each attribute of the
+// JSON output before it is written, as slog.HandlerOptions.ReplaceAttr
+// does, for instance to drop or reformat attributes. fn is called
+// first, with the default keys of the built-in attributes such as
+// slog.TimeKey; WithTimeFormat, WithoutTime and WithKeyNames then
+// apply to the attribute it returns. An attribute with an empty key
+// returned by fn is dropped. It has no effect with WithHandler.
+func WithReplaceAttr(fn func(groups []string, a slog.Attr) slog.Attr) Option {
+	return func(l *Logger) {
+		l.replaceAttrFunc = fn
+	}
+}
+
+// WithValidUTF8 replaces each run of invalid UTF-8 byte sequences in
+// the message, attribute keys and string values of the log entries
+// with the Unicode replacement character, as the tracer provider of
+// otelutils does for spans. It applies before any handler, so the
+// entries exported with WithOTLPExport or given to the handler set
+// with WithHandler are sanitized as well, user controlled data
+// otherwise making log pipelines reject them. Slices of strings and
+// errors with an invalid message are sanitized too, the latter being
+// replaced by their message.
+func WithValidUTF8() Option {
+	return withValidUTF8(true)
+}
+
+func withValidUTF8(enabled bool) Option {
+	return func(l *Logger) {
+		l.validUTF8 = enabled
+	}
+}
+
+func withTime(layout string, omit bool) Option {
+	return func(l *Logger) {
+		l.timeFormat = layout
+		l.omitTime = omit
+	}
+}
+
+func withGroups(groups []group) Option {
+	return func(l *Logger) {
+		l.groups = groups
+	}
+}
+
+// WithKeyNames renames the built-in time, level, message and source
+// keys of the log entries, so they match the schema expected by the
+// log pipeline. Top-level attributes using one of the default slog
+// keys are renamed as well.
+func WithKeyNames(cfg KeyNames) Option {
+	return func(l *Logger) {
+		l.keyNames = cfg
+	}
+}
+
+// GCPKeys returns the key names recognized by Google Cloud Logging
+// structured logs.
+func GCPKeys() KeyNames {
+	return KeyNames{
+		Time:    "time",
+		Level:   "severity",
+		Message: "message",
+		Source:  "logging.googleapis.com/sourceLocation",
+		Name:    DefaultNameKey,
+	}
+}
+
+// ECSKeys returns the key names defined by the Elastic Common Schema.
+func ECSKeys() KeyNames {
+	return KeyNames{
+		Time:    "@timestamp",
+		Level:   "log.level",
+		Message: "message",
+		Source:  "log.origin",
+		Name:    "log.logger",
+	}
+}
+
 // Any creates a key-value attribute with any data type.
 func Any(k string, v any) Attr {
 	return slog.Any(k, v)
@@ -137,6 +328,38 @@ func Error(err error) Attr {
 	return String("error", err.Error())
 }
 
+// ErrorAttr creates an "error" group attribute from an error, holding
+// the error message, the messages of the errors it wraps as returned
+// by errors.Unwrap, and the stack trace of the first error of the
+// chain implementing StackTracer, if any.
+func ErrorAttr(err error) Attr {
+	var (
+		attrs = []any{String("message", err.Error())}
+		chain []string
+		stack string
+	)
+
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if e != err {
+			chain = append(chain, e.Error())
+		}
+
+		if st, ok := e.(StackTracer); ok && stack == "" {
+			stack = st.StackTrace()
+		}
+	}
+
+	if len(chain) > 0 {
+		attrs = append(attrs, Any("chain", chain))
+	}
+
+	if stack != "" {
+		attrs = append(attrs, String("stack", stack))
+	}
+
+	return slog.Group("error", attrs...)
+}
+
 // NewLogger initializes a new Logger with optional configurations for
 // level, output, and default attributes.
 func NewLogger(options ...Option) *Logger {
@@ -149,34 +372,177 @@ func NewLogger(options ...Option) *Logger {
 		option(l)
 	}
 
-	handler := slog.NewJSONHandler(
-		l.output,
-		&slog.HandlerOptions{
-			Level: l.level,
-		},
-	).WithAttrs(l.attributes)
+	attrs := l.attributes
+	if l.path != "" {
+		attrs = append(
+			[]Attr{String(l.keyNames.name(), l.path)},
+			attrs...,
+		)
+	}
+
+	handlerOptions := &slog.HandlerOptions{
+		Level:       l.level,
+		ReplaceAttr: l.replaceAttr,
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(l.output, handlerOptions)
+	if len(l.outputs) > 0 {
+		handler = newLevelOutputsHandler(l.outputs, handlerOptions)
+	}
+
+	if l.handler != nil {
+		handler = &leveledHandler{Handler: l.handler, level: l.level}
+	}
+
+	if l.loggerProvider != nil {
+		handler = teeHandler{handler, newOTLPHandler(l.loggerProvider, l.level)}
+	}
+
+	if l.validUTF8 {
+		handler = &utf8Handler{Handler: handler}
+	}
 
-	l.logger = slog.New(handler)
+	l.logger = slog.New(handler.WithAttrs(attrs))
 
 	return l
 }
 
+// NewNopLogger returns a Logger discarding every log entry without
+// formatting it, making disabled logging free. Loggers derived from it
+// with With, WithGroup or Named discard their entries as well.
+func NewNopLogger() *Logger {
+	return &Logger{
+		logger: slog.New(nopHandler{}),
+		output: io.Discard,
+		level:  new(slog.LevelVar),
+		nop:    true,
+	}
+}
+
+func (kn KeyNames) name() string {
+	if kn.Name != "" {
+		return kn.Name
+	}
+
+	return DefaultNameKey
+}
+
+func (l *Logger) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if l.replaceAttrFunc != nil {
+		a = l.replaceAttrFunc(groups, a)
+		if a.Key == "" {
+			return a
+		}
+	}
+
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		if l.omitTime {
+			return slog.Attr{}
+		}
+
+		if l.timeFormat != "" && a.Value.Kind() == slog.KindTime {
+			a.Value = slog.StringValue(a.Value.Time().Format(l.timeFormat))
+		}
+	}
+
+	return l.keyNames.replaceAttr(groups, a)
+}
+
+func (kn KeyNames) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+
+	var name string
+	switch a.Key {
+	case slog.TimeKey:
+		name = kn.Time
+	case slog.LevelKey:
+		name = kn.Level
+	case slog.MessageKey:
+		name = kn.Message
+	case slog.SourceKey:
+		name = kn.Source
+	}
+
+	if name != "" {
+		a.Key = name
+	}
+
+	return a
+}
+
 // With returns a new Logger with additional attributes, keeping the
-// original Logger’s name and settings.
+// original Logger’s name and settings. The attributes are added to the
+// innermost group opened with WithGroup, if any.
 func (l *Logger) With(attrs ...Attr) *Logger {
+	if l.nop {
+		return l
+	}
+
+	var (
+		attributes = l.attributes
+		groups     = slices.Clone(l.groups)
+	)
+
+	if len(groups) == 0 {
+		attributes = append(slices.Clip(attributes), attrs...)
+	} else {
+		g := &groups[len(groups)-1]
+		g.attributes = append(slices.Clip(g.attributes), attrs...)
+	}
+
+	return NewLogger(
+		WithName(l.path),
+		WithOutput(l.output),
+		WithLevelOutputs(l.outputs),
+		WithLevel(l.level.Level()),
+		WithKeyNames(l.keyNames),
+		withTime(l.timeFormat, l.omitTime),
+		withValidUTF8(l.validUTF8),
+		WithReplaceAttr(l.replaceAttrFunc),
+		WithAttrsFromContext(l.contextAttrs),
+		WithOTLPExport(l.loggerProvider),
+		WithHandler(l.handler),
+		WithAttributes(attributes...),
+		withGroups(groups),
+	)
+}
+
+// WithGroup returns a new Logger nesting the attributes of the log
+// entries, and the ones added with With, under the name key, keeping
+// the original Logger’s name and settings. The logger name and the
+// trace and span IDs stay at the top level. An empty name returns the
+// Logger unchanged.
+func (l *Logger) WithGroup(name string) *Logger {
+	if name == "" || l.nop {
+		return l
+	}
+
 	return NewLogger(
 		WithName(l.path),
 		WithOutput(l.output),
+		WithLevelOutputs(l.outputs),
 		WithLevel(l.level.Level()),
-		WithAttributes(
-			append(l.attributes, attrs...)...,
-		),
+		WithKeyNames(l.keyNames),
+		withTime(l.timeFormat, l.omitTime),
+		withValidUTF8(l.validUTF8),
+		WithReplaceAttr(l.replaceAttrFunc),
+		WithAttrsFromContext(l.contextAttrs),
+		WithOTLPExport(l.loggerProvider),
+		WithHandler(l.handler),
+		WithAttributes(l.attributes...),
+		withGroups(append(slices.Clone(l.groups), group{name: name})),
 	)
 }
 
 // Named returns a new Logger with a modified name, appending the
 // given name to the current Logger’s path.
 func (l *Logger) Named(name string, options ...Option) *Logger {
+	if l.nop {
+		return l
+	}
+
 	newPath := l.path
 	if newPath != "" {
 		newPath += "."
@@ -185,8 +551,17 @@ func (l *Logger) Named(name string, options ...Option) *Logger {
 
 	inheritedOptions := []Option{
 		WithOutput(l.output),
+		WithLevelOutputs(l.outputs),
 		WithLevel(l.level.Level()),
+		WithKeyNames(l.keyNames),
+		withTime(l.timeFormat, l.omitTime),
+		withValidUTF8(l.validUTF8),
+		WithReplaceAttr(l.replaceAttrFunc),
+		WithAttrsFromContext(l.contextAttrs),
+		WithOTLPExport(l.loggerProvider),
+		WithHandler(l.handler),
 		WithAttributes(l.attributes...),
+		withGroups(slices.Clone(l.groups)),
 	}
 
 
@@ -199,6 +574,31 @@ func (l *Logger) Named(name string, options ...Option) *Logger {
 // Log logs a message at the specified level with optional attributes,
 // adding trace and span IDs if the context has a span.
 func (l *Logger) Log(ctx context.Context, level Level, msg string, args ...Attr) {
+	// The entry is only built when enabled, so disabled levels cost
+	// no allocation.
+	if l.nop || !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	// The groups are built for each entry rather than opened on the
+	// handler, so the trace and span IDs stay at the top level. The
+	// default attributes are not part of args: the handler holds
+	// them, and the ones of the groups are added here only.
+	for i := len(l.groups) - 1; i >= 0; i-- {
+		g := l.groups[i]
+
+		args = []Attr{
+			{
+				Key:   g.name,
+				Value: slog.GroupValue(slices.Concat(g.attributes, args)...),
+			},
+		}
+	}
+
+	if l.contextAttrs != nil {
+		args = slices.Concat(l.contextAttrs(ctx), args)
+	}
+
 	span := trace.SpanFromContext(ctx)
 
 	if span.IsRecording() {
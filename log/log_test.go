@@ -0,0 +1,507 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/logtest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func decodeEntry(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	buf.Reset()
+
+	return entry
+}
+
+func TestWithKeyNames(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewLogger(WithOutput(&buf)).Info("hello")
+
+		entry := decodeEntry(t, &buf)
+		assert.Equal(t, "INFO", entry["level"])
+		assert.Equal(t, "hello", entry["msg"])
+		assert.Contains(t, entry, "time")
+	})
+
+	t.Run("gcp", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := NewLogger(WithOutput(&buf), WithKeyNames(GCPKeys()))
+
+		l.Info("hello")
+		entry := decodeEntry(t, &buf)
+		assert.Equal(t, "INFO", entry["severity"])
+		assert.Equal(t, "hello", entry["message"])
+		assert.NotContains(t, entry, "level")
+		assert.NotContains(t, entry, "msg")
+
+		l.Named("child").With(String("foo", "bar")).Info("derived")
+		entry = decodeEntry(t, &buf)
+		assert.Equal(t, "derived", entry["message"])
+		assert.Equal(t, "bar", entry["foo"])
+	})
+
+	t.Run("ecs", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewLogger(WithOutput(&buf), WithKeyNames(ECSKeys())).Warn("hello")
+
+		entry := decodeEntry(t, &buf)
+		assert.Equal(t, "WARN", entry["log.level"])
+		assert.Equal(t, "hello", entry["message"])
+		assert.Contains(t, entry, "@timestamp")
+	})
+}
+
+func TestWithTimeFormat(t *testing.T) {
+	t.Run("layout", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := NewLogger(WithOutput(&buf), WithTimeFormat(time.RFC3339Nano))
+
+		l.Info("hello")
+		entry := decodeEntry(t, &buf)
+		require.IsType(t, "", entry["time"])
+		ts, err := time.Parse(time.RFC3339Nano, entry["time"].(string))
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), ts, time.Minute)
+
+		l.Named("child").Info("derived")
+		entry = decodeEntry(t, &buf)
+		_, err = time.Parse(time.RFC3339Nano, entry["time"].(string))
+		assert.NoError(t, err)
+	})
+
+	t.Run("custom key", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewLogger(
+			WithOutput(&buf),
+			WithKeyNames(ECSKeys()),
+			WithTimeFormat(time.DateOnly),
+		).Info("hello")
+
+		entry := decodeEntry(t, &buf)
+		assert.Equal(t, time.Now().Format(time.DateOnly), entry["@timestamp"])
+	})
+
+	t.Run("without time", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := NewLogger(WithOutput(&buf), WithoutTime())
+
+		l.Info("hello")
+		entry := decodeEntry(t, &buf)
+		assert.NotContains(t, entry, "time")
+		assert.Equal(t, "hello", entry["msg"])
+
+		l.With(String("foo", "bar")).WithGroup("g").Info("derived", Time("time", time.Now()))
+		entry = decodeEntry(t, &buf)
+		assert.NotContains(t, entry, "time")
+		assert.Contains(t, entry["g"], "time")
+	})
+}
+
+func TestWithReplaceAttr(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(
+		WithOutput(&buf),
+		WithKeyNames(ECSKeys()),
+		WithReplaceAttr(
+			func(groups []string, a slog.Attr) slog.Attr {
+				switch {
+				case a.Key == "password":
+					return slog.Attr{}
+				case a.Value.Kind() == slog.KindDuration:
+					return slog.Int64(a.Key+"_ms", a.Value.Duration().Milliseconds())
+				case len(groups) == 0 && a.Key == slog.MessageKey:
+					// The built-in keys are the default ones,
+					// renamed afterwards.
+					return slog.String(a.Key, strings.ToUpper(a.Value.String()))
+				}
+
+				return a
+			},
+		),
+	)
+
+	l.Info("hello", String("password", "secret"), Duration("elapsed", 1500*time.Millisecond))
+	entry := decodeEntry(t, &buf)
+	assert.NotContains(t, entry, "password")
+	assert.Equal(t, 1500.0, entry["elapsed_ms"])
+	assert.Equal(t, "HELLO", entry["message"])
+
+	// Derived loggers inherit the function.
+	l.Named("child").WithGroup("g").Info("derived", Duration("elapsed", time.Second))
+	entry = decodeEntry(t, &buf)
+	assert.Equal(t, map[string]any{"elapsed_ms": 1000.0}, entry["g"])
+}
+
+func TestWithValidUTF8(t *testing.T) {
+	var (
+		buf      bytes.Buffer
+		recorder = logtest.NewRecorder()
+	)
+
+	l := NewLogger(
+		WithOutput(&buf),
+		WithValidUTF8(),
+		WithOTLPExport(recorder),
+	).Named("child").With(String("user", "bob\xff")).WithGroup("query")
+
+	l.Info(
+		"login \xff\xfe failed",
+		String("k\xfe", "v"),
+		Any("tags", []string{"ok", "x\xc3"}),
+		Error(errors.New("bad \xff input")),
+	)
+
+	line := buf.Bytes()
+	assert.True(t, utf8.Valid(line))
+
+	entry := decodeEntry(t, &buf)
+	// A run of invalid bytes is replaced by a single character.
+	assert.Equal(t, "login \ufffd failed", entry["msg"])
+	assert.Equal(t, "bob\ufffd", entry["user"])
+	assert.Equal(
+		t,
+		map[string]any{
+			"k\ufffd": "v",
+			"tags":    []any{"ok", "x\ufffd"},
+			"error":   "bad \ufffd input",
+		},
+		entry["query"],
+	)
+
+	var records []logtest.EmittedRecord
+	for _, scope := range recorder.Result() {
+		records = append(records, scope.Records...)
+	}
+	require.Len(t, records, 1)
+
+	record := records[0]
+	assert.Equal(t, "login \ufffd failed", record.Body().AsString())
+	record.WalkAttributes(
+		func(kv otellog.KeyValue) bool {
+			assert.True(t, utf8.ValidString(kv.Key), "key %q", kv.Key)
+			assert.True(t, utf8.ValidString(kv.Value.String()), "value of %q: %q", kv.Key, kv.Value)
+			return true
+		},
+	)
+}
+
+type tenantKey struct{}
+
+func TestWithAttrsFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(
+		WithOutput(&buf),
+		WithAttrsFromContext(
+			func(ctx context.Context) []Attr {
+				tenant, ok := ctx.Value(tenantKey{}).(string)
+				if !ok {
+					return nil
+				}
+
+				return []Attr{String("tenant_id", tenant)}
+			},
+		),
+	)
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+
+	l.InfoCtx(ctx, "hello")
+	entry := decodeEntry(t, &buf)
+	assert.Equal(t, "acme", entry["tenant_id"])
+
+	l.Info("no context")
+	entry = decodeEntry(t, &buf)
+	assert.NotContains(t, entry, "tenant_id")
+
+	// The attributes of the call come after, overriding them.
+	l.InfoCtx(ctx, "override", String("tenant_id", "other"))
+	entry = decodeEntry(t, &buf)
+	assert.Equal(t, "other", entry["tenant_id"])
+
+	// Derived loggers inherit the extractor, and the attributes stay
+	// at the top level.
+	l.Named("child").WithGroup("request").InfoCtx(ctx, "derived", String("path", "/"))
+	entry = decodeEntry(t, &buf)
+	assert.Equal(t, "acme", entry["tenant_id"])
+	assert.Equal(t, map[string]any{"path": "/"}, entry["request"])
+}
+
+func TestLoggerName(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(WithOutput(&buf)).Named("http").Named("server")
+
+	l.Info("hello", String("name", "john"))
+	entry := decodeEntry(t, &buf)
+	assert.Equal(t, "http.server", entry[DefaultNameKey])
+	assert.Equal(t, "john", entry["name"])
+
+	l.With(String("foo", "bar")).Info("hello")
+	entry = decodeEntry(t, &buf)
+	assert.Equal(t, "http.server", entry[DefaultNameKey])
+
+	NewLogger(WithOutput(&buf), WithKeyNames(ECSKeys()), WithName("worker")).Info("hello")
+	entry = decodeEntry(t, &buf)
+	assert.Equal(t, "worker", entry["log.logger"])
+
+	NewLogger(WithOutput(&buf)).Info("hello")
+	entry = decodeEntry(t, &buf)
+	assert.NotContains(t, entry, DefaultNameKey)
+}
+
+type stackError struct {
+	err error
+}
+
+func (e *stackError) Error() string      { return e.err.Error() }
+func (e *stackError) Unwrap() error      { return e.err }
+func (e *stackError) StackTrace() string { return "main.main()\n\tmain.go:42" }
+
+func TestErrorAttr(t *testing.T) {
+	t.Run("chain and stack", func(t *testing.T) {
+		var (
+			buf  bytes.Buffer
+			root = errors.New("connection refused")
+			err  = fmt.Errorf("cannot load user: %w", &stackError{fmt.Errorf("cannot query: %w", root)})
+		)
+
+		NewLogger(WithOutput(&buf)).Error("failed", ErrorAttr(err))
+
+		entry := decodeEntry(t, &buf)
+		assert.Equal(
+			t,
+			map[string]any{
+				"message": "cannot load user: cannot query: connection refused",
+				"chain": []any{
+					"cannot query: connection refused",
+					"cannot query: connection refused",
+					"connection refused",
+				},
+				"stack": "main.main()\n\tmain.go:42",
+			},
+			entry["error"],
+		)
+	})
+
+	t.Run("plain error", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		NewLogger(WithOutput(&buf)).Error("failed", ErrorAttr(errors.New("boom")))
+
+		entry := decodeEntry(t, &buf)
+		assert.Equal(t, map[string]any{"message": "boom"}, entry["error"])
+	})
+}
+
+func TestWithGroup(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := NewLogger(WithOutput(&buf), WithName("app"), WithAttributes(String("env", "prod")))
+
+	db := l.WithGroup("db").With(String("table", "users"))
+	db.Info("query", Int("rows", 2))
+
+	entry := decodeEntry(t, &buf)
+	assert.Equal(t, "app", entry["logger"])
+	assert.Equal(t, "prod", entry["env"])
+	assert.Equal(t, map[string]any{"table": "users", "rows": 2.0}, entry["db"])
+
+	db.Named("pool").WithGroup("conn").With(Int("id", 1)).Info("acquired")
+
+	entry = decodeEntry(t, &buf)
+	assert.Equal(t, "app.pool", entry["logger"])
+	assert.Equal(
+		t,
+		map[string]any{
+			"table": "users",
+			"conn":  map[string]any{"id": 1.0},
+		},
+		entry["db"],
+	)
+
+	// The parent logger is not affected.
+	l.Info("hello", Int("rows", 2))
+
+	entry = decodeEntry(t, &buf)
+	assert.NotContains(t, entry, "db")
+	assert.Equal(t, 2.0, entry["rows"])
+
+	assert.Same(t, l, l.WithGroup(""))
+}
+
+func TestWithGroupTraceIDs(t *testing.T) {
+	var buf bytes.Buffer
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	NewLogger(WithOutput(&buf)).WithGroup("db").InfoCtx(ctx, "query", Int("rows", 2))
+
+	entry := decodeEntry(t, &buf)
+	assert.Equal(t, span.SpanContext().TraceID().String(), entry["trace_id"])
+	assert.Equal(t, span.SpanContext().SpanID().String(), entry["span_id"])
+	assert.Equal(t, map[string]any{"rows": 2.0}, entry["db"])
+}
+
+func TestWithLevelOutputs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	l := NewLogger(
+		WithLevel(LevelDebug),
+		WithLevelOutputs(
+			map[Level]io.Writer{
+				LevelInfo: &stdout,
+				LevelWarn: &stderr,
+			},
+		),
+		WithAttributes(String("env", "prod")),
+	)
+
+	// Entries below the lowest level are discarded.
+	l.Debug("debug")
+	assert.Zero(t, stdout.Len())
+	assert.Zero(t, stderr.Len())
+
+	l.Info("info")
+	assert.Zero(t, stderr.Len())
+	entry := decodeEntry(t, &stdout)
+	assert.Equal(t, "info", entry["msg"])
+	assert.Equal(t, "prod", entry["env"])
+
+	l.Error("error")
+	assert.Zero(t, stdout.Len())
+	entry = decodeEntry(t, &stderr)
+	assert.Equal(t, "error", entry["msg"])
+
+	// Derived loggers inherit the outputs, and the trace IDs are
+	// added whatever the output.
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	l.Named("child").WithGroup("db").WarnCtx(ctx, "warn", Int("rows", 2))
+	assert.Zero(t, stdout.Len())
+	entry = decodeEntry(t, &stderr)
+	assert.Equal(t, "child", entry["logger"])
+	assert.Equal(t, span.SpanContext().TraceID().String(), entry["trace_id"])
+	assert.Equal(t, map[string]any{"rows": 2.0}, entry["db"])
+}
+
+func TestDefaultAttributesOnce(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(
+		WithOutput(&buf),
+		WithAttributes(String("version", "1.0.0")),
+	).With(String("component", "db"))
+
+	l.Info("top level")
+	assert.Equal(t, 1, strings.Count(buf.String(), `"version"`), buf.String())
+	assert.Equal(t, 1, strings.Count(buf.String(), `"component"`), buf.String())
+	buf.Reset()
+
+	l.WithGroup("query").With(String("table", "users")).Info("grouped", Int("rows", 2))
+	assert.Equal(t, 1, strings.Count(buf.String(), `"version"`), buf.String())
+	assert.Equal(t, 1, strings.Count(buf.String(), `"component"`), buf.String())
+	assert.Equal(t, 1, strings.Count(buf.String(), `"table"`), buf.String())
+	buf.Reset()
+
+	// Derived loggers rebuild their handler from the inherited
+	// attributes.
+	l.Named("child").Named("grandchild").Info("named")
+	assert.Equal(t, 1, strings.Count(buf.String(), `"version"`), buf.String())
+	assert.Equal(t, 1, strings.Count(buf.String(), `"component"`), buf.String())
+	buf.Reset()
+
+	var errBuf bytes.Buffer
+	l = NewLogger(
+		WithLevelOutputs(map[Level]io.Writer{LevelInfo: &buf, LevelError: &errBuf}),
+		WithAttributes(String("version", "1.0.0")),
+	).Named("child")
+
+	l.Error("failed")
+	assert.Empty(t, buf.String())
+	assert.Equal(t, 1, strings.Count(errBuf.String(), `"version"`), errBuf.String())
+}
+
+func TestNopLogger(t *testing.T) {
+	logger := NewNopLogger()
+
+	derived := logger.With(String("key", "value")).WithGroup("group").Named("name")
+	assert.Same(t, logger, derived)
+
+	assert.NotPanics(t, func() {
+		derived.Info("message", Int("n", 1))
+	})
+}
+
+func BenchmarkLogger(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("discard", func(b *testing.B) {
+		logger := NewLogger(WithOutput(io.Discard)).With(String("component", "bench"))
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.InfoCtx(ctx, "message", Int("n", i), String("key", "value"))
+		}
+	})
+
+	b.Run("nop", func(b *testing.B) {
+		logger := NewNopLogger().With(String("component", "bench"))
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.InfoCtx(ctx, "message", Int("n", i), String("key", "value"))
+		}
+	})
+
+	b.Run("disabled level", func(b *testing.B) {
+		logger := NewLogger(WithOutput(io.Discard)).WithGroup("g").With(String("component", "bench"))
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.DebugCtx(ctx, "message", Int("n", i), String("key", "value"))
+		}
+	})
+
+	b.Run("group", func(b *testing.B) {
+		logger := NewLogger(WithOutput(io.Discard)).WithGroup("g").With(String("component", "bench"))
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.InfoCtx(ctx, "message", Int("n", i), String("key", "value"))
+		}
+	})
+}
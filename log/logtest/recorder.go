@@ -0,0 +1,242 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package logtest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.gearno.de/kit/log"
+)
+
+type (
+	// Entry is a log entry captured by a Recorder.
+	Entry struct {
+		Time    time.Time
+		Level   log.Level
+		Message string
+
+		// Attributes holds the resolved values of the attributes of
+		// the entry, including the ones added with With and the
+		// logger name. The attributes nested in groups are keyed by
+		// their dot separated path, e.g. "db.rows".
+		Attributes map[string]any
+	}
+
+	// Recorder is a slog.Handler capturing the log entries in memory.
+	// It is safe for concurrent use; the handlers derived from it
+	// share its entries.
+	Recorder struct {
+		store  *store
+		attrs  []attr
+		prefix string
+	}
+
+	store struct {
+		mu      sync.Mutex
+		entries []Entry
+	}
+
+	attr struct {
+		key   string
+		value any
+	}
+)
+
+var (
+	_ slog.Handler = (*Recorder)(nil)
+)
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{store: &store{}}
+}
+
+// NewRecordingLogger returns a Logger writing its entries to a new
+// Recorder, along with the Recorder. The options are applied to the
+// Logger; the level defaults to log.LevelInfo as for log.NewLogger.
+func NewRecordingLogger(options ...log.Option) (*log.Logger, *Recorder) {
+	r := NewRecorder()
+
+	options = append(slices.Clip(options), log.WithHandler(r))
+
+	return log.NewLogger(options...), r
+}
+
+// Enabled reports true for every level, the level filtering being
+// done by the Logger.
+func (r *Recorder) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle captures the record.
+func (r *Recorder) Handle(_ context.Context, record slog.Record) error {
+	entry := Entry{
+		Time:       record.Time,
+		Level:      record.Level,
+		Message:    record.Message,
+		Attributes: make(map[string]any, len(r.attrs)+record.NumAttrs()),
+	}
+
+	for _, a := range r.attrs {
+		entry.Attributes[a.key] = a.value
+	}
+
+	record.Attrs(
+		func(a slog.Attr) bool {
+			for _, a := range flatten(r.prefix, a) {
+				entry.Attributes[a.key] = a.value
+			}
+
+			return true
+		},
+	)
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.entries = append(r.store.entries, entry)
+
+	return nil
+}
+
+// WithAttrs returns a Recorder sharing the entries of r and adding
+// attrs to the entries it captures.
+func (r *Recorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	r2 := *r
+	r2.attrs = slices.Clip(r2.attrs)
+
+	for _, a := range attrs {
+		r2.attrs = append(r2.attrs, flatten(r.prefix, a)...)
+	}
+
+	return &r2
+}
+
+// WithGroup returns a Recorder sharing the entries of r and nesting
+// the attributes added afterwards under name.
+func (r *Recorder) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return r
+	}
+
+	r2 := *r
+	r2.prefix = r.prefix + name + "."
+
+	return &r2
+}
+
+// Entries returns a copy of the captured entries, in order.
+func (r *Recorder) Entries() []Entry {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return slices.Clone(r.store.entries)
+}
+
+// Reset discards the captured entries.
+func (r *Recorder) Reset() {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.entries = nil
+}
+
+// Find returns the first captured entry with the given level and
+// message.
+func (r *Recorder) Find(level log.Level, msg string) (Entry, bool) {
+	for _, e := range r.Entries() {
+		if e.Level == level && e.Message == msg {
+			return e, true
+		}
+	}
+
+	return Entry{}, false
+}
+
+// AssertContains reports an error on t unless an entry with the given
+// level and message was captured, and returns whether there is one.
+func (r *Recorder) AssertContains(t testing.TB, level log.Level, msg string) bool {
+	t.Helper()
+
+	if _, ok := r.Find(level, msg); ok {
+		return true
+	}
+
+	t.Errorf("no %s log entry with message %q, got:\n%s", level, msg, r)
+
+	return false
+}
+
+// AssertNotContains reports an error on t if an entry with the given
+// level and message was captured, and returns whether there is none.
+func (r *Recorder) AssertNotContains(t testing.TB, level log.Level, msg string) bool {
+	t.Helper()
+
+	if _, ok := r.Find(level, msg); !ok {
+		return true
+	}
+
+	t.Errorf("unexpected %s log entry with message %q", level, msg)
+
+	return false
+}
+
+// String returns the captured entries, one per line.
+func (r *Recorder) String() string {
+	var b strings.Builder
+	for _, e := range r.Entries() {
+		fmt.Fprintf(&b, "%s %q %v\n", e.Level, e.Message, e.Attributes)
+	}
+
+	return b.String()
+}
+
+// Attr returns the value of the attribute with the given key, groups
+// being separated by dots.
+func (e Entry) Attr(key string) (any, bool) {
+	v, ok := e.Attributes[key]
+	return v, ok
+}
+
+func flatten(prefix string, a slog.Attr) []attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return nil
+	}
+
+	if a.Value.Kind() != slog.KindGroup {
+		return []attr{{key: prefix + a.Key, value: a.Value.Any()}}
+	}
+
+	if a.Key != "" {
+		prefix += a.Key + "."
+	}
+
+	var attrs []attr
+	for _, a := range a.Value.Group() {
+		attrs = append(attrs, flatten(prefix, a)...)
+	}
+
+	return attrs
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package logtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordingLogger(t *testing.T) {
+	logger, recorder := NewRecordingLogger(
+		log.WithName("svc"),
+		log.WithAttributes(log.String("version", "1.0.0")),
+	)
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	logger.Debug("ignored")
+	logger.Named("db").With(log.String("table", "users")).WithGroup("query").
+		InfoCtx(ctx, "selected", log.Int("rows", 2))
+	logger.Error("failed", log.Error(errors.New("boom")))
+
+	entries := recorder.Entries()
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, log.LevelInfo, entries[0].Level)
+	assert.Equal(t, "selected", entries[0].Message)
+	assert.Equal(
+		t,
+		map[string]any{
+			"logger":     "svc.db",
+			"version":    "1.0.0",
+			"query.rows": int64(2),
+			"table":      "users",
+			"trace_id":   span.SpanContext().TraceID().String(),
+			"span_id":    span.SpanContext().SpanID().String(),
+		},
+		entries[0].Attributes,
+	)
+
+	errorEntry, ok := recorder.Find(log.LevelError, "failed")
+	require.True(t, ok)
+	v, ok := errorEntry.Attr("error")
+	assert.True(t, ok)
+	assert.Equal(t, "boom", v)
+
+	assert.True(t, recorder.AssertContains(t, log.LevelInfo, "selected"))
+	assert.True(t, recorder.AssertNotContains(t, log.LevelDebug, "ignored"))
+
+	ft := &fakeT{}
+	assert.False(t, recorder.AssertContains(ft, log.LevelWarn, "selected"))
+	assert.False(t, recorder.AssertNotContains(ft, log.LevelError, "failed"))
+	require.Len(t, ft.errors, 2)
+	assert.Contains(t, ft.errors[0], `no WARN log entry with message "selected"`)
+	assert.Contains(t, ft.errors[0], `INFO "selected"`)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Entries())
+}
+
+func TestRecordingLoggerLevel(t *testing.T) {
+	logger, recorder := NewRecordingLogger(log.WithLevel(log.LevelDebug))
+
+	logger.Debug("debug")
+
+	recorder.AssertContains(t, log.LevelDebug, "debug")
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"go.gearno.de/kit/internal/version"
+	otellog "go.opentelemetry.io/otel/log"
+)
+
+type (
+	// otlpHandler is a slog.Handler emitting the records as
+	// OpenTelemetry log records.
+	otlpHandler struct {
+		logger otellog.Logger
+		level  slog.Leveler
+		attrs  []otellog.KeyValue
+		groups []otlpGroup
+	}
+
+	otlpGroup struct {
+		name  string
+		attrs []otellog.KeyValue
+	}
+
+	// teeHandler is a slog.Handler passing the records to each of its
+	// handlers enabled for their level.
+	teeHandler []slog.Handler
+)
+
+const (
+	instrumentationName = "go.gearno.de/kit/log"
+)
+
+var (
+	_ slog.Handler = (*otlpHandler)(nil)
+	_ slog.Handler = teeHandler(nil)
+)
+
+// WithOTLPExport emits each log entry as an OpenTelemetry log record
+// through the given logger provider, in addition to the JSON output.
+// The context of the entry is passed along with the record, so the
+// provider attaches the span context to it; the trace_id and span_id
+// attributes are therefore not exported as attributes. The level of the
+// Logger applies to both outputs.
+func WithOTLPExport(lp otellog.LoggerProvider) Option {
+	return func(l *Logger) {
+		l.loggerProvider = lp
+	}
+}
+
+func newOTLPHandler(lp otellog.LoggerProvider, level slog.Leveler) *otlpHandler {
+	return &otlpHandler{
+		logger: lp.Logger(
+			instrumentationName,
+			otellog.WithInstrumentationVersion(version.New(0).Alpha(1)),
+		),
+		level: level,
+	}
+}
+
+func (h *otlpHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *otlpHandler) Handle(ctx context.Context, r slog.Record) error {
+	var record otellog.Record
+	record.SetTimestamp(r.Time)
+	record.SetBody(otellog.StringValue(r.Message))
+	record.SetSeverity(otlpSeverity(r.Level))
+	record.SetSeverityText(r.Level.String())
+
+	var attrs []otellog.KeyValue
+	r.Attrs(
+		func(a slog.Attr) bool {
+			// The span context is passed along with ctx.
+			if len(h.groups) == 0 && (a.Key == "trace_id" || a.Key == "span_id") {
+				return true
+			}
+
+			if kv, ok := otlpKeyValue(a); ok {
+				attrs = append(attrs, kv)
+			}
+
+			return true
+		},
+	)
+
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		g := h.groups[i]
+		attrs = []otellog.KeyValue{
+			otellog.Map(g.name, append(slices.Clip(g.attrs), attrs...)...),
+		}
+	}
+
+	record.AddAttributes(h.attrs...)
+	record.AddAttributes(attrs...)
+
+	h.logger.Emit(ctx, record)
+
+	return nil
+}
+
+func (h *otlpHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+
+	var kvs []otellog.KeyValue
+	for _, a := range attrs {
+		if kv, ok := otlpKeyValue(a); ok {
+			kvs = append(kvs, kv)
+		}
+	}
+
+	if len(h2.groups) == 0 {
+		h2.attrs = append(slices.Clip(h2.attrs), kvs...)
+	} else {
+		h2.groups = slices.Clone(h2.groups)
+		g := &h2.groups[len(h2.groups)-1]
+		g.attrs = append(slices.Clip(g.attrs), kvs...)
+	}
+
+	return &h2
+}
+
+func (h *otlpHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.groups = append(slices.Clone(h2.groups), otlpGroup{name: name})
+
+	return &h2
+}
+
+// otlpSeverity maps the slog levels to the OpenTelemetry severities,
+// LevelDebug being SeverityDebug, LevelInfo SeverityInfo, and so on.
+func otlpSeverity(level slog.Level) otellog.Severity {
+	return otellog.Severity(level + 9)
+}
+
+func otlpKeyValue(a slog.Attr) (otellog.KeyValue, bool) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return otellog.KeyValue{}, false
+	}
+
+	if a.Value.Kind() == slog.KindGroup && a.Key == "" {
+		// Inlined groups are not supported, they are rare enough to
+		// be exported under an empty key.
+		a.Key = "_"
+	}
+
+	return otellog.KeyValue{Key: a.Key, Value: otlpValue(a.Value)}, true
+}
+
+func otlpValue(v slog.Value) otellog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return otellog.StringValue(v.String())
+	case slog.KindInt64:
+		return otellog.Int64Value(v.Int64())
+	case slog.KindUint64:
+		if u := v.Uint64(); u <= math.MaxInt64 {
+			return otellog.Int64Value(int64(u))
+		}
+
+		return otellog.StringValue(v.String())
+	case slog.KindFloat64:
+		return otellog.Float64Value(v.Float64())
+	case slog.KindBool:
+		return otellog.BoolValue(v.Bool())
+	case slog.KindDuration:
+		return otellog.Int64Value(v.Duration().Nanoseconds())
+	case slog.KindTime:
+		return otellog.Int64Value(v.Time().UnixNano())
+	case slog.KindGroup:
+		var kvs []otellog.KeyValue
+		for _, a := range v.Group() {
+			if kv, ok := otlpKeyValue(a); ok {
+				kvs = append(kvs, kv)
+			}
+		}
+
+		return otellog.MapValue(kvs...)
+	default:
+		switch x := v.Any().(type) {
+		case error:
+			return otellog.StringValue(x.Error())
+		case []byte:
+			return otellog.BytesValue(x)
+		case []string:
+			values := make([]otellog.Value, len(x))
+			for i, s := range x {
+				values[i] = otellog.StringValue(s)
+			}
+
+			return otellog.SliceValue(values...)
+		default:
+			return otellog.StringValue(fmt.Sprintf("%+v", x))
+		}
+	}
+}
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if !handler.Enabled(ctx, r.Level) {
+			continue
+		}
+
+		if err := handler.Handle(ctx, r.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := make(teeHandler, len(h))
+	for i, handler := range h {
+		h2[i] = handler.WithAttrs(attrs)
+	}
+
+	return h2
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	h2 := make(teeHandler, len(h))
+	for i, handler := range h {
+		h2[i] = handler.WithGroup(name)
+	}
+
+	return h2
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/logtest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func recordAttributes(r otellog.Record) map[string]otellog.Value {
+	attrs := map[string]otellog.Value{}
+	r.WalkAttributes(
+		func(kv otellog.KeyValue) bool {
+			attrs[kv.Key] = kv.Value
+			return true
+		},
+	)
+
+	return attrs
+}
+
+func TestWithOTLPExport(t *testing.T) {
+	var (
+		buf      bytes.Buffer
+		recorder = logtest.NewRecorder()
+	)
+
+	logger := NewLogger(
+		WithOutput(&buf),
+		WithName("svc"),
+		WithLevel(LevelInfo),
+		WithOTLPExport(recorder),
+	).Named("db").With(String("component", "pool")).WithGroup("query")
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	logger.DebugCtx(ctx, "ignored")
+	logger.WarnCtx(ctx, "slow query", Int("rows", 2))
+
+	// The JSON output is kept.
+	entry := decodeEntry(t, &buf)
+	assert.Equal(t, "slow query", entry["msg"])
+	assert.Equal(t, "svc.db", entry["logger"])
+
+	var records []logtest.EmittedRecord
+	for _, scope := range recorder.Result() {
+		assert.Equal(t, instrumentationName, scope.Name)
+		records = append(records, scope.Records...)
+	}
+	require.Len(t, records, 1)
+
+	record := records[0]
+	assert.Equal(t, "slow query", record.Body().AsString())
+	assert.Equal(t, otellog.SeverityWarn, record.Severity())
+	assert.Equal(t, "WARN", record.SeverityText())
+	assert.Equal(
+		t,
+		span.SpanContext(),
+		trace.SpanContextFromContext(record.Context()),
+	)
+
+	attrs := recordAttributes(record.Record)
+	assert.Equal(t, "svc.db", attrs["logger"].AsString())
+	assert.Equal(t, "pool", attrs["component"].AsString())
+	assert.Equal(
+		t,
+		[]otellog.KeyValue{otellog.Int64("rows", 2)},
+		attrs["query"].AsMap(),
+	)
+	assert.NotContains(t, attrs, "trace_id")
+	assert.NotContains(t, attrs, "span_id")
+}
+
+func TestOTLPValue(t *testing.T) {
+	assert.Equal(t, otellog.Int64Value(1500), otlpValue(Duration("d", 1500).Value))
+	assert.Equal(t, otellog.StringValue("boom"), otlpValue(Any("err", errors.New("boom")).Value))
+	assert.Equal(
+		t,
+		otellog.SliceValue(otellog.StringValue("a"), otellog.StringValue("b")),
+		otlpValue(Any("s", []string{"a", "b"}).Value),
+	)
+	assert.Equal(
+		t,
+		otellog.StringValue("18446744073709551615"),
+		otlpValue(Uint64("u", 1<<64-1).Value),
+	)
+}
@@ -0,0 +1,431 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package log
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RotatingWriter is an io.Writer appending to a file which is
+	// rotated once it grows over a maximum size or gets older than a
+	// maximum age. Rotated files are renamed with their rotation
+	// time, optionally compressed with gzip, and pruned past a
+	// maximum number of backups. It is safe for concurrent use and
+	// meant to be passed to WithOutput.
+	//
+	// Compression and pruning run in the background, so they never
+	// delay nor fail a write; their errors are passed to the
+	// rotation error handler.
+	RotatingWriter struct {
+		mu       sync.Mutex
+		filename string
+		file     *os.File
+		size     int64
+		openedAt time.Time
+
+		maxSize    int64
+		maxAge     time.Duration
+		maxBackups int
+		compress   bool
+		onError    func(error)
+
+		// cleanupMu serializes the background compression and
+		// pruning, cleanups tracks them so Close can wait for them.
+		cleanupMu sync.Mutex
+		cleanups  sync.WaitGroup
+
+		now func() time.Time
+	}
+
+	// RotatingWriterOption configures RotatingWriter during
+	// initialization.
+	RotatingWriterOption func(w *RotatingWriter)
+)
+
+const (
+	// DefaultRotatingMaxSize is the size in bytes over which the
+	// file is rotated unless WithMaxSize is used.
+	DefaultRotatingMaxSize = 100 * 1024 * 1024
+
+	backupTimeFormat = "2006-01-02T15-04-05.000000000"
+)
+
+var (
+	_ io.WriteCloser = (*RotatingWriter)(nil)
+)
+
+// WithMaxSize sets the size in bytes over which the file is rotated.
+// A single write larger than the maximum size is never split, it is
+// written whole to a fresh file.
+func WithMaxSize(size int64) RotatingWriterOption {
+	return func(w *RotatingWriter) {
+		w.maxSize = size
+	}
+}
+
+// WithMaxAge rotates the file once it has been open for longer than d.
+// By default, files are only rotated by size.
+func WithMaxAge(d time.Duration) RotatingWriterOption {
+	return func(w *RotatingWriter) {
+		w.maxAge = d
+	}
+}
+
+// WithMaxBackups sets the number of rotated files to keep, the oldest
+// ones being removed first. By default, all rotated files are kept.
+func WithMaxBackups(n int) RotatingWriterOption {
+	return func(w *RotatingWriter) {
+		w.maxBackups = n
+	}
+}
+
+// WithCompression compresses rotated files with gzip.
+func WithCompression() RotatingWriterOption {
+	return func(w *RotatingWriter) {
+		w.compress = true
+	}
+}
+
+// WithRotationErrorHandler sets the function called with the errors
+// which cannot be returned by Write, such as a failed rotation or
+// background compression. It may be called concurrently. By default,
+// the errors are printed to the standard error.
+func WithRotationErrorHandler(f func(err error)) RotatingWriterOption {
+	return func(w *RotatingWriter) {
+		w.onError = f
+	}
+}
+
+// NewRotatingWriter opens filename for appending, creating it and its
+// parent directories when needed, and returns a RotatingWriter
+// writing to it.
+func NewRotatingWriter(filename string, options ...RotatingWriterOption) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		filename: filename,
+		maxSize:  DefaultRotatingMaxSize,
+		onError: func(err error) {
+			fmt.Fprintf(os.Stderr, "log: %v\n", err)
+		},
+		now: time.Now,
+	}
+
+	for _, o := range options {
+		o(w)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create log directory: %w", err)
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write appends b to the file, rotating it first when b would make
+// it exceed the maximum size or when it is older than the maximum
+// age. When the rotation fails, b is still appended to the current
+// file if it could be kept open, and the rotation error is passed to
+// the rotation error handler.
+func (w *RotatingWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, fmt.Errorf("cannot write to %q: writer is closed", w.filename)
+	}
+
+	if w.shouldRotate(int64(len(b))) {
+		if err := w.rotate(); err != nil {
+			if w.file == nil {
+				return 0, err
+			}
+
+			w.onError(err)
+		}
+	}
+
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("cannot write to %q: %w", w.filename, err)
+	}
+
+	return n, nil
+}
+
+// Rotate closes the current file, renames it as a backup and opens a
+// new one, regardless of its size or age. It is meant to be called on
+// signals such as SIGHUP.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("cannot rotate %q: writer is closed", w.filename)
+	}
+
+	return w.rotate()
+}
+
+// Close closes the current file and waits for the background
+// compression and pruning to finish. Writes after Close fail.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	defer w.cleanups.Wait()
+
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("cannot close %q: %w", w.filename, err)
+	}
+
+	return nil
+}
+
+func (w *RotatingWriter) shouldRotate(n int64) bool {
+	if w.size > 0 && w.maxSize > 0 && w.size+n > w.maxSize {
+		return true
+	}
+
+	if w.maxAge > 0 && w.now().Sub(w.openedAt) >= w.maxAge {
+		return true
+	}
+
+	return false
+}
+
+func (w *RotatingWriter) open() error {
+	f, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open %q: %w", w.filename, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot stat %q: %w", w.filename, err)
+	}
+
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = w.now()
+
+	return nil
+}
+
+// rotate renames the current file as a backup and opens a new one.
+// When the file cannot be renamed, the current file is reopened so the
+// following writes still succeed. The backup is compressed and the
+// old backups pruned in the background.
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("cannot close %q: %w", w.filename, err)
+	}
+	w.file = nil
+
+	backup := w.backupName(w.now())
+	if err := os.Rename(w.filename, backup); err != nil {
+		err = fmt.Errorf("cannot rename %q: %w", w.filename, err)
+		if err2 := w.open(); err2 != nil {
+			return errors.Join(err, err2)
+		}
+
+		return err
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if w.compress || w.maxBackups > 0 {
+		w.cleanups.Add(1)
+		go func() {
+			defer w.cleanups.Done()
+			w.cleanup(backup)
+		}()
+	}
+
+	return nil
+}
+
+// cleanup compresses backup when compression is enabled and prunes
+// the old backups, passing the errors to the rotation error handler.
+func (w *RotatingWriter) cleanup(backup string) {
+	w.cleanupMu.Lock()
+	defer w.cleanupMu.Unlock()
+
+	if w.compress {
+		// The backup may already be pruned by a later rotation.
+		err := compressFile(backup)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			w.onError(err)
+		}
+	}
+
+	if err := w.prune(); err != nil {
+		w.onError(err)
+	}
+}
+
+// backupName returns the name of the file rotated at t, such as
+// "app-2024-01-01T00-00-00.000000000.log" for "app.log".
+func (w *RotatingWriter) backupName(t time.Time) string {
+	var (
+		dir    = filepath.Dir(w.filename)
+		ext    = filepath.Ext(w.filename)
+		prefix = strings.TrimSuffix(filepath.Base(w.filename), ext)
+	)
+
+	return filepath.Join(
+		dir,
+		fmt.Sprintf("%s-%s%s", prefix, t.UTC().Format(backupTimeFormat), ext),
+	)
+}
+
+// backups returns the rotated files of the writer, oldest first.
+func (w *RotatingWriter) backups() ([]string, error) {
+	var (
+		dir    = filepath.Dir(w.filename)
+		ext    = filepath.Ext(w.filename)
+		prefix = strings.TrimSuffix(filepath.Base(w.filename), ext) + "-"
+	)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read log directory: %w", err)
+	}
+
+	type backup struct {
+		name string
+		t    time.Time
+	}
+
+	var backups []backup
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		ts := strings.TrimPrefix(name, prefix)
+		ts = strings.TrimSuffix(ts, ".gz")
+		if !strings.HasSuffix(ts, ext) {
+			continue
+		}
+		ts = strings.TrimSuffix(ts, ext)
+
+		t, err := time.Parse(backupTimeFormat, ts)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, backup{filepath.Join(dir, name), t})
+	}
+
+	sort.Slice(
+		backups,
+		func(i, j int) bool {
+			return backups[i].t.Before(backups[j].t)
+		},
+	)
+
+	names := make([]string, len(backups))
+	for i, b := range backups {
+		names[i] = b.name
+	}
+
+	return names, nil
+}
+
+func (w *RotatingWriter) prune() error {
+	if w.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > w.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("cannot remove %q: %w", backups[0], err)
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("cannot open %q: %w", name, err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot create %q: %w", name+".gz", err)
+	}
+
+	gw := gzip.NewWriter(dst)
+	if _, err := io.Copy(gw, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("cannot compress %q: %w", name, err)
+	}
+
+	if err := gw.Close(); err != nil {
+		dst.Close()
+		return fmt.Errorf("cannot compress %q: %w", name, err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("cannot close %q: %w", name+".gz", err)
+	}
+
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("cannot remove %q: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRotatingWriter(t *testing.T, options ...RotatingWriterOption) (*RotatingWriter, *time.Time) {
+	t.Helper()
+
+	w, err := NewRotatingWriter(filepath.Join(t.TempDir(), "app.log"), options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { w.Close() })
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	w.openedAt = now
+
+	return w, &now
+}
+
+func TestRotatingWriter_MaxSize(t *testing.T) {
+	w, now := newTestRotatingWriter(t, WithMaxSize(10))
+
+	_, err := w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	backups, err := w.backups()
+	require.NoError(t, err)
+	assert.Empty(t, backups, "a write reaching the maximum size exactly does not rotate")
+
+	*now = now.Add(time.Second)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+
+	backups, err = w.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(
+		t,
+		filepath.Join(filepath.Dir(w.filename), "app-2024-01-01T00-00-01.000000000.log"),
+		backups[0],
+	)
+
+	b, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(b))
+
+	b, err = os.ReadFile(w.filename)
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(b))
+}
+
+func TestRotatingWriter_MaxAge(t *testing.T) {
+	w, now := newTestRotatingWriter(t, WithMaxAge(time.Hour))
+
+	_, err := w.Write([]byte("first"))
+	require.NoError(t, err)
+
+	*now = now.Add(time.Hour - time.Second)
+	_, err = w.Write([]byte("second"))
+	require.NoError(t, err)
+
+	backups, err := w.backups()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+
+	*now = now.Add(time.Second)
+	_, err = w.Write([]byte("third"))
+	require.NoError(t, err)
+
+	backups, err = w.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	b, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "firstsecond", string(b))
+}
+
+func TestRotatingWriter_MaxBackups(t *testing.T) {
+	w, now := newTestRotatingWriter(t, WithMaxBackups(2))
+
+	for i := 0; i < 4; i++ {
+		_, err := w.Write([]byte{byte('a' + i)})
+		require.NoError(t, err)
+
+		*now = now.Add(time.Second)
+		require.NoError(t, w.Rotate())
+	}
+	w.cleanups.Wait()
+
+	backups, err := w.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	for i, name := range backups {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, string(rune('c'+i)), string(b))
+	}
+}
+
+func TestRotatingWriter_Compression(t *testing.T) {
+	w, _ := newTestRotatingWriter(t, WithCompression())
+
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Rotate())
+	w.cleanups.Wait()
+
+	backups, err := w.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, ".gz", filepath.Ext(backups[0]))
+
+	f, err := os.Open(backups[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	b, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestRotatingWriter_Concurrent(t *testing.T) {
+	w, err := NewRotatingWriter(
+		filepath.Join(t.TempDir(), "app.log"),
+		WithMaxSize(1024),
+	)
+	require.NoError(t, err)
+	defer w.Close()
+
+	logger := NewLogger(WithOutput(w))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Info("hello", Int("j", j))
+			}
+		}()
+	}
+	wg.Wait()
+
+	backups, err := w.backups()
+	require.NoError(t, err)
+	assert.NotEmpty(t, backups)
+
+	for _, name := range append(backups, w.filename) {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(1024))
+	}
+}
+
+func TestRotatingWriter_Closed(t *testing.T) {
+	w, _ := newTestRotatingWriter(t)
+
+	require.NoError(t, w.Close())
+
+	_, err := w.Write([]byte("hello"))
+	assert.Error(t, err)
+}
+
+func TestRotatingWriter_RenameError(t *testing.T) {
+	var errs []error
+	w, now := newTestRotatingWriter(
+		t,
+		WithMaxSize(5),
+		WithRotationErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	_, err := w.Write([]byte("first"))
+	require.NoError(t, err)
+
+	// A directory in place of the backup makes the rename fail.
+	*now = now.Add(time.Second)
+	require.NoError(t, os.Mkdir(w.backupName(*now), 0755))
+
+	assert.Error(t, w.Rotate())
+
+	_, err = w.Write([]byte("second"))
+	require.NoError(t, err)
+	require.Len(t, errs, 1)
+
+	b, err := os.ReadFile(w.filename)
+	require.NoError(t, err)
+	assert.Equal(t, "firstsecond", string(b))
+}
+
+func TestRotatingWriter_CompressionError(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+
+	w, now := newTestRotatingWriter(
+		t,
+		WithMaxSize(5),
+		WithCompression(),
+		WithRotationErrorHandler(
+			func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			},
+		),
+	)
+
+	_, err := w.Write([]byte("first"))
+	require.NoError(t, err)
+
+	// A directory in place of the compressed backup makes the
+	// compression fail.
+	*now = now.Add(time.Second)
+	require.NoError(t, os.Mkdir(w.backupName(*now)+".gz", 0755))
+
+	_, err = w.Write([]byte("second"))
+	require.NoError(t, err)
+	w.cleanups.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, errs, 1)
+
+	b, err := os.ReadFile(w.backupName(*now))
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(b))
+
+	b, err = os.ReadFile(w.filename)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(b))
+}
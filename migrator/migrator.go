@@ -18,103 +18,448 @@ package migrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"regexp"
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"go.gearno.de/kit/pg"
 )
 
 type (
+	// Option configures the Migrator during initialization.
+	Option func(m *Migrator)
+
 	Migrator struct {
-		pg   *pg.Client
-		path string
+		pg           *pg.Client
+		path         string
+		goMigrations []*GoMigration
+		timeout      time.Duration
+		schema       string
+		table        string
+		progressHook func(MigrationEvent)
+	}
+
+	// MigrationEventType is the type of a MigrationEvent.
+	MigrationEventType int
+
+	// MigrationEvent describes the progress of a migration run, as
+	// reported to the WithProgressHook function.
+	MigrationEvent struct {
+		Type    MigrationEventType
+		Version string
+
+		// Index is the position of the migration among the
+		// pending migrations of the run, starting at 1, and
+		// Total is the number of pending migrations.
+		Index int
+		Total int
+
+		// Duration is the time spent applying the migration. It
+		// is zero for MigrationStarted events.
+		Duration time.Duration
+
+		// Err is the error which made the migration fail. It is
+		// only set for MigrationFailed events.
+		Err error
 	}
 
 	Migration struct {
 		Version string
 		SQL     string
+
+		// origin is the path the migration was loaded from,
+		// reported when its version collides with another one.
+		origin string
+	}
+
+	// MigrationSource is a directory of SQL migrations within a file
+	// system, such as an embed.FS, for RunAll.
+	MigrationSource struct {
+		FS      fs.FS
+		Dirname string
 	}
 
 	Migrations []*Migration
+
+	// GoMigration is a migration implemented in Go rather than in
+	// SQL. It is useful when a migration requires logic that is
+	// painful to express in SQL, such as backfilling a column by
+	// transforming existing rows in batches.
+	GoMigration struct {
+		Version string
+		Up      func(context.Context, pg.Conn) error
+	}
+
+	step struct {
+		version string
+		origin  string
+		exec    func(context.Context, pg.Conn) error
+	}
 )
 
 const (
 	MigrationAdvisoryLock pg.AdvisoryLock = 0
+
+	// DefaultVersionsTable is the name of the table recording the
+	// applied migration versions when WithVersionsTable is not used.
+	DefaultVersionsTable = "schema_versions"
+
+	rollbackTimeout = 5 * time.Second
+	unlockTimeout   = 5 * time.Second
+)
+
+const (
+	// MigrationStarted is reported before applying a migration.
+	MigrationStarted MigrationEventType = iota
+
+	// MigrationFinished is reported once a migration is applied.
+	MigrationFinished
+
+	// MigrationFailed is reported when a migration fails, its
+	// transaction being rolled back.
+	MigrationFailed
+)
+
+var (
+	identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
 )
 
-func NewMigrator(pg *pg.Client, dirname string) *Migrator {
-	return &Migrator{
-		pg:   pg,
-		path: dirname,
+// WithMigrationTimeout bounds the execution of each migration to the
+// given duration. The migration runs with a context carrying that
+// deadline, and the session lock_timeout and statement_timeout are set
+// for the migration transaction, so a migration stuck behind another
+// transaction fails fast instead of holding the advisory lock for the
+// whole fleet. The advisory lock is released when Run returns the
+// timeout error. The session timeouts are rounded up to the
+// millisecond, their resolution in PostgreSQL.
+func WithMigrationTimeout(d time.Duration) Option {
+	return func(m *Migrator) {
+		m.timeout = d
 	}
 }
 
+// WithVersionsTable sets the name of the table recording the applied
+// migration versions. It defaults to DefaultVersionsTable. Using
+// distinct tables lets independent migrators coexist in the same
+// database.
+func WithVersionsTable(name string) Option {
+	return func(m *Migrator) {
+		m.table = name
+	}
+}
+
+// WithSchema sets the schema holding the versions table. The schema is
+// created if it does not exist. By default the table is unqualified
+// and resolved through the connection search_path.
+func WithSchema(schema string) Option {
+	return func(m *Migrator) {
+		m.schema = schema
+	}
+}
+
+// WithProgressHook sets a function called as each pending migration
+// starts, finishes or fails, so callers can display progress or record
+// metrics. The function is called synchronously from Run while the
+// migration advisory lock is held, it should return quickly.
+func WithProgressHook(f func(MigrationEvent)) Option {
+	return func(m *Migrator) {
+		m.progressHook = f
+	}
+}
+
+func NewMigrator(pg *pg.Client, dirname string, options ...Option) *Migrator {
+	m := &Migrator{
+		pg:    pg,
+		path:  dirname,
+		table: DefaultVersionsTable,
+	}
+
+	for _, o := range options {
+		o(m)
+	}
+
+	return m
+}
+
+// Register adds Go migrations to the migrator. They are applied
+// alongside the SQL migrations loaded from the directory, in version
+// order.
+func (m *Migrator) Register(migrations ...*GoMigration) {
+	m.goMigrations = append(m.goMigrations, migrations...)
+}
+
+// Run applies the pending migrations. It pins a single connection for
+// the whole run: the migration advisory lock is a session level lock
+// taken on that connection, and the versions table and the migrations,
+// each in its own transaction, run on it as well. The lock is released
+// explicitly once done; if it cannot be, the connection is closed
+// rather than returned to the pool still holding it.
 func (m *Migrator) Run(ctx context.Context) error {
 	var migrations Migrations
 	if err := migrations.LoadFromDir(m.path); err != nil {
 		return fmt.Errorf("cannot load migrations: %w", err)
 	}
 
-	migrations.Sort()
+	return m.migrate(ctx, migrations)
+}
+
+// RunAll applies the pending migrations of all the sources, along with
+// the registered Go migrations, as Run does for a single directory: the
+// migrations are merged into a single sequence ordered by version,
+// applied under the same advisory lock and recorded in the same
+// versions table. It fails without applying anything when two
+// migrations share the same version. The directory given to
+// NewMigrator is not used.
+//
+// Example:
+//
+//	//go:embed migrations
+//	var coreMigrations embed.FS
+//
+//	err := migrator.NewMigrator(client, "").RunAll(
+//	    ctx,
+//	    migrator.MigrationSource{FS: coreMigrations, Dirname: "migrations"},
+//	    billing.MigrationSource(),
+//	)
+func (m *Migrator) RunAll(ctx context.Context, sources ...MigrationSource) error {
+	var migrations Migrations
+
+	for _, source := range sources {
+		var ms Migrations
+		if err := ms.LoadFromFS(source.FS, source.Dirname); err != nil {
+			return fmt.Errorf("cannot load migrations from %q: %w", source.Dirname, err)
+		}
+
+		migrations = append(migrations, ms...)
+	}
+
+	return m.migrate(ctx, migrations)
+}
+
+func (m *Migrator) migrate(ctx context.Context, migrations Migrations) error {
+	table, err := m.versionsTable()
+	if err != nil {
+		return fmt.Errorf("invalid versions table: %w", err)
+	}
+
+	steps, err := m.steps(migrations)
+	if err != nil {
+		return err
+	}
 
-	if len(migrations) == 0 {
+	if len(steps) == 0 {
 		return nil
 	}
 
-	err := m.pg.WithAdvisoryLock(
-		ctx,
-		MigrationAdvisoryLock,
-		func(conn pg.Conn) error {
-			err := m.pg.WithConn(
-				ctx,
-				func(conn pg.Conn) error {
-					return createIfNotExistVersionsTable(ctx, conn)
-				},
-			)
-			if err != nil {
-				return fmt.Errorf("cannot create schema version table: %w", err)
-			}
+	conn, err := m.pg.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot acquire connection: %w", err)
+	}
+	defer conn.Release()
 
-			appliedVersions, err := loadSchemaVersions(ctx, conn)
-			if err != nil {
-				return fmt.Errorf("cannot load schema versions: %w", err)
-			}
+	if err := lock(ctx, conn); err != nil {
+		return fmt.Errorf("cannot acquire migration lock: %w", err)
+	}
 
-			for _, migration := range migrations {
-				if _, found := appliedVersions[migration.Version]; found {
-					continue
-				}
+	err = m.run(ctx, conn, table, steps)
+
+	if err2 := unlock(ctx, conn); err2 != nil {
+		err = errors.Join(err, fmt.Errorf("cannot release migration lock: %w", err2))
+
+		if err3 := conn.Close(context.WithoutCancel(ctx)); err3 != nil {
+			err = errors.Join(err, fmt.Errorf("cannot close connection: %w", err3))
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := m.pg.RefreshTypes(ctx); err != nil {
+		return fmt.Errorf("cannot refresh types: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) run(ctx context.Context, conn *pg.PinnedConn, table string, steps []step) error {
+	if err := createIfNotExistVersionsTable(ctx, conn, m.schema, table); err != nil {
+		return fmt.Errorf("cannot create schema version table: %w", err)
+	}
+
+	appliedVersions, err := loadSchemaVersions(ctx, conn, table)
+	if err != nil {
+		return fmt.Errorf("cannot load schema versions: %w", err)
+	}
+
+	var pending []step
+	for _, migration := range steps {
+		if _, found := appliedVersions[migration.version]; !found {
+			pending = append(pending, migration)
+		}
+	}
+
+	for i, migration := range pending {
+		event := MigrationEvent{
+			Type:    MigrationStarted,
+			Version: migration.version,
+			Index:   i + 1,
+			Total:   len(pending),
+		}
+		m.report(event)
+
+		start := time.Now()
+		err := m.apply(ctx, conn, table, migration)
+		event.Duration = time.Since(start)
+
+		if err != nil {
+			event.Type = MigrationFailed
+			event.Err = err
+			m.report(event)
+
+			return fmt.Errorf("cannot apply migration %q: %w", migration.version, err)
+		}
+
+		event.Type = MigrationFinished
+		m.report(event)
+	}
+
+	return nil
+}
+
+func (m *Migrator) report(event MigrationEvent) {
+	if m.progressHook != nil {
+		m.progressHook(event)
+	}
+}
+
+func (m *Migrator) versionsTable() (string, error) {
+	if err := validateIdentifier(m.table); err != nil {
+		return "", err
+	}
+
+	if m.schema == "" {
+		return pgx.Identifier{m.table}.Sanitize(), nil
+	}
+
+	if err := validateIdentifier(m.schema); err != nil {
+		return "", err
+	}
+
+	return pgx.Identifier{m.schema, m.table}.Sanitize(), nil
+}
 
-				// c.logger.Info("applying migration", zap.String("version", migration.Version))
+func (m *Migrator) apply(ctx context.Context, conn *pg.PinnedConn, table string, s step) error {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
 
-				err := m.pg.WithTx(
-					ctx,
-					func(conn pg.Conn) error {
-						return migration.Apply(ctx, conn)
-					},
-				)
-				if err != nil {
-					return fmt.Errorf("cannot apply migration %v: %w", migration, err)
+	err := withTx(
+		ctx,
+		conn,
+		func(tx pg.Conn) error {
+			if m.timeout > 0 {
+				if err := setLocalTimeouts(ctx, tx, m.timeout); err != nil {
+					return fmt.Errorf("cannot set migration timeouts: %w", err)
 				}
 			}
 
-			return nil
+			return execStep(ctx, tx, table, s)
 		},
 	)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("migration timed out after %s: %w", m.timeout, err)
+	}
 
+	return err
+}
+
+// withTx runs exec within a transaction of conn, committing it when
+// exec succeeds and rolling it back otherwise, even when ctx is done.
+func withTx(ctx context.Context, conn *pg.PinnedConn, exec pg.ExecFunc) error {
+	tx, err := conn.Begin(ctx)
 	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	if err := exec(tx); err != nil {
+		rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+		defer cancel()
+
+		if err2 := tx.Rollback(rollbackCtx); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("cannot rollback transaction: %w", err2))
+		}
+
 		return err
 	}
 
-	if err := m.pg.RefreshTypes(ctx); err != nil {
-		return fmt.Errorf("cannot refresh types: %w", err)
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
 	}
 
 	return nil
 }
 
+func (m *Migrator) steps(migrations Migrations) ([]step, error) {
+	var (
+		steps    = make([]step, 0, len(migrations)+len(m.goMigrations))
+		versions = make(map[string]string)
+	)
+
+	for _, migration := range migrations {
+		steps = append(steps, step{migration.Version, migration.origin, migration.exec})
+	}
+
+	for _, migration := range m.goMigrations {
+		steps = append(steps, step{migration.Version, "go migration", migration.Up})
+	}
+
+	for _, s := range steps {
+		if origin, found := versions[s.version]; found {
+			return nil, fmt.Errorf(
+				"duplicate migration version %q: defined by %s and %s",
+				s.version,
+				origin,
+				s.origin,
+			)
+		}
+
+		versions[s.version] = s.origin
+	}
+
+	sort.Slice(
+		steps,
+		func(i, j int) bool {
+			return steps[i].version < steps[j].version
+		},
+	)
+
+	return steps, nil
+}
+
+// String returns the name of the event type.
+func (t MigrationEventType) String() string {
+	switch t {
+	case MigrationStarted:
+		return "started"
+	case MigrationFinished:
+		return "finished"
+	case MigrationFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("MigrationEventType(%d)", int(t))
+	}
+}
+
 func (ms Migrations) Sort() {
 	sort.Slice(
 		ms,
@@ -157,19 +502,93 @@ func (pms *Migrations) LoadFromDir(pathname string) error {
 	return nil
 }
 
-func (m *Migration) Apply(ctx context.Context, conn pg.Conn) error {
-	_, err := conn.Exec(ctx, m.SQL)
+// LoadFromFS loads the SQL migrations of the dirname directory of
+// fsys, as LoadFromDir does for a directory of the host file system.
+func (pms *Migrations) LoadFromFS(fsys fs.FS, dirname string) error {
+	var ms Migrations
+
+	if fsys == nil {
+		return fmt.Errorf("no file system")
+	}
+
+	if dirname == "" {
+		dirname = "."
+	}
+
+	entries, err := fs.ReadDir(fsys, dirname)
 	if err != nil {
+		return fmt.Errorf("cannot read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		filepath := path.Join(dirname, entry.Name())
+
+		code, err := fs.ReadFile(fsys, filepath)
+		if err != nil {
+			return fmt.Errorf("cannot load migration from %q: %w", filepath, err)
+		}
+
+		ms = append(
+			ms,
+			&Migration{
+				Version: strings.TrimSuffix(entry.Name(), ".sql"),
+				SQL:     string(code),
+				origin:  fmt.Sprintf("%q", filepath),
+			},
+		)
+	}
+
+	*pms = ms
+	return nil
+}
+
+// execStep executes s on conn and records its version in table.
+func execStep(ctx context.Context, conn pg.Conn, table string, s step) error {
+	if err := s.exec(ctx, conn); err != nil {
 		return fmt.Errorf("cannot execute migration: %w", err)
 	}
 
-	q := "INSERT INTO schema_versions (version) VALUES ($1)"
-	_, err = conn.Exec(ctx, q, m.Version)
+	return insertSchemaVersion(ctx, conn, table, s.version)
+}
+
+// applyStep executes s on conn outside of a migrator run, recording its
+// version in the versions table configured by options.
+func applyStep(ctx context.Context, conn pg.Conn, s step, options []Option) error {
+	m := &Migrator{table: DefaultVersionsTable}
+	for _, o := range options {
+		o(m)
+	}
+
+	table, err := m.versionsTable()
 	if err != nil {
-		return fmt.Errorf("cannot insert schema version: %w", err)
+		return err
 	}
 
-	return nil
+	return execStep(ctx, conn, table, s)
+}
+
+// Apply executes the migration on conn and records its version in the
+// versions table, DefaultVersionsTable unless set with the
+// WithVersionsTable and WithSchema options; the other options are
+// ignored. Unlike Run, it neither creates the versions table, takes the
+// migration advisory lock nor begins a transaction.
+func (m *Migration) Apply(ctx context.Context, conn pg.Conn, options ...Option) error {
+	return applyStep(ctx, conn, step{version: m.Version, exec: m.exec}, options)
+}
+
+func (m *Migration) exec(ctx context.Context, conn pg.Conn) error {
+	_, err := conn.Exec(ctx, m.SQL)
+	return err
+}
+
+// Apply runs the Go migration on the given connection and records its
+// version in the versions table, as Migration.Apply does.
+func (m *GoMigration) Apply(ctx context.Context, conn pg.Conn, options ...Option) error {
+	return applyStep(ctx, conn, step{version: m.Version, exec: m.Up}, options)
 }
 
 func (m *Migration) LoadFromFile(pathname string) error {
@@ -184,24 +603,100 @@ func (m *Migration) LoadFromFile(pathname string) error {
 
 	m.Version = version
 	m.SQL = string(code)
+	m.origin = fmt.Sprintf("%q", pathname)
 
 	return nil
 }
 
-func createIfNotExistVersionsTable(ctx context.Context, conn pg.Conn) error {
-	q := `
-CREATE TABLE IF NOT EXISTS schema_versions (
+func validateIdentifier(name string) error {
+	if !identifierRegexp.MatchString(name) {
+		return fmt.Errorf("invalid identifier %q", name)
+	}
+
+	return nil
+}
+
+func createIfNotExistVersionsTable(ctx context.Context, conn pg.Conn, schema, table string) error {
+	if schema != "" {
+		q := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pgx.Identifier{schema}.Sanitize())
+		if _, err := conn.Exec(ctx, q); err != nil {
+			return fmt.Errorf("cannot create schema: %w", err)
+		}
+	}
+
+	q := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
   version VARCHAR PRIMARY KEY,
   executed_at TIMESTAMP NOT NULL DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
 )
-`
+`, table)
 
 	_, err := conn.Exec(ctx, q)
 	return err
 }
 
-func loadSchemaVersions(ctx context.Context, conn pg.Conn) (map[string]struct{}, error) {
-	q := "SELECT version FROM schema_versions"
+// lock takes the migration advisory lock at the session level, waiting
+// for the migrators of the other instances to finish. It conflicts
+// with the transaction level lock taken by pg.Client.WithAdvisoryLock
+// on the same id.
+func lock(ctx context.Context, conn pg.Conn) error {
+	_, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1, $2)", pg.BaseAdvisoryLockId, MigrationAdvisoryLock)
+	return err
+}
+
+// unlock releases the migration advisory lock taken by lock. It runs
+// even when ctx is done, so the lock does not outlive the run.
+func unlock(ctx context.Context, conn pg.Conn) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), unlockTimeout)
+	defer cancel()
+
+	var unlocked bool
+	err := conn.QueryRow(
+		ctx,
+		"SELECT pg_advisory_unlock($1, $2)",
+		pg.BaseAdvisoryLockId,
+		MigrationAdvisoryLock,
+	).Scan(&unlocked)
+	if err != nil {
+		return err
+	}
+
+	if !unlocked {
+		return fmt.Errorf("lock not held")
+	}
+
+	return nil
+}
+
+func setLocalTimeouts(ctx context.Context, conn pg.Conn, d time.Duration) error {
+	q := `
+SELECT
+  set_config('lock_timeout', $1, true),
+  set_config('statement_timeout', $1, true)
+`
+
+	_, err := conn.Exec(ctx, q, timeoutSetting(d))
+	return err
+}
+
+// timeoutSetting returns the PostgreSQL setting of the timeout d, in
+// milliseconds rounded up: a zero timeout would disable it.
+func timeoutSetting(d time.Duration) string {
+	return fmt.Sprintf("%dms", (d+time.Millisecond-1)/time.Millisecond)
+}
+
+func insertSchemaVersion(ctx context.Context, conn pg.Conn, table, version string) error {
+	q := fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", table)
+	_, err := conn.Exec(ctx, q, version)
+	if err != nil {
+		return fmt.Errorf("cannot insert schema version: %w", err)
+	}
+
+	return nil
+}
+
+func loadSchemaVersions(ctx context.Context, conn pg.Conn, table string) (map[string]struct{}, error) {
+	q := fmt.Sprintf("SELECT version FROM %s", table)
 	r, err := conn.Query(ctx, q)
 	if err != nil {
 		return nil, fmt.Errorf("cannot exec query: %w", err)
@@ -0,0 +1,632 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package migrator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/pg"
+)
+
+type (
+	fakeQuery struct {
+		conn  int64
+		query string
+	}
+
+	fakeServer struct {
+		mu      sync.Mutex
+		queries []fakeQuery
+	}
+)
+
+// newFakeServer starts a server speaking enough of the PostgreSQL
+// simple query protocol for the migrator, recording each query along
+// with the connection it was received on.
+func newFakeServer(t *testing.T) (string, *fakeServer) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeServer{}
+
+	var connID atomic.Int64
+
+	serve := func(conn net.Conn, id int64) {
+		defer conn.Close()
+
+		backend := pgproto3.NewBackend(conn, conn)
+
+		for {
+			msg, err := backend.ReceiveStartupMessage()
+			if err != nil {
+				return
+			}
+
+			if _, ok := msg.(*pgproto3.SSLRequest); ok {
+				if _, err := conn.Write([]byte("N")); err != nil {
+					return
+				}
+				continue
+			}
+
+			break
+		}
+
+		txStatus := byte('I')
+
+		backend.Send(&pgproto3.AuthenticationOk{})
+		backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+		backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+		backend.Send(&pgproto3.BackendKeyData{ProcessID: uint32(id), SecretKey: 1})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+		if err := backend.Flush(); err != nil {
+			return
+		}
+
+		for {
+			msg, err := backend.Receive()
+			if err != nil {
+				return
+			}
+
+			switch msg := msg.(type) {
+			case *pgproto3.Query:
+				q := strings.TrimSpace(msg.String)
+
+				s.mu.Lock()
+				s.queries = append(s.queries, fakeQuery{conn: id, query: q})
+				s.mu.Unlock()
+
+				switch {
+				case strings.EqualFold(q, "begin"):
+					txStatus = 'T'
+				case strings.EqualFold(q, "commit"), strings.EqualFold(q, "rollback"):
+					txStatus = 'I'
+				case strings.HasPrefix(q, "SELECT pg_advisory_unlock"):
+					backend.Send(&pgproto3.RowDescription{
+						Fields: []pgproto3.FieldDescription{
+							{Name: []byte("pg_advisory_unlock"), DataTypeOID: pgtype.BoolOID, DataTypeSize: 1, TypeModifier: -1},
+						},
+					})
+					backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte("t")}})
+				case strings.HasPrefix(q, "SELECT version FROM"):
+					backend.Send(&pgproto3.RowDescription{
+						Fields: []pgproto3.FieldDescription{
+							{Name: []byte("version"), DataTypeOID: pgtype.TextOID, DataTypeSize: -1, TypeModifier: -1},
+						},
+					})
+					backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte("0001_applied")}})
+				}
+
+				backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+				if err := backend.Flush(); err != nil {
+					return
+				}
+			case *pgproto3.Terminate:
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn, connID.Add(1))
+		}
+	}()
+
+	return ln.Addr().String(), s
+}
+
+func (s *fakeServer) received() []fakeQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]fakeQuery(nil), s.queries...)
+}
+
+func (s *fakeServer) receivedQueries() []string {
+	var queries []string
+	for _, q := range s.received() {
+		queries = append(queries, q.query)
+	}
+
+	return queries
+}
+
+func newTestClient(t *testing.T, addr string) *pg.Client {
+	t.Helper()
+
+	client, err := pg.NewClient(
+		pg.WithAddr(addr),
+		pg.WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+		pg.WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+// assertQueryPrefixes asserts each query starts with the expected
+// prefix of the same index.
+func assertQueryPrefixes(t *testing.T, expected, queries []string) {
+	t.Helper()
+
+	require.Len(t, queries, len(expected), "queries: %q", queries)
+	for i := range expected {
+		assert.True(
+			t,
+			strings.HasPrefix(queries[i], expected[i]),
+			"query %d: expected prefix %q, got %q", i, expected[i], queries[i],
+		)
+	}
+}
+
+func TestMigratorRun_SingleConnection(t *testing.T) {
+	addr, server := newFakeServer(t)
+
+	client, err := pg.NewClient(
+		pg.WithAddr(addr),
+		pg.WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+		pg.WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0001_applied.sql"), []byte("CREATE TABLE a ()"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0002_users.sql"), []byte("CREATE TABLE users ()"), 0o600))
+
+	m := NewMigrator(client, dir)
+	m.Register(
+		&GoMigration{
+			Version: "0003_backfill",
+			Up: func(ctx context.Context, conn pg.Conn) error {
+				_, err := conn.Exec(ctx, "UPDATE users SET x = 1")
+				return err
+			},
+		},
+	)
+
+	require.NoError(t, m.Run(context.Background()))
+
+	received := server.received()
+	require.NotEmpty(t, received)
+
+	var queries []string
+	for _, q := range received {
+		assert.Equal(t, received[0].conn, q.conn, "query %q received on another connection", q.query)
+		queries = append(queries, q.query)
+	}
+
+	expected := []string{
+		"SELECT pg_advisory_lock(",
+		`CREATE TABLE IF NOT EXISTS "schema_versions"`,
+		`SELECT version FROM "schema_versions"`,
+		"begin",
+		"CREATE TABLE users ()",
+		`INSERT INTO "schema_versions" (version) VALUES ( '0002_users' )`,
+		"commit",
+		"begin",
+		"UPDATE users SET x = 1",
+		`INSERT INTO "schema_versions" (version) VALUES ( '0003_backfill' )`,
+		"commit",
+		"SELECT pg_advisory_unlock(",
+	}
+	assertQueryPrefixes(t, expected, queries)
+}
+
+func TestMigratorRunAll(t *testing.T) {
+	t.Run("merges sources", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+
+		client, err := pg.NewClient(
+			pg.WithAddr(addr),
+			pg.WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+			pg.WithRegisterer(prometheus.NewRegistry()),
+		)
+		require.NoError(t, err)
+		defer client.Close()
+
+		core := fstest.MapFS{
+			"migrations/0001_applied.sql": {Data: []byte("CREATE TABLE a ()")},
+			"migrations/0003_users.sql":   {Data: []byte("CREATE TABLE users ()")},
+			"migrations/README":           {Data: []byte("not a migration")},
+		}
+		billing := fstest.MapFS{
+			"0002_invoices.sql": {Data: []byte("CREATE TABLE invoices ()")},
+		}
+
+		err = NewMigrator(client, "").RunAll(
+			context.Background(),
+			MigrationSource{FS: core, Dirname: "migrations"},
+			MigrationSource{FS: billing},
+		)
+		require.NoError(t, err)
+
+		var queries []string
+		for _, q := range server.received() {
+			queries = append(queries, q.query)
+		}
+
+		expected := []string{
+			"SELECT pg_advisory_lock(",
+			`CREATE TABLE IF NOT EXISTS "schema_versions"`,
+			`SELECT version FROM "schema_versions"`,
+			"begin",
+			"CREATE TABLE invoices ()",
+			`INSERT INTO "schema_versions" (version) VALUES ( '0002_invoices' )`,
+			"commit",
+			"begin",
+			"CREATE TABLE users ()",
+			`INSERT INTO "schema_versions" (version) VALUES ( '0003_users' )`,
+			"commit",
+			"SELECT pg_advisory_unlock(",
+		}
+		assertQueryPrefixes(t, expected, queries)
+	})
+
+	t.Run("version collision", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+
+		client, err := pg.NewClient(
+			pg.WithAddr(addr),
+			pg.WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+			pg.WithRegisterer(prometheus.NewRegistry()),
+		)
+		require.NoError(t, err)
+		defer client.Close()
+
+		err = NewMigrator(client, "").RunAll(
+			context.Background(),
+			MigrationSource{
+				FS:      fstest.MapFS{"core/0002_users.sql": {Data: []byte("CREATE TABLE users ()")}},
+				Dirname: "core",
+			},
+			MigrationSource{
+				FS:      fstest.MapFS{"billing/0002_users.sql": {Data: []byte("CREATE TABLE accounts ()")}},
+				Dirname: "billing",
+			},
+		)
+		require.EqualError(
+			t,
+			err,
+			`duplicate migration version "0002_users": defined by "core/0002_users.sql" and "billing/0002_users.sql"`,
+		)
+		assert.Empty(t, server.received())
+	})
+}
+
+func TestMigratorRun_VersionsTable(t *testing.T) {
+	t.Run("table and schema", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		m := NewMigrator(client, "", WithSchema("migrations"), WithVersionsTable("app_versions"))
+		err := m.RunAll(
+			context.Background(),
+			MigrationSource{FS: fstest.MapFS{"0002_users.sql": {Data: []byte("CREATE TABLE users ()")}}},
+		)
+		require.NoError(t, err)
+
+		assertQueryPrefixes(
+			t,
+			[]string{
+				"SELECT pg_advisory_lock(",
+				`CREATE SCHEMA IF NOT EXISTS "migrations"`,
+				`CREATE TABLE IF NOT EXISTS "migrations"."app_versions"`,
+				`SELECT version FROM "migrations"."app_versions"`,
+				"begin",
+				"CREATE TABLE users ()",
+				`INSERT INTO "migrations"."app_versions" (version) VALUES ( '0002_users' )`,
+				"commit",
+				"SELECT pg_advisory_unlock(",
+			},
+			server.receivedQueries(),
+		)
+	})
+
+	t.Run("invalid identifiers", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		for _, options := range [][]Option{
+			{WithVersionsTable("app versions")},
+			{WithSchema("1migrations")},
+		} {
+			err := NewMigrator(client, t.TempDir(), options...).Run(context.Background())
+			assert.ErrorContains(t, err, "invalid identifier")
+		}
+
+		assert.Empty(t, server.received())
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		err := client.WithConn(
+			context.Background(),
+			func(conn pg.Conn) error {
+				migration := &Migration{Version: "0002_users", SQL: "CREATE TABLE users ()"}
+				if err := migration.Apply(context.Background(), conn); err != nil {
+					return err
+				}
+
+				goMigration := &GoMigration{
+					Version: "0003_backfill",
+					Up: func(ctx context.Context, conn pg.Conn) error {
+						_, err := conn.Exec(ctx, "UPDATE users SET x = 1")
+						return err
+					},
+				}
+
+				return goMigration.Apply(
+					context.Background(),
+					conn,
+					WithSchema("migrations"),
+					WithVersionsTable("app_versions"),
+				)
+			},
+		)
+		require.NoError(t, err)
+
+		assertQueryPrefixes(
+			t,
+			[]string{
+				"CREATE TABLE users ()",
+				`INSERT INTO "schema_versions" (version) VALUES ( '0002_users' )`,
+				"UPDATE users SET x = 1",
+				`INSERT INTO "migrations"."app_versions" (version) VALUES ( '0003_backfill' )`,
+			},
+			server.receivedQueries(),
+		)
+	})
+}
+
+func TestMigratorRun_Timeout(t *testing.T) {
+	t.Run("set locally", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		err := NewMigrator(client, "", WithMigrationTimeout(time.Minute)).RunAll(
+			context.Background(),
+			MigrationSource{FS: fstest.MapFS{"0002_users.sql": {Data: []byte("CREATE TABLE users ()")}}},
+		)
+		require.NoError(t, err)
+
+		queries := server.receivedQueries()
+		require.Greater(t, len(queries), 5, "queries: %q", queries)
+
+		// The timeouts are set within the migration transaction.
+		assert.Equal(t, "begin", queries[3])
+		assert.Contains(t, queries[4], "set_config('lock_timeout',  '60000ms' , true)")
+		assert.Contains(t, queries[4], "set_config('statement_timeout',  '60000ms' , true)")
+		assert.Equal(t, "CREATE TABLE users ()", queries[5])
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		m := NewMigrator(client, "", WithMigrationTimeout(50*time.Millisecond))
+		m.Register(
+			&GoMigration{
+				Version: "0002_stuck",
+				Up: func(ctx context.Context, conn pg.Conn) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+		)
+
+		err := m.RunAll(context.Background())
+		require.ErrorContains(t, err, "migration timed out after 50ms")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// The transaction is rolled back and the advisory lock
+		// released despite the deadline.
+		queries := server.receivedQueries()
+		require.GreaterOrEqual(t, len(queries), 2, "queries: %q", queries)
+		assert.Equal(t, "rollback", queries[len(queries)-2])
+		assert.True(t, strings.HasPrefix(queries[len(queries)-1], "SELECT pg_advisory_unlock("))
+	})
+}
+
+func TestTimeoutSetting(t *testing.T) {
+	assert.Equal(t, "1ms", timeoutSetting(time.Nanosecond))
+	assert.Equal(t, "1ms", timeoutSetting(time.Millisecond))
+	assert.Equal(t, "2ms", timeoutSetting(1500*time.Microsecond))
+	assert.Equal(t, "60000ms", timeoutSetting(time.Minute))
+}
+
+func TestMigratorRun_ProgressHook(t *testing.T) {
+	addr, _ := newFakeServer(t)
+	client := newTestClient(t, addr)
+
+	var (
+		errBroken = errors.New("broken")
+		events    []MigrationEvent
+	)
+
+	m := NewMigrator(
+		client,
+		"",
+		WithProgressHook(func(e MigrationEvent) { events = append(events, e) }),
+	)
+	m.Register(
+		&GoMigration{
+			Version: "0003_slow",
+			Up: func(ctx context.Context, conn pg.Conn) error {
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			},
+		},
+		&GoMigration{
+			Version: "0004_broken",
+			Up: func(ctx context.Context, conn pg.Conn) error {
+				return errBroken
+			},
+		},
+	)
+
+	err := m.RunAll(
+		context.Background(),
+		MigrationSource{
+			FS: fstest.MapFS{
+				"0001_applied.sql": {Data: []byte("CREATE TABLE a ()")},
+				"0002_users.sql":   {Data: []byte("CREATE TABLE users ()")},
+			},
+		},
+	)
+	require.ErrorIs(t, err, errBroken)
+
+	require.Len(t, events, 6)
+
+	// The already applied migration is not reported, the indexes
+	// are among the pending migrations.
+	for i, expected := range []struct {
+		typ     MigrationEventType
+		version string
+		index   int
+	}{
+		{MigrationStarted, "0002_users", 1},
+		{MigrationFinished, "0002_users", 1},
+		{MigrationStarted, "0003_slow", 2},
+		{MigrationFinished, "0003_slow", 2},
+		{MigrationStarted, "0004_broken", 3},
+		{MigrationFailed, "0004_broken", 3},
+	} {
+		assert.Equal(t, expected.typ, events[i].Type, "event %d", i)
+		assert.Equal(t, expected.version, events[i].Version, "event %d", i)
+		assert.Equal(t, expected.index, events[i].Index, "event %d", i)
+		assert.Equal(t, 3, events[i].Total, "event %d", i)
+	}
+
+	for _, i := range []int{0, 2, 4} {
+		assert.Zero(t, events[i].Duration, "started event %d", i)
+		assert.NoError(t, events[i].Err, "started event %d", i)
+	}
+
+	assert.Positive(t, events[1].Duration)
+	assert.GreaterOrEqual(t, events[3].Duration, 10*time.Millisecond)
+	assert.Positive(t, events[5].Duration)
+
+	assert.NoError(t, events[1].Err)
+	assert.NoError(t, events[3].Err)
+	assert.ErrorIs(t, events[5].Err, errBroken)
+}
+
+func TestMigratorRun_GoMigrations(t *testing.T) {
+	t.Run("rolled back on error", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		m := NewMigrator(client, "")
+		m.Register(
+			&GoMigration{
+				Version: "0002_backfill",
+				Up: func(ctx context.Context, conn pg.Conn) error {
+					if _, err := conn.Exec(ctx, "UPDATE users SET x = 1"); err != nil {
+						return err
+					}
+
+					return errors.New("cannot transform row")
+				},
+			},
+			&GoMigration{
+				Version: "0003_next",
+				Up: func(ctx context.Context, conn pg.Conn) error {
+					_, err := conn.Exec(ctx, "UPDATE users SET y = 1")
+					return err
+				},
+			},
+		)
+
+		err := m.RunAll(context.Background())
+		require.EqualError(
+			t,
+			err,
+			`cannot apply migration "0002_backfill": cannot execute migration: cannot transform row`,
+		)
+
+		// The version is not recorded and the following migrations
+		// are not applied.
+		assertQueryPrefixes(
+			t,
+			[]string{
+				"SELECT pg_advisory_lock(",
+				`CREATE TABLE IF NOT EXISTS "schema_versions"`,
+				`SELECT version FROM "schema_versions"`,
+				"begin",
+				"UPDATE users SET x = 1",
+				"rollback",
+				"SELECT pg_advisory_unlock(",
+			},
+			server.receivedQueries(),
+		)
+	})
+
+	t.Run("duplicate version", func(t *testing.T) {
+		addr, server := newFakeServer(t)
+		client := newTestClient(t, addr)
+
+		m := NewMigrator(client, "")
+		m.Register(
+			&GoMigration{
+				Version: "0002_users",
+				Up:      func(context.Context, pg.Conn) error { return nil },
+			},
+		)
+
+		err := m.RunAll(
+			context.Background(),
+			MigrationSource{FS: fstest.MapFS{"0002_users.sql": {Data: []byte("CREATE TABLE users ()")}}},
+		)
+		require.EqualError(
+			t,
+			err,
+			`duplicate migration version "0002_users": defined by "0002_users.sql" and go migration`,
+		)
+		assert.Empty(t, server.received())
+	})
+}
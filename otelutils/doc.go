@@ -0,0 +1,5 @@
+// Package otelutils provides OpenTelemetry helpers, such as tracer and
+// meter provider wrappers guaranteeing that the telemetry produced by the
+// application is accepted by OTLP backends, and a sampler honoring
+// sampling decisions forced through the context.
+package otelutils
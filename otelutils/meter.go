@@ -0,0 +1,334 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+)
+
+type (
+	// UTF8MeterProvider is a metric.MeterProvider ensuring every
+	// string handed to the wrapped provider (meter and instrument
+	// names, attribute keys and values) is valid UTF-8.
+	UTF8MeterProvider struct {
+		embedded.MeterProvider
+
+		next metric.MeterProvider
+	}
+
+	utf8Meter struct {
+		embedded.Meter
+
+		next metric.Meter
+	}
+
+	utf8Observer struct {
+		embedded.Observer
+
+		next metric.Observer
+	}
+
+	utf8Int64Observer struct {
+		embedded.Int64Observer
+
+		next metric.Int64Observer
+	}
+
+	utf8Float64Observer struct {
+		embedded.Float64Observer
+
+		next metric.Float64Observer
+	}
+
+	utf8Int64Counter       struct{ metric.Int64Counter }
+	utf8Int64UpDownCounter struct{ metric.Int64UpDownCounter }
+	utf8Int64Histogram     struct{ metric.Int64Histogram }
+	utf8Int64Gauge         struct{ metric.Int64Gauge }
+
+	utf8Float64Counter       struct{ metric.Float64Counter }
+	utf8Float64UpDownCounter struct{ metric.Float64UpDownCounter }
+	utf8Float64Histogram     struct{ metric.Float64Histogram }
+	utf8Float64Gauge         struct{ metric.Float64Gauge }
+)
+
+var (
+	_ metric.MeterProvider = (*UTF8MeterProvider)(nil)
+	_ metric.Meter         = (*utf8Meter)(nil)
+	_ metric.Observer      = (*utf8Observer)(nil)
+)
+
+// WrapMeterProvider returns a meter provider sanitizing all the
+// strings passed to next with ToValidUTF8.
+func WrapMeterProvider(next metric.MeterProvider) *UTF8MeterProvider {
+	return &UTF8MeterProvider{next: next}
+}
+
+// Meter returns a meter sanitizing the instruments it creates and the
+// measurements they record.
+func (mp *UTF8MeterProvider) Meter(name string, options ...metric.MeterOption) metric.Meter {
+	config := metric.NewMeterConfig(options...)
+	attrs := config.InstrumentationAttributes()
+
+	return &utf8Meter{
+		next: mp.next.Meter(
+			ToValidUTF8(name),
+			metric.WithInstrumentationVersion(ToValidUTF8(config.InstrumentationVersion())),
+			metric.WithInstrumentationAttributes(
+				sanitizeAttributes(attrs.ToSlice())...,
+			),
+			metric.WithSchemaURL(config.SchemaURL()),
+		),
+	}
+}
+
+func (m *utf8Meter) Int64Counter(name string, options ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	i, err := m.next.Int64Counter(ToValidUTF8(name), options...)
+	return &utf8Int64Counter{i}, err
+}
+
+func (m *utf8Meter) Int64UpDownCounter(name string, options ...metric.Int64UpDownCounterOption) (metric.Int64UpDownCounter, error) {
+	i, err := m.next.Int64UpDownCounter(ToValidUTF8(name), options...)
+	return &utf8Int64UpDownCounter{i}, err
+}
+
+func (m *utf8Meter) Int64Histogram(name string, options ...metric.Int64HistogramOption) (metric.Int64Histogram, error) {
+	i, err := m.next.Int64Histogram(ToValidUTF8(name), options...)
+	return &utf8Int64Histogram{i}, err
+}
+
+func (m *utf8Meter) Int64Gauge(name string, options ...metric.Int64GaugeOption) (metric.Int64Gauge, error) {
+	i, err := m.next.Int64Gauge(ToValidUTF8(name), options...)
+	return &utf8Int64Gauge{i}, err
+}
+
+func (m *utf8Meter) Int64ObservableCounter(name string, options ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	config := metric.NewInt64ObservableCounterConfig(options...)
+
+	sanitizedOptions := []metric.Int64ObservableCounterOption{
+		metric.WithDescription(config.Description()),
+		metric.WithUnit(config.Unit()),
+	}
+	for _, callback := range config.Callbacks() {
+		sanitizedOptions = append(sanitizedOptions, metric.WithInt64Callback(sanitizeInt64Callback(callback)))
+	}
+
+	return m.next.Int64ObservableCounter(ToValidUTF8(name), sanitizedOptions...)
+}
+
+func (m *utf8Meter) Int64ObservableUpDownCounter(name string, options ...metric.Int64ObservableUpDownCounterOption) (metric.Int64ObservableUpDownCounter, error) {
+	config := metric.NewInt64ObservableUpDownCounterConfig(options...)
+
+	sanitizedOptions := []metric.Int64ObservableUpDownCounterOption{
+		metric.WithDescription(config.Description()),
+		metric.WithUnit(config.Unit()),
+	}
+	for _, callback := range config.Callbacks() {
+		sanitizedOptions = append(sanitizedOptions, metric.WithInt64Callback(sanitizeInt64Callback(callback)))
+	}
+
+	return m.next.Int64ObservableUpDownCounter(ToValidUTF8(name), sanitizedOptions...)
+}
+
+func (m *utf8Meter) Int64ObservableGauge(name string, options ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	config := metric.NewInt64ObservableGaugeConfig(options...)
+
+	sanitizedOptions := []metric.Int64ObservableGaugeOption{
+		metric.WithDescription(config.Description()),
+		metric.WithUnit(config.Unit()),
+	}
+	for _, callback := range config.Callbacks() {
+		sanitizedOptions = append(sanitizedOptions, metric.WithInt64Callback(sanitizeInt64Callback(callback)))
+	}
+
+	return m.next.Int64ObservableGauge(ToValidUTF8(name), sanitizedOptions...)
+}
+
+func (m *utf8Meter) Float64Counter(name string, options ...metric.Float64CounterOption) (metric.Float64Counter, error) {
+	i, err := m.next.Float64Counter(ToValidUTF8(name), options...)
+	return &utf8Float64Counter{i}, err
+}
+
+func (m *utf8Meter) Float64UpDownCounter(name string, options ...metric.Float64UpDownCounterOption) (metric.Float64UpDownCounter, error) {
+	i, err := m.next.Float64UpDownCounter(ToValidUTF8(name), options...)
+	return &utf8Float64UpDownCounter{i}, err
+}
+
+func (m *utf8Meter) Float64Histogram(name string, options ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	i, err := m.next.Float64Histogram(ToValidUTF8(name), options...)
+	return &utf8Float64Histogram{i}, err
+}
+
+func (m *utf8Meter) Float64Gauge(name string, options ...metric.Float64GaugeOption) (metric.Float64Gauge, error) {
+	i, err := m.next.Float64Gauge(ToValidUTF8(name), options...)
+	return &utf8Float64Gauge{i}, err
+}
+
+func (m *utf8Meter) Float64ObservableCounter(name string, options ...metric.Float64ObservableCounterOption) (metric.Float64ObservableCounter, error) {
+	config := metric.NewFloat64ObservableCounterConfig(options...)
+
+	sanitizedOptions := []metric.Float64ObservableCounterOption{
+		metric.WithDescription(config.Description()),
+		metric.WithUnit(config.Unit()),
+	}
+	for _, callback := range config.Callbacks() {
+		sanitizedOptions = append(sanitizedOptions, metric.WithFloat64Callback(sanitizeFloat64Callback(callback)))
+	}
+
+	return m.next.Float64ObservableCounter(ToValidUTF8(name), sanitizedOptions...)
+}
+
+func (m *utf8Meter) Float64ObservableUpDownCounter(name string, options ...metric.Float64ObservableUpDownCounterOption) (metric.Float64ObservableUpDownCounter, error) {
+	config := metric.NewFloat64ObservableUpDownCounterConfig(options...)
+
+	sanitizedOptions := []metric.Float64ObservableUpDownCounterOption{
+		metric.WithDescription(config.Description()),
+		metric.WithUnit(config.Unit()),
+	}
+	for _, callback := range config.Callbacks() {
+		sanitizedOptions = append(sanitizedOptions, metric.WithFloat64Callback(sanitizeFloat64Callback(callback)))
+	}
+
+	return m.next.Float64ObservableUpDownCounter(ToValidUTF8(name), sanitizedOptions...)
+}
+
+func (m *utf8Meter) Float64ObservableGauge(name string, options ...metric.Float64ObservableGaugeOption) (metric.Float64ObservableGauge, error) {
+	config := metric.NewFloat64ObservableGaugeConfig(options...)
+
+	sanitizedOptions := []metric.Float64ObservableGaugeOption{
+		metric.WithDescription(config.Description()),
+		metric.WithUnit(config.Unit()),
+	}
+	for _, callback := range config.Callbacks() {
+		sanitizedOptions = append(sanitizedOptions, metric.WithFloat64Callback(sanitizeFloat64Callback(callback)))
+	}
+
+	return m.next.Float64ObservableGauge(ToValidUTF8(name), sanitizedOptions...)
+}
+
+// RegisterCallback registers f with the wrapped meter. Observable
+// instruments are returned unwrapped by this meter, so they are
+// handed to the wrapped meter as is.
+func (m *utf8Meter) RegisterCallback(f metric.Callback, instruments ...metric.Observable) (metric.Registration, error) {
+	return m.next.RegisterCallback(
+		func(ctx context.Context, o metric.Observer) error {
+			return f(ctx, &utf8Observer{next: o})
+		},
+		instruments...,
+	)
+}
+
+func (o *utf8Observer) ObserveInt64(obsrv metric.Int64Observable, value int64, options ...metric.ObserveOption) {
+	o.next.ObserveInt64(obsrv, value, sanitizeObserveOptions(options)...)
+}
+
+func (o *utf8Observer) ObserveFloat64(obsrv metric.Float64Observable, value float64, options ...metric.ObserveOption) {
+	o.next.ObserveFloat64(obsrv, value, sanitizeObserveOptions(options)...)
+}
+
+func (o *utf8Int64Observer) Observe(value int64, options ...metric.ObserveOption) {
+	o.next.Observe(value, sanitizeObserveOptions(options)...)
+}
+
+func (o *utf8Float64Observer) Observe(value float64, options ...metric.ObserveOption) {
+	o.next.Observe(value, sanitizeObserveOptions(options)...)
+}
+
+func (i *utf8Int64Counter) Add(ctx context.Context, incr int64, options ...metric.AddOption) {
+	i.Int64Counter.Add(ctx, incr, sanitizeAddOptions(options)...)
+}
+
+func (i *utf8Int64UpDownCounter) Add(ctx context.Context, incr int64, options ...metric.AddOption) {
+	i.Int64UpDownCounter.Add(ctx, incr, sanitizeAddOptions(options)...)
+}
+
+func (i *utf8Int64Histogram) Record(ctx context.Context, incr int64, options ...metric.RecordOption) {
+	i.Int64Histogram.Record(ctx, incr, sanitizeRecordOptions(options)...)
+}
+
+func (i *utf8Int64Gauge) Record(ctx context.Context, value int64, options ...metric.RecordOption) {
+	i.Int64Gauge.Record(ctx, value, sanitizeRecordOptions(options)...)
+}
+
+func (i *utf8Float64Counter) Add(ctx context.Context, incr float64, options ...metric.AddOption) {
+	i.Float64Counter.Add(ctx, incr, sanitizeAddOptions(options)...)
+}
+
+func (i *utf8Float64UpDownCounter) Add(ctx context.Context, incr float64, options ...metric.AddOption) {
+	i.Float64UpDownCounter.Add(ctx, incr, sanitizeAddOptions(options)...)
+}
+
+func (i *utf8Float64Histogram) Record(ctx context.Context, incr float64, options ...metric.RecordOption) {
+	i.Float64Histogram.Record(ctx, incr, sanitizeRecordOptions(options)...)
+}
+
+func (i *utf8Float64Gauge) Record(ctx context.Context, value float64, options ...metric.RecordOption) {
+	i.Float64Gauge.Record(ctx, value, sanitizeRecordOptions(options)...)
+}
+
+func sanitizeInt64Callback(callback metric.Int64Callback) metric.Int64Callback {
+	return func(ctx context.Context, o metric.Int64Observer) error {
+		return callback(ctx, &utf8Int64Observer{next: o})
+	}
+}
+
+func sanitizeFloat64Callback(callback metric.Float64Callback) metric.Float64Callback {
+	return func(ctx context.Context, o metric.Float64Observer) error {
+		return callback(ctx, &utf8Float64Observer{next: o})
+	}
+}
+
+func sanitizeAddOptions(options []metric.AddOption) []metric.AddOption {
+	if len(options) == 0 {
+		return options
+	}
+
+	set, ok := sanitizeSet(metric.NewAddConfig(options).Attributes())
+	if !ok {
+		return options
+	}
+
+	return []metric.AddOption{metric.WithAttributeSet(set)}
+}
+
+func sanitizeRecordOptions(options []metric.RecordOption) []metric.RecordOption {
+	if len(options) == 0 {
+		return options
+	}
+
+	set, ok := sanitizeSet(metric.NewRecordConfig(options).Attributes())
+	if !ok {
+		return options
+	}
+
+	return []metric.RecordOption{metric.WithAttributeSet(set)}
+}
+
+func sanitizeObserveOptions(options []metric.ObserveOption) []metric.ObserveOption {
+	if len(options) == 0 {
+		return options
+	}
+
+	set, ok := sanitizeSet(metric.NewObserveConfig(options).Attributes())
+	if !ok {
+		return options
+	}
+
+	return []metric.ObserveOption{metric.WithAttributeSet(set)}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"context"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestUTF8MeterProvider_SanitizesAllStrings(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := WrapMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	meter := mp.Meter("meter " + invalid)
+
+	// The SDK reports names which are not valid instrument names but
+	// still creates the instruments.
+	counter, _ := meter.Int64Counter("counter" + invalid)
+	counter.Add(
+		context.Background(),
+		1,
+		metric.WithAttributes(
+			attribute.String("key "+invalid, invalid),
+			attribute.StringSlice("slice", []string{"ok", invalid}),
+			attribute.Int("int", 42),
+		),
+	)
+
+	histogram, err := meter.Float64Histogram("histogram")
+	require.NoError(t, err)
+	histogram.Record(context.Background(), 1.5, metric.WithAttributes(attribute.String("path", invalid)))
+
+	_, err = meter.Int64ObservableGauge(
+		"gauge",
+		metric.WithInt64Callback(
+			func(ctx context.Context, o metric.Int64Observer) error {
+				o.Observe(1, metric.WithAttributes(attribute.String("host", invalid)))
+				return nil
+			},
+		),
+	)
+	require.NoError(t, err)
+
+	observable, err := meter.Float64ObservableCounter("observable")
+	require.NoError(t, err)
+
+	_, err = meter.RegisterCallback(
+		func(ctx context.Context, o metric.Observer) error {
+			o.ObserveFloat64(observable, 2, metric.WithAttributes(attribute.String("host", invalid)))
+			return nil
+		},
+		observable,
+	)
+	require.NoError(t, err)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	if !assert.Len(t, rm.ScopeMetrics, 1) {
+		return
+	}
+
+	sm := rm.ScopeMetrics[0]
+	assert.Equal(t, "meter bad�bytes", sm.Scope.Name)
+	assert.Len(t, sm.Metrics, 4)
+
+	for _, m := range sm.Metrics {
+		assert.True(t, utf8.ValidString(m.Name), "invalid name %q", m.Name)
+
+		var sets []attribute.Set
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			for _, dp := range data.DataPoints {
+				sets = append(sets, dp.Attributes)
+			}
+		case metricdata.Sum[float64]:
+			for _, dp := range data.DataPoints {
+				sets = append(sets, dp.Attributes)
+			}
+		case metricdata.Gauge[int64]:
+			for _, dp := range data.DataPoints {
+				sets = append(sets, dp.Attributes)
+			}
+		case metricdata.Histogram[float64]:
+			for _, dp := range data.DataPoints {
+				sets = append(sets, dp.Attributes)
+			}
+		default:
+			t.Fatalf("unexpected data type %T", m.Data)
+		}
+
+		assert.Len(t, sets, 1, "metric %q", m.Name)
+		for _, set := range sets {
+			assertValidAttributes(t, set.ToSlice())
+		}
+	}
+}
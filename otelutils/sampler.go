@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"context"
+	"fmt"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// SamplingOverride forces the sampling decision of the spans
+	// started with a context carrying it, see
+	// ContextWithSamplingOverride.
+	SamplingOverride int
+
+	overrideSampler struct {
+		next sdktrace.Sampler
+	}
+
+	samplingOverrideKey struct{}
+)
+
+const (
+	// SampleDefault leaves the decision to the wrapped sampler.
+	SampleDefault SamplingOverride = iota
+
+	// SampleAlways records and samples the spans.
+	SampleAlways
+
+	// SampleNever drops the spans.
+	SampleNever
+)
+
+// ContextWithSamplingOverride returns a copy of ctx carrying the
+// sampling override o, honored by the samplers created with
+// NewOverrideSampler.
+func ContextWithSamplingOverride(ctx context.Context, o SamplingOverride) context.Context {
+	return context.WithValue(ctx, samplingOverrideKey{}, o)
+}
+
+// SamplingOverrideFromContext returns the sampling override carried by
+// ctx, or SampleDefault if there is none.
+func SamplingOverrideFromContext(ctx context.Context) SamplingOverride {
+	o, _ := ctx.Value(samplingOverrideKey{}).(SamplingOverride)
+	return o
+}
+
+// NewOverrideSampler returns a sampler honoring the sampling override
+// carried by the context of the spans being started, and delegating
+// to next otherwise.
+//
+// The override takes precedence over the parent span decision, even
+// when next is parent based: SampleAlways samples the span of an
+// unsampled remote parent, and SampleNever drops the span of a
+// sampled one, leaving a gap in the trace as the children of the
+// dropped span follow its decision.
+func NewOverrideSampler(next sdktrace.Sampler) sdktrace.Sampler {
+	return &overrideSampler{next: next}
+}
+
+func (s *overrideSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	var decision sdktrace.SamplingDecision
+	switch SamplingOverrideFromContext(p.ParentContext) {
+	case SampleAlways:
+		decision = sdktrace.RecordAndSample
+	case SampleNever:
+		decision = sdktrace.Drop
+	default:
+		return s.next.ShouldSample(p)
+	}
+
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s *overrideSampler) Description() string {
+	return fmt.Sprintf("OverrideSampler{%s}", s.next.Description())
+}
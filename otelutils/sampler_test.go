@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOverrideSampler(t *testing.T) {
+	newTracer := func(next sdktrace.Sampler) trace.Tracer {
+		return sdktrace.NewTracerProvider(
+			sdktrace.WithSampler(NewOverrideSampler(next)),
+		).Tracer("test")
+	}
+
+	tests := []struct {
+		name     string
+		next     sdktrace.Sampler
+		override SamplingOverride
+		sampled  bool
+	}{
+		{"default sampled", sdktrace.AlwaysSample(), SampleDefault, true},
+		{"default dropped", sdktrace.NeverSample(), SampleDefault, false},
+		{"always", sdktrace.NeverSample(), SampleAlways, true},
+		{"never", sdktrace.AlwaysSample(), SampleNever, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name,
+			func(t *testing.T) {
+				ctx := ContextWithSamplingOverride(context.Background(), tt.override)
+
+				_, span := newTracer(tt.next).Start(ctx, "span")
+				defer span.End()
+
+				assert.Equal(t, tt.sampled, span.SpanContext().IsSampled())
+			},
+		)
+	}
+
+	t.Run(
+		"overrides the parent decision",
+		func(t *testing.T) {
+			tracer := newTracer(sdktrace.ParentBased(sdktrace.AlwaysSample()))
+
+			ctx, parent := tracer.Start(context.Background(), "parent")
+			defer parent.End()
+			assert.True(t, parent.SpanContext().IsSampled())
+
+			_, span := tracer.Start(ContextWithSamplingOverride(ctx, SampleNever), "span")
+			defer span.End()
+			assert.False(t, span.SpanContext().IsSampled())
+		},
+	)
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"context"
+	"reflect"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+type (
+	// TracerProviderOption configures the UTF8TracerProvider during
+	// initialization.
+	TracerProviderOption func(tp *UTF8TracerProvider)
+
+	// UTF8TracerProvider is a trace.TracerProvider ensuring every
+	// string handed to the wrapped provider (span names, attribute
+	// keys and values, event names, status descriptions and
+	// recorded errors) is valid UTF-8.
+	UTF8TracerProvider struct {
+		embedded.TracerProvider
+
+		next               trace.TracerProvider
+		maxAttributeLength int
+	}
+
+	utf8Tracer struct {
+		embedded.Tracer
+
+		provider *UTF8TracerProvider
+		next     trace.Tracer
+	}
+
+	utf8Span struct {
+		embedded.Span
+
+		provider *UTF8TracerProvider
+		next     trace.Span
+	}
+)
+
+var (
+	_ trace.TracerProvider = (*UTF8TracerProvider)(nil)
+	_ trace.Tracer         = (*utf8Tracer)(nil)
+	_ trace.Span           = (*utf8Span)(nil)
+)
+
+// WithMaxAttributeLength truncates the string and string slice
+// attribute values longer than n characters, replacing their end with
+// an ellipsis, so they are not rejected by a collector enforcing an
+// attribute length limit. Values are not truncated when n is zero,
+// which is the default.
+func WithMaxAttributeLength(n int) TracerProviderOption {
+	return func(tp *UTF8TracerProvider) {
+		tp.maxAttributeLength = n
+	}
+}
+
+// WrapTracerProvider returns a tracer provider sanitizing all the
+// strings passed to next with ToValidUTF8.
+func WrapTracerProvider(next trace.TracerProvider, options ...TracerProviderOption) *UTF8TracerProvider {
+	tp := &UTF8TracerProvider{next: next}
+
+	for _, o := range options {
+		o(tp)
+	}
+
+	return tp
+}
+
+// Tracer returns a tracer sanitizing the spans it creates.
+func (tp *UTF8TracerProvider) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	config := trace.NewTracerConfig(options...)
+	attrs := config.InstrumentationAttributes()
+
+	return &utf8Tracer{
+		provider: tp,
+		next: tp.next.Tracer(
+			ToValidUTF8(name),
+			trace.WithInstrumentationVersion(ToValidUTF8(config.InstrumentationVersion())),
+			trace.WithInstrumentationAttributes(
+				sanitizeAttributes(attrs.ToSlice())...,
+			),
+			trace.WithSchemaURL(config.SchemaURL()),
+		),
+	}
+}
+
+func (t *utf8Tracer) Start(
+	ctx context.Context,
+	name string,
+	options ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(options...)
+
+	links := config.Links()
+	for i := range links {
+		links[i].Attributes = t.provider.sanitizeAttributes(links[i].Attributes)
+	}
+
+	sanitizedOptions := []trace.SpanStartOption{
+		trace.WithAttributes(t.provider.sanitizeAttributes(config.Attributes())...),
+		trace.WithLinks(links...),
+		trace.WithSpanKind(config.SpanKind()),
+	}
+
+	if !config.Timestamp().IsZero() {
+		sanitizedOptions = append(sanitizedOptions, trace.WithTimestamp(config.Timestamp()))
+	}
+
+	if config.NewRoot() {
+		sanitizedOptions = append(sanitizedOptions, trace.WithNewRoot())
+	}
+
+	ctx, span := t.next.Start(ctx, ToValidUTF8(name), sanitizedOptions...)
+
+	// Store the wrapper in the context so callers retrieving the
+	// span with trace.SpanFromContext are sanitized as well.
+	wrapped := &utf8Span{provider: t.provider, next: span}
+	return trace.ContextWithSpan(ctx, wrapped), wrapped
+}
+
+func (s *utf8Span) End(options ...trace.SpanEndOption) {
+	s.next.End(options...)
+}
+
+func (s *utf8Span) AddEvent(name string, options ...trace.EventOption) {
+	s.next.AddEvent(ToValidUTF8(name), s.provider.sanitizeEventOptions(options)...)
+}
+
+func (s *utf8Span) AddLink(link trace.Link) {
+	link.Attributes = s.provider.sanitizeAttributes(link.Attributes)
+	s.next.AddLink(link)
+}
+
+func (s *utf8Span) IsRecording() bool {
+	return s.next.IsRecording()
+}
+
+// RecordError records err as an exception event, following the same
+// conventions as the SDK but with a sanitized message.
+func (s *utf8Span) RecordError(err error, options ...trace.EventOption) {
+	if err == nil || !s.next.IsRecording() {
+		return
+	}
+
+	config := trace.NewEventConfig(options...)
+	attrs := append(
+		config.Attributes(),
+		semconv.ExceptionType(errorType(err)),
+		semconv.ExceptionMessage(err.Error()),
+	)
+
+	if config.StackTrace() {
+		attrs = append(attrs, semconv.ExceptionStacktrace(string(debug.Stack())))
+	}
+
+	eventOptions := []trace.EventOption{trace.WithAttributes(attrs...)}
+	if !config.Timestamp().IsZero() {
+		eventOptions = append(eventOptions, trace.WithTimestamp(config.Timestamp()))
+	}
+
+	s.AddEvent(semconv.ExceptionEventName, eventOptions...)
+}
+
+func (s *utf8Span) SpanContext() trace.SpanContext {
+	return s.next.SpanContext()
+}
+
+func (s *utf8Span) SetStatus(code codes.Code, description string) {
+	s.next.SetStatus(code, ToValidUTF8(description))
+}
+
+func (s *utf8Span) SetName(name string) {
+	s.next.SetName(ToValidUTF8(name))
+}
+
+func (s *utf8Span) SetAttributes(kv ...attribute.KeyValue) {
+	s.next.SetAttributes(s.provider.sanitizeAttributes(kv)...)
+}
+
+func (s *utf8Span) TracerProvider() trace.TracerProvider {
+	return s.provider
+}
+
+func (tp *UTF8TracerProvider) sanitizeAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	attrs = sanitizeAttributes(attrs)
+
+	if tp.maxAttributeLength > 0 {
+		for i, attr := range attrs {
+			attrs[i] = truncateAttribute(attr, tp.maxAttributeLength)
+		}
+	}
+
+	return attrs
+}
+
+func (tp *UTF8TracerProvider) sanitizeEventOptions(options []trace.EventOption) []trace.EventOption {
+	config := trace.NewEventConfig(options...)
+
+	sanitized := []trace.EventOption{
+		trace.WithAttributes(tp.sanitizeAttributes(config.Attributes())...),
+		trace.WithStackTrace(config.StackTrace()),
+	}
+
+	if !config.Timestamp().IsZero() {
+		sanitized = append(sanitized, trace.WithTimestamp(config.Timestamp()))
+	}
+
+	return sanitized
+}
+
+func errorType(err error) string {
+	t := reflect.TypeOf(err)
+	if t.PkgPath() == "" && t.Name() == "" {
+		return t.String()
+	}
+
+	return t.PkgPath() + "." + t.Name()
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const invalid = "bad\xff\xfebytes"
+
+func assertValidAttributes(t *testing.T, attrs []attribute.KeyValue) {
+	t.Helper()
+
+	for _, attr := range attrs {
+		assert.True(t, utf8.ValidString(string(attr.Key)), "invalid key %q", attr.Key)
+		assert.True(t, utf8.ValidString(attr.Value.Emit()), "invalid value for %q", attr.Key)
+	}
+}
+
+func TestToValidUTF8(t *testing.T) {
+	assert.Equal(t, "hello", ToValidUTF8("hello"))
+	assert.Equal(t, "bad�bytes", ToValidUTF8(invalid))
+}
+
+func TestUTF8TracerProvider_SanitizesAllStrings(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := WrapTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, span := tp.Tracer("test").Start(
+		context.Background(),
+		"span "+invalid,
+		trace.WithAttributes(
+			attribute.String("key "+invalid, invalid),
+			attribute.StringSlice("slice", []string{"ok", invalid}),
+			attribute.Int("int", 42),
+		),
+	)
+
+	assert.Same(t, span, trace.SpanFromContext(ctx))
+
+	span.SetName("renamed " + invalid)
+	span.SetAttributes(attribute.String("late", invalid))
+	span.AddEvent("event "+invalid, trace.WithAttributes(attribute.String("event", invalid)))
+	span.RecordError(errors.New(invalid))
+	span.SetStatus(codes.Error, invalid)
+	span.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+
+	s := spans[0]
+	assert.Equal(t, "renamed bad�bytes", s.Name())
+	assert.True(t, utf8.ValidString(s.Status().Description))
+	assertValidAttributes(t, s.Attributes())
+	assert.Contains(t, s.Attributes(), attribute.Int("int", 42))
+
+	assert.Len(t, s.Events(), 2)
+	for _, event := range s.Events() {
+		assert.True(t, utf8.ValidString(event.Name))
+		assertValidAttributes(t, event.Attributes)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "hello", truncate("hello", 5))
+	assert.Equal(t, "hell…", truncate("hello world", 5))
+	assert.Equal(t, "héll…", truncate("héllo wörld", 5))
+	assert.Equal(t, "…", truncate("hello", 1))
+}
+
+func TestUTF8TracerProvider_TruncatesAttributes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := WrapTracerProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+		WithMaxAttributeLength(8),
+	)
+
+	_, span := tp.Tracer("test").Start(
+		context.Background(),
+		"span",
+		trace.WithAttributes(
+			attribute.String("db.statement", "SELECT * FROM users"),
+			attribute.StringSlice("slice", []string{"short", "much too long"}),
+			attribute.String("invalid", invalid),
+		),
+	)
+	span.RecordError(errors.New("a long error message"), trace.WithStackTrace(true))
+	span.End()
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 1) {
+		return
+	}
+
+	s := spans[0]
+	assert.ElementsMatch(
+		t,
+		[]attribute.KeyValue{
+			attribute.String("db.statement", "SELECT …"),
+			attribute.StringSlice("slice", []string{"short", "much to…"}),
+			attribute.String("invalid", "bad�byt…"),
+		},
+		s.Attributes(),
+	)
+
+	if !assert.Len(t, s.Events(), 1) {
+		return
+	}
+
+	for _, attr := range s.Events()[0].Attributes {
+		assert.LessOrEqual(t, utf8.RuneCountInString(attr.Value.AsString()), 8, "attribute %q", attr.Key)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package otelutils
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ToValidUTF8 returns s with each run of invalid UTF-8 byte sequences
+// replaced by the Unicode replacement character. OTLP exporters reject
+// the whole batch when a single string is not valid UTF-8.
+func ToValidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
+
+func sanitizeAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	if len(attrs) == 0 {
+		return attrs
+	}
+
+	sanitized := make([]attribute.KeyValue, len(attrs))
+	for i, attr := range attrs {
+		sanitized[i] = sanitizeAttribute(attr)
+	}
+
+	return sanitized
+}
+
+func sanitizeAttribute(attr attribute.KeyValue) attribute.KeyValue {
+	key := attribute.Key(ToValidUTF8(string(attr.Key)))
+
+	switch attr.Value.Type() {
+	case attribute.STRING:
+		return key.String(ToValidUTF8(attr.Value.AsString()))
+	case attribute.STRINGSLICE:
+		values := attr.Value.AsStringSlice()
+		for i, v := range values {
+			values[i] = ToValidUTF8(v)
+		}
+
+		return key.StringSlice(values)
+	default:
+		return attribute.KeyValue{Key: key, Value: attr.Value}
+	}
+}
+
+// sanitizeSet returns a sanitized copy of set and true when set holds
+// invalid UTF-8, or false when it can be used as is.
+func sanitizeSet(set attribute.Set) (attribute.Set, bool) {
+	iter := set.Iter()
+	for iter.Next() {
+		if !isValidAttribute(iter.Attribute()) {
+			return attribute.NewSet(sanitizeAttributes(set.ToSlice())...), true
+		}
+	}
+
+	return set, false
+}
+
+func isValidAttribute(attr attribute.KeyValue) bool {
+	if !utf8.ValidString(string(attr.Key)) {
+		return false
+	}
+
+	switch attr.Value.Type() {
+	case attribute.STRING:
+		return utf8.ValidString(attr.Value.AsString())
+	case attribute.STRINGSLICE:
+		for _, v := range attr.Value.AsStringSlice() {
+			if !utf8.ValidString(v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// truncate returns s limited to n characters, the last one being an
+// ellipsis when s is longer than n.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	var (
+		i     int
+		count int
+	)
+	for i = range s {
+		if count == n-1 {
+			break
+		}
+		count++
+	}
+
+	return s[:i] + "…"
+}
+
+func truncateAttribute(attr attribute.KeyValue, n int) attribute.KeyValue {
+	switch attr.Value.Type() {
+	case attribute.STRING:
+		return attr.Key.String(truncate(attr.Value.AsString(), n))
+	case attribute.STRINGSLICE:
+		values := attr.Value.AsStringSlice()
+		for i, v := range values {
+			values[i] = truncate(v, n)
+		}
+
+		return attr.Key.StringSlice(values)
+	default:
+		return attr
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// Batch holds statements sent to the server in a single round
+	// trip.
+	Batch struct {
+		batch pgx.Batch
+	}
+
+	// StatementResult is the outcome of one statement of a batch.
+	StatementResult struct {
+		CommandTag pgconn.CommandTag
+		Err        error
+	}
+
+	// BatchResult holds the result of each statement of a batch, in
+	// the order they were queued.
+	BatchResult []StatementResult
+)
+
+// Queue adds a statement to the batch.
+func (b *Batch) Queue(sql string, args ...any) {
+	b.batch.Queue(sql, args...)
+}
+
+// Len returns the number of statements in the batch.
+func (b *Batch) Len() int {
+	return b.batch.Len()
+}
+
+// Err returns the errors of the failed statements joined together, or
+// nil when every statement succeeded.
+func (br BatchResult) Err() error {
+	var errs []error
+	for i, r := range br {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("statement %d: %w", i, r.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Batch builds a batch with the given function and executes it on a
+// connection from the pool, returning the result of each statement.
+// The returned error only reports a failure to execute the batch
+// itself; statement errors are in the BatchResult.
+//
+// The batch does not run in an explicit transaction. PostgreSQL runs
+// it in an implicit one, so once a statement fails the following ones
+// fail as well and none of the changes are kept. Use SendBatch within
+// WithTx to make the batch part of a larger transaction.
+//
+// Example:
+//
+//	result, err := client.Batch(ctx, func(b *pg.Batch) {
+//	    for _, id := range ids {
+//	        b.Queue("DELETE FROM sessions WHERE user_id = $1", id)
+//	    }
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	if err := result.Err(); err != nil {
+//	    return err
+//	}
+//
+// If tracing is enabled, this method creates a span named "Batch"
+// and logs any errors.
+func (c *Client) Batch(ctx context.Context, build func(*Batch)) (BatchResult, error) {
+	b := &Batch{}
+	build(b)
+
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+		result   BatchResult
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"Batch",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(BatchSizeKey.Int(b.Len())),
+		)
+		defer span.End()
+	}
+
+	err := c.WithConn(
+		ctx,
+		func(conn Conn) error {
+			var err error
+			result, err = SendBatch(ctx, conn, b)
+			return err
+		},
+	)
+	if rootSpan.IsRecording() {
+		if err := errors.Join(err, result.Err()); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+	}
+
+	return result, err
+}
+
+// SendBatch executes the batch on conn and returns the result of each
+// statement, which makes it usable within WithTx. The returned error
+// only reports a failure to execute the batch itself.
+func SendBatch(ctx context.Context, conn Conn, b *Batch) (BatchResult, error) {
+	if b.Len() == 0 {
+		return BatchResult{}, nil
+	}
+
+	var (
+		br     = conn.SendBatch(ctx, &b.batch)
+		result = make(BatchResult, b.Len())
+		failed bool
+	)
+
+	for i := range result {
+		tag, err := br.Exec()
+		result[i] = StatementResult{CommandTag: tag, Err: err}
+		failed = failed || err != nil
+	}
+
+	// Close reports the first statement error again, which is already
+	// part of the result.
+	if err := br.Close(); err != nil && !failed {
+		return result, fmt.Errorf("cannot close batch results: %w", err)
+	}
+
+	return result, nil
+}
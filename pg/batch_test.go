@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	batchConn struct {
+		Conn
+
+		results []StatementResult
+	}
+
+	batchResults struct {
+		pgx.BatchResults
+
+		results []StatementResult
+	}
+)
+
+func (c *batchConn) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	return &batchResults{results: c.results}
+}
+
+func (br *batchResults) Exec() (pgconn.CommandTag, error) {
+	r := br.results[0]
+	br.results = br.results[1:]
+	return r.CommandTag, r.Err
+}
+
+func (br *batchResults) Close() error {
+	return nil
+}
+
+func TestSendBatch(t *testing.T) {
+	errDuplicate := errors.New("duplicate key")
+	conn := &batchConn{
+		results: []StatementResult{
+			{CommandTag: pgconn.NewCommandTag("INSERT 0 1")},
+			{Err: errDuplicate},
+		},
+	}
+
+	b := &Batch{}
+	b.Queue("INSERT INTO users (id) VALUES ($1)", 1)
+	b.Queue("INSERT INTO users (id) VALUES ($1)", 1)
+
+	result, err := SendBatch(context.Background(), conn, b)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+
+	assert.Equal(t, int64(1), result[0].CommandTag.RowsAffected())
+	assert.NoError(t, result[0].Err)
+	assert.ErrorIs(t, result[1].Err, errDuplicate)
+	assert.ErrorIs(t, result.Err(), errDuplicate)
+}
+
+func TestSendBatch_Empty(t *testing.T) {
+	result, err := SendBatch(context.Background(), &batchConn{}, &Batch{})
+	require.NoError(t, err)
+	assert.Empty(t, result)
+	assert.NoError(t, result.Err())
+}
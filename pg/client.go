@@ -22,10 +22,13 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
-	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/tracelog"
@@ -51,7 +54,16 @@ type (
 		password string
 		database string
 
-		poolSize int32
+		applicationName string
+		copyTables      map[string]struct{}
+
+		poolSize       int32
+		acquireTimeout time.Duration
+
+		queryExecMode          pgx.QueryExecMode
+		disableStatementCaches bool
+
+		afterConnect func(context.Context, *pgx.Conn) error
 
 		tlsConfig *tls.Config
 
@@ -61,6 +73,8 @@ type (
 		tracer         trace.Tracer
 		logger         *log.Logger
 		registerer     prometheus.Registerer
+
+		poolExhaustedTotal prometheus.Counter
 	}
 
 	ExecFunc func(Conn) error
@@ -70,6 +84,8 @@ type (
 
 const (
 	BaseAdvisoryLockId uint32 = 42
+
+	rollbackTimeout = 5 * time.Second
 )
 
 // WithLogger sets a custom logger.
@@ -107,6 +123,31 @@ func WithDatabase(database string) Option {
 	}
 }
 
+// WithApplicationName sets the application_name of the connections,
+// identifying the service in pg_stat_activity and in the server logs.
+// It defaults to the base name of the running binary; an empty name
+// leaves it unset.
+func WithApplicationName(name string) Option {
+	return func(c *Client) {
+		c.applicationName = name
+	}
+}
+
+// WithCopyTables lists the tables whose COPY FROM operations are
+// labeled with their name in the pg_copy_rows_total and
+// pg_copy_duration_seconds metrics, keeping their cardinality bounded.
+// The tables are named as given to CopyFrom, with the schema if any,
+// e.g. "events" or "audit.events". The operations on the other tables
+// are labeled "other".
+func WithCopyTables(tables ...string) Option {
+	return func(c *Client) {
+		c.copyTables = make(map[string]struct{}, len(tables))
+		for _, table := range tables {
+			c.copyTables[table] = struct{}{}
+		}
+	}
+}
+
 // WithTLS configures TLS using the provided certificate for secure
 // connections.
 func WithTLS(cert *x509.Certificate) Option {
@@ -127,6 +168,59 @@ func WithPoolSize(i int32) Option {
 	}
 }
 
+// WithAcquireTimeout bounds the time WithConn and WithTx wait for a
+// connection from the pool, regardless of the caller context. When the
+// pool stays exhausted for longer, they return an error matching
+// ErrPoolExhausted, so callers can shed load instead of hanging.
+func WithAcquireTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.acquireTimeout = d
+	}
+}
+
+// WithQueryExecMode sets the protocol mode used to execute queries,
+// which defaults to pgx.QueryExecModeCacheStatement.
+func WithQueryExecMode(mode pgx.QueryExecMode) Option {
+	return func(c *Client) {
+		c.queryExecMode = mode
+	}
+}
+
+// WithPgBouncerCompat configures the client to run behind PgBouncer in
+// transaction pooling mode, where prepared statements cannot be used
+// as consecutive queries may run on different server connections. It
+// executes queries with the simple protocol and disables the statement
+// and description caches.
+func WithPgBouncerCompat() Option {
+	return func(c *Client) {
+		c.queryExecMode = pgx.QueryExecModeSimpleProtocol
+		c.disableStatementCaches = true
+	}
+}
+
+// WithAfterConnect sets a function called on each new physical
+// connection before it is added to the pool, to set session parameters
+// or register custom types. An error fails the connection creation.
+//
+// Example:
+//
+//	pg.WithAfterConnect(
+//	    func(ctx context.Context, conn *pgx.Conn) error {
+//	        t, err := conn.LoadType(ctx, "order_status")
+//	        if err != nil {
+//	            return err
+//	        }
+//
+//	        conn.TypeMap().RegisterType(t)
+//	        return nil
+//	    },
+//	)
+func WithAfterConnect(f func(ctx context.Context, conn *pgx.Conn) error) Option {
+	return func(c *Client) {
+		c.afterConnect = f
+	}
+}
+
 // WithTracerProvider configures OpenTelemetry tracing with the
 // provided tracer provider.
 func WithTracerProvider(tp trace.TracerProvider) Option {
@@ -157,13 +251,15 @@ func WithRegisterer(r prometheus.Registerer) Option {
 //	}
 func NewClient(options ...Option) (*Client, error) {
 	c := &Client{
-		addr:           "localhost:5432",
-		user:           "postgres",
-		database:       "postgres",
-		poolSize:       10,
-		logger:         log.NewLogger(log.WithOutput(io.Discard)),
-		tracerProvider: otel.GetTracerProvider(),
-		registerer:     prometheus.DefaultRegisterer,
+		addr:            "localhost:5432",
+		user:            "postgres",
+		database:        "postgres",
+		applicationName: filepath.Base(os.Args[0]),
+		poolSize:        10,
+		queryExecMode:   pgx.QueryExecModeCacheStatement,
+		logger:          log.NewNopLogger(),
+		tracerProvider:  otel.GetTracerProvider(),
+		registerer:      prometheus.DefaultRegisterer,
 	}
 
 	for _, o := range options {
@@ -187,8 +283,27 @@ func NewClient(options ...Option) (*Client, error) {
 	config.ConnConfig.Config.Password = c.password
 	config.ConnConfig.Config.Database = c.database
 	config.ConnConfig.Config.TLSConfig = c.tlsConfig
+	if c.applicationName != "" {
+		config.ConnConfig.Config.RuntimeParams["application_name"] = c.applicationName
+	}
 	config.MinConns = 1
 	config.MaxConns = int32(c.poolSize)
+	config.ConnConfig.DefaultQueryExecMode = c.queryExecMode
+
+	if c.disableStatementCaches {
+		config.ConnConfig.StatementCacheCapacity = 0
+		config.ConnConfig.DescriptionCacheCapacity = 0
+	}
+
+	if c.afterConnect != nil {
+		config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			if err := c.afterConnect(ctx, conn); err != nil {
+				return fmt.Errorf("cannot run after connect hook: %w", err)
+			}
+
+			return nil
+		}
+	}
 
 	c.tracer = c.tracerProvider.Tracer(
 		tracerName,
@@ -197,8 +312,17 @@ func NewClient(options ...Option) (*Client, error) {
 		),
 	)
 
+	labels := map[string]string{
+		"database": c.database,
+		"user":     c.user,
+		"addr":     c.addr,
+	}
+
+	pgxTracer := newTracer(c.tracer, c.registerer, labels)
+	pgxTracer.copyTables = c.copyTables
+
 	config.ConnConfig.Tracer = multitracer.New(
-		&tracer{c.tracer},
+		pgxTracer,
 		&tracelog.TraceLog{
 			Logger:   &logger{c.logger}, // TODO not enable tracelog by default
 			LogLevel: tracelog.LogLevelInfo,
@@ -210,16 +334,17 @@ func NewClient(options ...Option) (*Client, error) {
 		return nil, fmt.Errorf("cannot create connection pool from config: %w", err)
 	}
 
-	c.registerer.MustRegister(
-		newCollector(
-			pool,
-			map[string]string{
-				"database": c.database,
-				"user":     c.user,
-				"addr":     c.addr,
-			},
-		),
+	c.registerer.MustRegister(newCollector(pool, labels))
+
+	c.poolExhaustedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem:   "pg",
+			Name:        "pool_exhausted_total",
+			Help:        "Total number of connection acquires which timed out because the pool was exhausted.",
+			ConstLabels: labels,
+		},
 	)
+	c.registerer.MustRegister(c.poolExhaustedTotal)
 
 	c.pool = pool
 
@@ -261,7 +386,7 @@ func (c *Client) WithConn(
 		defer span.End()
 	}
 
-	conn, err := c.pool.Acquire(ctx)
+	conn, err := c.acquire(ctx)
 	if err != nil {
 		err := fmt.Errorf("cannot acquire connection: %w", err)
 		if rootSpan.IsRecording() {
@@ -283,10 +408,30 @@ func (c *Client) WithConn(
 	return nil
 }
 
+// acquire acquires a connection from the pool, waiting at most for the
+// acquire timeout when one is set.
+func (c *Client) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	if c.acquireTimeout <= 0 {
+		return c.pool.Acquire(ctx)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, c.acquireTimeout)
+	defer cancel()
+
+	conn, err := c.pool.Acquire(acquireCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(acquireCtx.Err(), context.DeadlineExceeded) {
+		c.poolExhaustedTotal.Inc()
+		return nil, &poolExhaustedError{err}
+	}
+
+	return conn, err
+}
+
 // WithTx executes the given ExecFunc within a transaction. This
 // method begins a transaction, executing `exec` within it. If `exec`
 // returns an error, the transaction is rolled back; otherwise, it
-// commits.
+// commits. The rollback is not cancelled along with ctx, so it reaches
+// the server even when ctx is done, but is bounded to a few seconds.
 //
 // Example:
 //
@@ -317,7 +462,7 @@ func (c *Client) WithTx(
 		defer span.End()
 	}
 
-	conn, err := c.pool.Acquire(ctx)
+	conn, err := c.acquire(ctx)
 	if err != nil {
 		err := fmt.Errorf("cannot acquire connection: %w", err)
 		if rootSpan.IsRecording() {
@@ -339,7 +484,15 @@ func (c *Client) WithTx(
 	}
 
 	if err := exec(tx); err != nil {
-		if err2 := tx.Rollback(ctx); err2 != nil {
+		// The rollback must reach the server even when ctx is done,
+		// which is a common reason for exec to fail.
+		rollbackCtx, cancel := context.WithTimeout(
+			context.WithoutCancel(ctx),
+			rollbackTimeout,
+		)
+		defer cancel()
+
+		if err2 := tx.Rollback(rollbackCtx); err2 != nil {
 			err = errors.Join(
 				err,
 				fmt.Errorf("cannot rollback transaction: %w", err2),
@@ -365,6 +518,105 @@ func (c *Client) WithTx(
 	return nil
 }
 
+// WithSavepoint executes the given ExecFunc within a savepoint of the
+// transaction tx, which must be a Conn passed by WithTx or by another
+// WithSavepoint, or a Tx returned by Begin. If `exec` returns an
+// error, the transaction is rolled back to the savepoint, leaving the
+// outer transaction usable; otherwise, the savepoint is released.
+// Savepoints can be nested.
+//
+// Example:
+//
+//	err := client.WithTx(ctx, func(tx pg.Conn) error {
+//	    // The audit log is best effort, failing to insert it must
+//	    // not abort the deletion.
+//	    _ = client.WithSavepoint(ctx, tx, func(sp pg.Conn) error {
+//	        _, err := sp.Exec(ctx, "INSERT INTO audit_logs (id) VALUES ($1)", id)
+//	        return err
+//	    })
+//
+//	    _, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", id)
+//	    return err
+//	})
+//
+// If tracing is enabled, this method creates a span named
+// "db.savepoint" and logs any errors.
+func (c *Client) WithSavepoint(
+	ctx context.Context,
+	tx Conn,
+	exec ExecFunc,
+) error {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"db.savepoint",
+			trace.WithSpanKind(trace.SpanKindClient),
+		)
+		defer span.End()
+	}
+
+	if t, ok := tx.(*Tx); ok {
+		tx = t.tx
+	}
+
+	parent, ok := tx.(pgx.Tx)
+	if !ok {
+		err := fmt.Errorf("cannot create savepoint: %T is not a transaction", tx)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+		}
+
+		return err
+	}
+
+	savepoint, err := parent.Begin(ctx)
+	if err != nil {
+		err := fmt.Errorf("cannot create savepoint: %w", err)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+		}
+
+		return err
+	}
+
+	if err := exec(savepoint); err != nil {
+		rollbackCtx, cancel := context.WithTimeout(
+			context.WithoutCancel(ctx),
+			rollbackTimeout,
+		)
+		defer cancel()
+
+		if err2 := savepoint.Rollback(rollbackCtx); err2 != nil {
+			err = errors.Join(
+				err,
+				fmt.Errorf("cannot rollback to savepoint: %w", err2),
+			)
+		}
+
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+		}
+
+		return err
+	}
+
+	if err := savepoint.Commit(ctx); err != nil {
+		err := fmt.Errorf("cannot release savepoint: %w", err)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
 func (c *Client) WithAdvisoryLock(
 	ctx context.Context,
 	id AdvisoryLock,
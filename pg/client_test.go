@@ -0,0 +1,340 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a client using its own metrics registry,
+// closed when the test ends.
+func newTestClient(t *testing.T, options ...Option) *Client {
+	t.Helper()
+
+	c, err := NewClient(
+		append(
+			[]Option{WithRegisterer(prometheus.NewRegistry())},
+			options...,
+		)...,
+	)
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func TestNewClient_QueryExecMode(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		config := newTestClient(t).pool.Config().ConnConfig
+
+		assert.Equal(t, pgx.QueryExecModeCacheStatement, config.DefaultQueryExecMode)
+		assert.NotZero(t, config.StatementCacheCapacity)
+	})
+
+	t.Run("custom mode", func(t *testing.T) {
+		config := newTestClient(t, WithQueryExecMode(pgx.QueryExecModeExec)).pool.Config().ConnConfig
+
+		assert.Equal(t, pgx.QueryExecModeExec, config.DefaultQueryExecMode)
+	})
+
+	t.Run("pgbouncer", func(t *testing.T) {
+		config := newTestClient(t, WithPgBouncerCompat()).pool.Config().ConnConfig
+
+		assert.Equal(t, pgx.QueryExecModeSimpleProtocol, config.DefaultQueryExecMode)
+		assert.Zero(t, config.StatementCacheCapacity)
+		assert.Zero(t, config.DescriptionCacheCapacity)
+	})
+}
+
+func TestNewClient_ApplicationName(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		params := newTestClient(t).pool.Config().ConnConfig.RuntimeParams
+
+		assert.Equal(t, filepath.Base(os.Args[0]), params["application_name"])
+	})
+
+	t.Run("custom name", func(t *testing.T) {
+		params := newTestClient(t, WithApplicationName("billing-api")).pool.Config().ConnConfig.RuntimeParams
+
+		assert.Equal(t, "billing-api", params["application_name"])
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		params := newTestClient(t, WithApplicationName("")).pool.Config().ConnConfig.RuntimeParams
+
+		assert.NotContains(t, params, "application_name")
+	})
+}
+
+func TestWithAcquireTimeout(t *testing.T) {
+	// The server accepts connections but does not answer before the
+	// acquire timeout, so no connection can be established in time.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			time.AfterFunc(500*time.Millisecond, func() { conn.Close() })
+		}
+	}()
+
+	c, err := NewClient(
+		WithAddr(ln.Addr().String()),
+		WithRegisterer(prometheus.NewRegistry()),
+		WithAcquireTimeout(50*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.WithConn(context.Background(), func(Conn) error { return nil })
+	assert.ErrorIs(t, err, ErrPoolExhausted)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.poolExhaustedTotal))
+
+	// The caller context expiring first is not a pool exhaustion.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = c.WithTx(ctx, func(Conn) error { return nil })
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPoolExhausted)
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.poolExhaustedTotal))
+}
+
+func TestWithAfterConnect(t *testing.T) {
+	errHook := errors.New("boom")
+
+	c, err := NewClient(
+		WithRegisterer(prometheus.NewRegistry()),
+		WithAfterConnect(
+			func(ctx context.Context, conn *pgx.Conn) error {
+				return errHook
+			},
+		),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	afterConnect := c.pool.Config().AfterConnect
+	require.NotNil(t, afterConnect)
+	assert.ErrorIs(t, afterConnect(context.Background(), nil), errHook)
+}
+
+// newFakeServer starts a server speaking enough of the PostgreSQL
+// protocol to run simple queries, each of them succeeding. The
+// received queries are sent to the returned channel.
+func newFakeServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	queries := make(chan string, 100)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		backend := pgproto3.NewBackend(conn, conn)
+
+		for {
+			msg, err := backend.ReceiveStartupMessage()
+			if err != nil {
+				return
+			}
+
+			if _, ok := msg.(*pgproto3.SSLRequest); ok {
+				if _, err := conn.Write([]byte("N")); err != nil {
+					return
+				}
+				continue
+			}
+
+			break
+		}
+
+		txStatus := byte('I')
+
+		backend.Send(&pgproto3.AuthenticationOk{})
+		backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+		backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+		backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+		if err := backend.Flush(); err != nil {
+			return
+		}
+
+		for {
+			msg, err := backend.Receive()
+			if err != nil {
+				return
+			}
+
+			switch msg := msg.(type) {
+			case *pgproto3.Query:
+				queries <- msg.String
+
+				switch strings.ToLower(msg.String) {
+				case "begin":
+					txStatus = 'T'
+				case "commit", "rollback":
+					txStatus = 'I'
+				}
+
+				backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(strings.ToUpper(msg.String))})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+				if err := backend.Flush(); err != nil {
+					return
+				}
+			case *pgproto3.Terminate:
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn)
+		}
+	}()
+
+	return ln.Addr().String(), queries
+}
+
+func TestWithTx_RollbackOnCancel(t *testing.T) {
+	addr, queries := newFakeServer(t)
+
+	c, err := NewClient(
+		WithAddr(addr),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = c.WithTx(
+		ctx,
+		func(Conn) error {
+			cancel()
+			return ctx.Err()
+		},
+	)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotContains(t, err.Error(), "cannot rollback transaction")
+
+	var received []string
+	for len(queries) > 0 {
+		received = append(received, <-queries)
+	}
+	assert.Equal(t, []string{"begin", "rollback"}, received)
+
+	// The connection went back to the pool idle, rather than being
+	// closed in the middle of the transaction.
+	stat := c.pool.Stat()
+	assert.Equal(t, stat.TotalConns(), stat.IdleConns())
+	assert.NotZero(t, stat.IdleConns())
+}
+
+func TestWithSavepoint(t *testing.T) {
+	addr, queries := newFakeServer(t)
+
+	c, err := NewClient(
+		WithAddr(addr),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	var (
+		ctx      = context.Background()
+		errInner = errors.New("inner failure")
+	)
+
+	err = c.WithTx(
+		ctx,
+		func(tx Conn) error {
+			err := c.WithSavepoint(
+				ctx,
+				tx,
+				func(sp Conn) error {
+					return c.WithSavepoint(ctx, sp, func(Conn) error { return nil })
+				},
+			)
+			require.NoError(t, err)
+
+			err = c.WithSavepoint(ctx, tx, func(Conn) error { return errInner })
+			assert.ErrorIs(t, err, errInner)
+
+			return nil
+		},
+	)
+	require.NoError(t, err)
+
+	var received []string
+	for len(queries) > 0 {
+		received = append(received, <-queries)
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"begin",
+			"savepoint sp_1",
+			"savepoint sp_2",
+			"release savepoint sp_2",
+			"release savepoint sp_1",
+			"savepoint sp_3",
+			"rollback to savepoint sp_3",
+			"commit",
+		},
+		received,
+	)
+
+	err = c.WithConn(
+		ctx,
+		func(conn Conn) error {
+			return c.WithSavepoint(ctx, conn, func(Conn) error { return nil })
+		},
+	)
+	assert.ErrorContains(t, err, "cannot create savepoint")
+}
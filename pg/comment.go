@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// taggedConn prepends a sqlcommenter comment to the queries sent
+	// through the wrapped connection.
+	taggedConn struct {
+		conn        Conn
+		tags        map[string]string
+		formatted   string
+		traceparent bool
+	}
+)
+
+var (
+	_ Conn = (*taggedConn)(nil)
+)
+
+// WithConnTagged executes the given ExecFunc like WithConn, prefixing
+// each query sent through the connection with a sqlcommenter style
+// comment holding the given tags, e.g.
+// "/*action='list',controller='users'*/ SELECT ...", so queries found
+// in pg_stat_statements or in the server logs can be traced back to
+// the code issuing them.
+//
+// Statements are prepared and cached by their SQL text, comment
+// included: tags must come from a bounded set of values, such as the
+// route or the name of a job, not from user input or identifiers. For
+// the same reason, the traceparent of the span active when the query
+// is sent is only added when the client does not cache statements,
+// that is when it uses the pgx.QueryExecModeExec or
+// pgx.QueryExecModeSimpleProtocol execution mode.
+//
+// Example:
+//
+//	err := client.WithConnTagged(
+//	    ctx,
+//	    map[string]string{"controller": "users", "action": "list"},
+//	    func(conn pg.Conn) error {
+//	        _, err := conn.Exec(ctx, "SELECT * FROM users")
+//	        return err
+//	    },
+//	)
+func (c *Client) WithConnTagged(
+	ctx context.Context,
+	tags map[string]string,
+	exec ExecFunc,
+) error {
+	traceparent := c.queryExecMode == pgx.QueryExecModeExec ||
+		c.queryExecMode == pgx.QueryExecModeSimpleProtocol
+
+	return c.WithConn(
+		ctx,
+		func(conn Conn) error {
+			return exec(newTaggedConn(conn, tags, traceparent))
+		},
+	)
+}
+
+func newTaggedConn(conn Conn, tags map[string]string, traceparent bool) *taggedConn {
+	return &taggedConn{
+		conn:        conn,
+		tags:        tags,
+		formatted:   formatTags(tags),
+		traceparent: traceparent,
+	}
+}
+
+// formatTags formats tags as sqlcommenter key value pairs, sorted by
+// key, with URL encoded keys and values.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, commentEscape(k)+"='"+commentEscape(tags[k])+"'")
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// commentEscape URL encodes s, which cannot then hold a quote or the
+// end of the comment.
+func commentEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func (c *taggedConn) comment(ctx context.Context) string {
+	tags := c.formatted
+
+	if c.traceparent {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			t := maps.Clone(c.tags)
+			if t == nil {
+				t = make(map[string]string, 1)
+			}
+			t["traceparent"] = "00-" + sc.TraceID().String() + "-" +
+				sc.SpanID().String() + "-" + sc.TraceFlags().String()
+
+			tags = formatTags(t)
+		}
+	}
+
+	if tags == "" {
+		return ""
+	}
+
+	return "/*" + tags + "*/ "
+}
+
+func (c *taggedConn) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return c.conn.Exec(ctx, c.comment(ctx)+sql, args...)
+}
+
+func (c *taggedConn) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return c.conn.Query(ctx, c.comment(ctx)+sql, args...)
+}
+
+func (c *taggedConn) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return c.conn.QueryRow(ctx, c.comment(ctx)+sql, args...)
+}
+
+func (c *taggedConn) CopyFrom(
+	ctx context.Context,
+	table pgx.Identifier,
+	columns []string,
+	src pgx.CopyFromSource,
+) (int64, error) {
+	return c.conn.CopyFrom(ctx, table, columns, src)
+}
+
+// SendBatch sends a copy of b whose queries are prefixed with the
+// comment, leaving b untouched so it can be sent again.
+func (c *taggedConn) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	comment := c.comment(ctx)
+	if comment == "" {
+		return c.conn.SendBatch(ctx, b)
+	}
+
+	b2 := &pgx.Batch{QueuedQueries: make([]*pgx.QueuedQuery, len(b.QueuedQueries))}
+	for i, q := range b.QueuedQueries {
+		q2 := *q
+		q2.SQL = comment + q.SQL
+		b2.QueuedQueries[i] = &q2
+	}
+
+	return c.conn.SendBatch(ctx, b2)
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type recordingConn struct {
+	Conn
+	queries []string
+}
+
+func (c *recordingConn) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	c.queries = append(c.queries, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (c *recordingConn) SendBatch(_ context.Context, b *pgx.Batch) pgx.BatchResults {
+	for _, q := range b.QueuedQueries {
+		c.queries = append(c.queries, q.SQL)
+	}
+
+	return nil
+}
+
+func testSpanContext(t *testing.T) context.Context {
+	t.Helper()
+
+	traceID, err := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("b7ad6b7169203331")
+	require.NoError(t, err)
+
+	return trace.ContextWithSpanContext(
+		context.Background(),
+		trace.NewSpanContext(
+			trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+			},
+		),
+	)
+}
+
+func TestFormatTags(t *testing.T) {
+	assert.Equal(t, "", formatTags(nil))
+	assert.Equal(
+		t,
+		"action='list',controller='users'",
+		formatTags(map[string]string{"controller": "users", "action": "list"}),
+	)
+	assert.Equal(
+		t,
+		"route='%2Fusers%2F%7Bid%7D%20%27x%27%2A%2F'",
+		formatTags(map[string]string{"route": "/users/{id} 'x'*/"}),
+	)
+}
+
+func TestTaggedConn(t *testing.T) {
+	ctx := testSpanContext(t)
+	tags := map[string]string{"controller": "users", "action": "list"}
+
+	t.Run("cached statements", func(t *testing.T) {
+		rec := &recordingConn{}
+		conn := newTaggedConn(rec, tags, false)
+
+		_, err := conn.Exec(ctx, "SELECT 1")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"/*action='list',controller='users'*/ SELECT 1"}, rec.queries)
+	})
+
+	t.Run("traceparent", func(t *testing.T) {
+		rec := &recordingConn{}
+		conn := newTaggedConn(rec, tags, true)
+
+		_, err := conn.Exec(ctx, "SELECT 1")
+		require.NoError(t, err)
+		_, err = conn.Exec(context.Background(), "SELECT 2")
+		require.NoError(t, err)
+
+		assert.Equal(
+			t,
+			[]string{
+				"/*action='list',controller='users',traceparent='00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01'*/ SELECT 1",
+				"/*action='list',controller='users'*/ SELECT 2",
+			},
+			rec.queries,
+		)
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		rec := &recordingConn{}
+		conn := newTaggedConn(rec, nil, false)
+
+		_, err := conn.Exec(ctx, "SELECT 1")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"SELECT 1"}, rec.queries)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		rec := &recordingConn{}
+		conn := newTaggedConn(rec, map[string]string{"job": "sync"}, false)
+
+		b := &pgx.Batch{}
+		b.Queue("SELECT 1")
+		b.Queue("SELECT 2")
+
+		conn.SendBatch(ctx, b)
+
+		assert.Equal(t, []string{"/*job='sync'*/ SELECT 1", "/*job='sync'*/ SELECT 2"}, rec.queries)
+		assert.Equal(t, "SELECT 1", b.QueuedQueries[0].SQL)
+	})
+}
+
+func TestWithConnTagged(t *testing.T) {
+	addr, queries := newFakeServer(t)
+
+	c, err := NewClient(
+		WithAddr(addr),
+		WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := testSpanContext(t)
+
+	err = c.WithConnTagged(
+		ctx,
+		map[string]string{"controller": "users"},
+		func(conn Conn) error {
+			_, err := conn.Exec(ctx, "SELECT 1")
+			return err
+		},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		"/*controller='users',traceparent='00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01'*/ SELECT 1",
+		<-queries,
+	)
+}
+
+func TestWithConnTaggedOperation(t *testing.T) {
+	addr, queries := newFakeServer(t)
+	registry := prometheus.NewRegistry()
+
+	c, err := NewClient(
+		WithAddr(addr),
+		WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+		WithRegisterer(registry),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := testSpanContext(t)
+
+	err = c.WithConnTagged(
+		ctx,
+		map[string]string{"controller": "users"},
+		func(conn Conn) error {
+			_, err := conn.Exec(ctx, "SELECT 1")
+			return err
+		},
+	)
+	require.NoError(t, err)
+	<-queries
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var operations []string
+	for _, family := range families {
+		if family.GetName() != "pg_queries_total" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "operation" {
+					operations = append(operations, label.GetValue())
+				}
+			}
+		}
+	}
+
+	assert.Equal(t, []string{"SELECT"}, operations)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type (
+	noRowsError struct {
+		err error
+	}
+
+	poolExhaustedError struct {
+		err error
+	}
+)
+
+var (
+	// ErrNoRows is returned when a query expected to return a row
+	// does not return any. It replaces pgx.ErrNoRows so application
+	// code can check for it with errors.Is without depending on the
+	// driver. The driver error stays wrapped, and any other error,
+	// such as a *pgconn.PgError, is returned as is.
+	ErrNoRows = errors.New("no rows in result set")
+
+	// ErrPoolExhausted is returned when no connection could be
+	// acquired from the pool within the timeout set with
+	// WithAcquireTimeout. The acquire error, wrapping
+	// context.DeadlineExceeded, stays wrapped.
+	ErrPoolExhausted = errors.New("connection pool exhausted")
+)
+
+// ScanRow scans row into dest like pgx.Row.Scan, returning ErrNoRows
+// when the query did not return any row.
+//
+// Example:
+//
+//	var email string
+//	err := pg.ScanRow(conn.QueryRow(ctx, "SELECT email FROM users WHERE id = $1", id), &email)
+//	if errors.Is(err, pg.ErrNoRows) {
+//	    return nil, ErrUserNotFound
+//	}
+func ScanRow(row pgx.Row, dest ...any) error {
+	return translateError(row.Scan(dest...))
+}
+
+// IsUniqueViolation reports whether err is, or wraps, a
+// unique_violation (23505) error returned by the server, as when an
+// insert conflicts with an existing row.
+//
+// Example:
+//
+//	_, err := conn.Exec(ctx, "INSERT INTO users (email) VALUES ($1)", email)
+//	if pg.IsUniqueViolation(err) && pg.ConstraintName(err) == "users_email_key" {
+//	    return ErrEmailTaken
+//	}
+func IsUniqueViolation(err error) bool {
+	return hasSQLState(err, "23505")
+}
+
+// IsForeignKeyViolation reports whether err is, or wraps, a
+// foreign_key_violation (23503) error returned by the server.
+func IsForeignKeyViolation(err error) bool {
+	return hasSQLState(err, "23503")
+}
+
+// IsCheckViolation reports whether err is, or wraps, a
+// check_violation (23514) error returned by the server.
+func IsCheckViolation(err error) bool {
+	return hasSQLState(err, "23514")
+}
+
+// IsSerializationFailure reports whether err is, or wraps, a
+// serialization_failure (40001) error returned by the server, in which
+// case the transaction can be retried.
+func IsSerializationFailure(err error) bool {
+	return hasSQLState(err, "40001")
+}
+
+// ConstraintName returns the name of the constraint violated by err,
+// or an empty string when err does not wrap a server error or the
+// error is not about a constraint.
+func ConstraintName(err error) string {
+	if pgErr := asPgError(err); pgErr != nil {
+		return pgErr.ConstraintName
+	}
+
+	return ""
+}
+
+func hasSQLState(err error, code string) bool {
+	pgErr := asPgError(err)
+	return pgErr != nil && pgErr.Code == code
+}
+
+func asPgError(err error) *pgconn.PgError {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr
+	}
+
+	return nil
+}
+
+func translateError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &noRowsError{err}
+	}
+
+	return err
+}
+
+func (e *noRowsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *noRowsError) Is(target error) bool {
+	return target == ErrNoRows
+}
+
+func (e *noRowsError) Unwrap() error {
+	return e.err
+}
+
+func (e *poolExhaustedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPoolExhausted, e.err)
+}
+
+func (e *poolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+func (e *poolExhaustedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+type row struct {
+	err error
+}
+
+func (r row) Scan(...any) error {
+	return r.err
+}
+
+func TestScanRow(t *testing.T) {
+	t.Run("no rows", func(t *testing.T) {
+		err := ScanRow(row{pgx.ErrNoRows})
+
+		assert.ErrorIs(t, err, ErrNoRows)
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	t.Run("postgres error", func(t *testing.T) {
+		err := ScanRow(row{&pgconn.PgError{Code: "23505"}})
+
+		var pgErr *pgconn.PgError
+		assert.False(t, errors.Is(err, ErrNoRows))
+		if assert.ErrorAs(t, err, &pgErr) {
+			assert.Equal(t, "23505", pgErr.Code)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		assert.NoError(t, ScanRow(row{}))
+	})
+}
+
+func TestErrorHelpers(t *testing.T) {
+	unique := fmt.Errorf(
+		"cannot insert user: %w",
+		&pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"},
+	)
+
+	assert.True(t, IsUniqueViolation(unique))
+	assert.False(t, IsForeignKeyViolation(unique))
+	assert.False(t, IsCheckViolation(unique))
+	assert.False(t, IsSerializationFailure(unique))
+	assert.Equal(t, "users_email_key", ConstraintName(unique))
+
+	assert.True(t, IsForeignKeyViolation(&pgconn.PgError{Code: "23503"}))
+	assert.True(t, IsCheckViolation(&pgconn.PgError{Code: "23514"}))
+	assert.True(t, IsSerializationFailure(&pgconn.PgError{Code: "40001"}))
+
+	other := errors.New("boom")
+	assert.False(t, IsUniqueViolation(other))
+	assert.False(t, IsUniqueViolation(nil))
+	assert.Empty(t, ConstraintName(other))
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.gearno.de/kit/log"
+)
+
+type (
+	// InvalidatorOption configures the Invalidator during
+	// initialization.
+	InvalidatorOption func(i *Invalidator)
+
+	// Invalidator broadcasts cache invalidations between the
+	// instances of a service with LISTEN and NOTIFY: Notify sends a
+	// key on the channel and every instance running Run calls its
+	// subscribers with it, so they evict the key from their local
+	// cache.
+	//
+	// Notifications received in a burst are deduplicated: the keys
+	// received within the dedupe window are collected and each one
+	// is passed once to the subscribers at the end of the window.
+	//
+	// Run reconnects when the connection is lost. The notifications
+	// sent while it is not listening are lost, so the subscribers
+	// registered with SubscribeReconnect are called once it listens
+	// again, to drop the entries which may have become stale.
+	Invalidator struct {
+		client  *Client
+		channel string
+		logger  *log.Logger
+
+		dedupeWindow      time.Duration
+		reconnectDelay    time.Duration
+		maxReconnectDelay time.Duration
+
+		mu                   sync.RWMutex
+		subscribers          []func(key string)
+		reconnectSubscribers []func()
+	}
+)
+
+// WithInvalidatorDedupeWindow sets the window within which the keys
+// received more than once are passed once to the subscribers. It
+// defaults to 100 milliseconds; the keys are passed as soon as they
+// are received when d is zero.
+func WithInvalidatorDedupeWindow(d time.Duration) InvalidatorOption {
+	return func(i *Invalidator) {
+		i.dedupeWindow = d
+	}
+}
+
+// NewInvalidator returns an invalidator sending and receiving the
+// keys on the given channel.
+//
+// Example:
+//
+//	invalidator := pg.NewInvalidator(client, "users_cache")
+//	invalidator.Subscribe(func(key string) {
+//	    cache.Delete(key)
+//	})
+//
+//	go invalidator.Run(ctx)
+//
+//	// After a user is updated, on any instance:
+//	err := invalidator.Notify(ctx, userID)
+func NewInvalidator(c *Client, channel string, options ...InvalidatorOption) *Invalidator {
+	i := &Invalidator{
+		client:            c,
+		channel:           channel,
+		logger:            c.logger.With(log.String("channel", channel)),
+		dedupeWindow:      100 * time.Millisecond,
+		reconnectDelay:    100 * time.Millisecond,
+		maxReconnectDelay: 30 * time.Second,
+	}
+
+	for _, o := range options {
+		o(i)
+	}
+
+	return i
+}
+
+// Subscribe registers f to be called with every key received. The
+// subscribers are called sequentially from a goroutine started by
+// Run, concurrently with the reconnect subscribers, and must not
+// block. They may register other subscribers.
+func (i *Invalidator) Subscribe(f func(key string)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.subscribers = append(i.subscribers, f)
+}
+
+// SubscribeReconnect registers f to be called each time Run listens
+// again after losing its connection, the keys notified in the
+// meantime being lost. The reconnect subscribers are called
+// sequentially from the goroutine running Run.
+func (i *Invalidator) SubscribeReconnect(f func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.reconnectSubscribers = append(i.reconnectSubscribers, f)
+}
+
+// Notify sends key to the invalidators listening to the channel,
+// including the one of this instance, with pg_notify. The key must be
+// shorter than 8000 bytes, the maximum payload size of PostgreSQL.
+func (i *Invalidator) Notify(ctx context.Context, key string) error {
+	return i.client.WithConn(
+		ctx,
+		func(conn Conn) error {
+			if _, err := conn.Exec(ctx, "SELECT pg_notify($1, $2)", i.channel, key); err != nil {
+				return fmt.Errorf("cannot notify %q channel: %w", i.channel, err)
+			}
+
+			return nil
+		},
+	)
+}
+
+// Run listens to the channel and calls the subscribers with the keys
+// received until ctx is done, reconnecting with an exponential
+// backoff when the connection is lost. It always returns nil once ctx
+// is done.
+func (i *Invalidator) Run(ctx context.Context) error {
+	var (
+		keys = make(chan string)
+		done = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+		i.dispatch(keys)
+	}()
+
+	defer func() {
+		close(keys)
+		<-done
+	}()
+
+	var (
+		delay     = i.reconnectDelay
+		listening = false
+	)
+
+	for {
+		err := i.listen(
+			ctx,
+			keys,
+			func() {
+				if listening {
+					i.logger.InfoCtx(ctx, "invalidator listening again")
+					i.reconnected()
+				}
+
+				listening = true
+				delay = i.reconnectDelay
+			},
+		)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		i.logger.WarnCtx(
+			ctx,
+			"invalidator connection lost, reconnecting",
+			log.Duration("retry_in", delay),
+			log.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay = min(2*delay, i.maxReconnectDelay)
+	}
+}
+
+// listen sends the keys received on a dedicated connection to keys
+// until ctx is done or the connection fails, calling listening once
+// the subscription is active.
+func (i *Invalidator) listen(ctx context.Context, keys chan<- string, listening func()) error {
+	pooledConn, err := i.client.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot acquire connection: %w", err)
+	}
+
+	// As for ListenJSON, the connection is hijacked so the
+	// subscription does not outlive the call on a pooled connection.
+	conn := pooledConn.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	q := "LISTEN " + pgx.Identifier{i.channel}.Sanitize()
+	if _, err := conn.Exec(ctx, q); err != nil {
+		return fmt.Errorf("cannot listen to %q channel: %w", i.channel, err)
+	}
+
+	listening()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot wait for notification: %w", err)
+		}
+
+		select {
+		case keys <- n.Payload:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// dispatch passes the keys received from keys to the subscribers,
+// once per dedupe window, until keys is closed.
+func (i *Invalidator) dispatch(keys <-chan string) {
+	var (
+		pending = make(map[string]struct{})
+		order   []string
+		flush   <-chan time.Time
+	)
+
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				i.invalidate(order)
+				return
+			}
+
+			if i.dedupeWindow <= 0 {
+				i.invalidate([]string{key})
+				continue
+			}
+
+			if _, ok := pending[key]; ok {
+				continue
+			}
+
+			if len(pending) == 0 {
+				flush = time.After(i.dedupeWindow)
+			}
+
+			pending[key] = struct{}{}
+			order = append(order, key)
+		case <-flush:
+			i.invalidate(order)
+
+			clear(pending)
+			order = nil
+			flush = nil
+		}
+	}
+}
+
+// invalidate calls the subscribers with keys. They are called
+// without holding the lock, so they can subscribe themselves.
+func (i *Invalidator) invalidate(keys []string) {
+	i.mu.RLock()
+	subscribers := i.subscribers
+	i.mu.RUnlock()
+
+	for _, key := range keys {
+		for _, f := range subscribers {
+			f(key)
+		}
+	}
+}
+
+func (i *Invalidator) reconnected() {
+	i.mu.RLock()
+	subscribers := i.reconnectSubscribers
+	i.mu.RUnlock()
+
+	for _, f := range subscribers {
+		f()
+	}
+}
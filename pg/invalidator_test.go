@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.gearno.de/kit/log"
+)
+
+func TestInvalidatorDispatch(t *testing.T) {
+	newInvalidator := func(window time.Duration) (*Invalidator, *[]string) {
+		var received []string
+
+		i := NewInvalidator(
+			&Client{logger: log.NewNopLogger()},
+			"cache",
+			WithInvalidatorDedupeWindow(window),
+		)
+		i.Subscribe(func(key string) { received = append(received, key) })
+
+		return i, &received
+	}
+
+	t.Run("dedupes bursts", func(t *testing.T) {
+		i, received := newInvalidator(time.Hour)
+
+		keys := make(chan string)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			i.dispatch(keys)
+		}()
+
+		for _, key := range []string{"a", "b", "a", "c", "b"} {
+			keys <- key
+		}
+		close(keys)
+		<-done
+
+		assert.Equal(t, []string{"a", "b", "c"}, *received)
+	})
+
+	t.Run("flushes at the end of the window", func(t *testing.T) {
+		i, received := newInvalidator(time.Millisecond)
+
+		keys := make(chan string)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			i.dispatch(keys)
+		}()
+
+		keys <- "a"
+		time.Sleep(50 * time.Millisecond)
+		keys <- "a"
+		close(keys)
+		<-done
+
+		assert.Equal(t, []string{"a", "a"}, *received)
+	})
+
+	t.Run("without window", func(t *testing.T) {
+		i, received := newInvalidator(0)
+
+		keys := make(chan string)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			i.dispatch(keys)
+		}()
+
+		keys <- "a"
+		keys <- "a"
+		close(keys)
+		<-done
+
+		assert.Equal(t, []string{"a", "a"}, *received)
+	})
+
+	t.Run("subscribes from a subscriber", func(t *testing.T) {
+		i, received := newInvalidator(0)
+		i.Subscribe(
+			func(key string) {
+				i.Subscribe(func(key string) { *received = append(*received, "late "+key) })
+			},
+		)
+
+		i.invalidate([]string{"a"})
+		i.invalidate([]string{"b"})
+
+		assert.Equal(t, []string{"a", "b", "late b"}, *received)
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"runtime"
+
+	"go.gearno.de/kit/log"
+)
+
+// trackTx logs a warning when t is garbage collected without having
+// been ended, and rolls it back so its connection returns to the pool.
+func trackTx(t *Tx) {
+	stack := leakStack()
+
+	runtime.SetFinalizer(
+		t,
+		func(t *Tx) {
+			if t.ended {
+				return
+			}
+
+			t.client.logger.Warn(
+				"transaction garbage collected without being committed or rolled back",
+				leakAttrs(stack)...,
+			)
+
+			go t.Rollback(context.Background())
+		},
+	)
+}
+
+func untrackTx(t *Tx) {
+	runtime.SetFinalizer(t, nil)
+}
+
+// trackPinnedConn logs a warning when pc is garbage collected without
+// having been released, and closes its connection so it leaves the
+// pool. It is not released as the session state it may hold, such as
+// settings or temporary tables, must not leak to other users of the
+// pool.
+func trackPinnedConn(pc *PinnedConn) {
+	stack := leakStack()
+
+	runtime.SetFinalizer(
+		pc,
+		func(pc *PinnedConn) {
+			if pc.released {
+				return
+			}
+
+			pc.client.logger.Warn(
+				"pinned connection garbage collected without being released",
+				leakAttrs(stack)...,
+			)
+
+			go pc.Close(context.Background())
+		},
+	)
+}
+
+func untrackPinnedConn(pc *PinnedConn) {
+	runtime.SetFinalizer(pc, nil)
+}
+
+// leakAttrs returns the log attributes of a leak, holding the stack it
+// was acquired with if captured.
+func leakAttrs(stack string) []log.Attr {
+	if stack == "" {
+		return nil
+	}
+
+	return []log.Attr{log.String("stack", stack)}
+}
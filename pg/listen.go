@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.gearno.de/kit/log"
+)
+
+// ListenJSON subscribes to the given channels with LISTEN and calls
+// handler with the JSON payload of each notification, decoded into a
+// T. Malformed payloads are logged and skipped. It blocks until ctx is
+// done, returning nil, or until handler returns an error, which is
+// returned.
+//
+// The notifications are received on a dedicated connection taken out
+// of the pool for the lifetime of the call, and closed on return.
+//
+// Example:
+//
+//	type OrderEvent struct {
+//	    ID     string `json:"id"`
+//	    Status string `json:"status"`
+//	}
+//
+//	err := pg.ListenJSON(
+//	    ctx,
+//	    client,
+//	    []string{"orders", "refunds"},
+//	    func(channel string, event OrderEvent) error {
+//	        return process(ctx, channel, event)
+//	    },
+//	)
+func ListenJSON[T any](
+	ctx context.Context,
+	c *Client,
+	channels []string,
+	handler func(channel string, payload T) error,
+) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("cannot listen: no channel")
+	}
+
+	pooledConn, err := c.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot acquire connection: %w", err)
+	}
+
+	// The connection is hijacked as the LISTEN subscriptions would
+	// otherwise outlive the call on a pooled connection.
+	conn := pooledConn.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	for _, channel := range channels {
+		q := "LISTEN " + pgx.Identifier{channel}.Sanitize()
+		if _, err := conn.Exec(ctx, q); err != nil {
+			return fmt.Errorf("cannot listen to %q channel: %w", channel, err)
+		}
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("cannot wait for notification: %w", err)
+		}
+
+		if err := dispatchJSON(ctx, c.logger, n, handler); err != nil {
+			return err
+		}
+	}
+}
+
+func dispatchJSON[T any](
+	ctx context.Context,
+	logger *log.Logger,
+	n *pgconn.Notification,
+	handler func(channel string, payload T) error,
+) error {
+	var payload T
+	if err := json.Unmarshal([]byte(n.Payload), &payload); err != nil {
+		logger.WarnCtx(
+			ctx,
+			"skipping malformed notification payload",
+			log.String("channel", n.Channel),
+			log.Error(err),
+		)
+
+		return nil
+	}
+
+	if err := handler(n.Channel, payload); err != nil {
+		return fmt.Errorf("cannot handle %q channel notification: %w", n.Channel, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+)
+
+func TestDispatchJSON(t *testing.T) {
+	type event struct {
+		ID string `json:"id"`
+	}
+
+	var (
+		buf      bytes.Buffer
+		logger   = log.NewLogger(log.WithOutput(&buf))
+		received []string
+		handler  = func(channel string, e event) error {
+			received = append(received, channel+":"+e.ID)
+			return nil
+		}
+	)
+
+	err := dispatchJSON(
+		context.Background(),
+		logger,
+		&pgconn.Notification{Channel: "orders", Payload: `{"id":"42"}`},
+		handler,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders:42"}, received)
+
+	err = dispatchJSON(
+		context.Background(),
+		logger,
+		&pgconn.Notification{Channel: "refunds", Payload: `not json`},
+		handler,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders:42"}, received)
+	assert.Contains(t, buf.String(), "skipping malformed notification payload")
+
+	errHandler := errors.New("boom")
+	err = dispatchJSON(
+		context.Background(),
+		logger,
+		&pgconn.Notification{Channel: "orders", Payload: `{"id":"43"}`},
+		func(string, event) error { return errHandler },
+	)
+	assert.ErrorIs(t, err, errHandler)
+}
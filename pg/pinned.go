@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// PinnedConn is a connection acquired from the pool and held
+	// across several operations until it is released, for workflows
+	// such as session level settings or temporary tables which do not
+	// fit in a single WithConn callback.
+	//
+	// A PinnedConn must be released exactly once with Release, and
+	// must not be used afterwards. A pinned connection garbage
+	// collected without being released is logged and closed, but
+	// only once the garbage collector finds it: until then, leaking
+	// pinned connections exhausts the pool. Building with the pgdebug
+	// tag adds the acquisition stack to the log.
+	PinnedConn struct {
+		conn   *pgxpool.Conn
+		client *Client
+		span   trace.Span
+
+		releaseOnce sync.Once
+		released    bool
+	}
+)
+
+var (
+	_ Conn = (*PinnedConn)(nil)
+)
+
+// Acquire acquires a connection from the pool and pins it until
+// Release is called on the returned PinnedConn.
+//
+// Example:
+//
+//	conn, err := client.Acquire(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	defer conn.Release()
+//
+//	if _, err := conn.Exec(ctx, "SET search_path TO tenant_42"); err != nil {
+//	    return err
+//	}
+//
+// If tracing is enabled, this method creates a span named "PinnedConn"
+// which ends when the connection is released.
+func (c *Client) Acquire(ctx context.Context) (*PinnedConn, error) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"PinnedConn",
+			trace.WithSpanKind(trace.SpanKindClient),
+		)
+	}
+
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		err := fmt.Errorf("cannot acquire connection: %w", err)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+			span.End()
+		}
+
+		return nil, err
+	}
+
+	if rootSpan.IsRecording() {
+		span.AddEvent("acquired")
+	}
+
+	pc := &PinnedConn{
+		conn:   conn,
+		client: c,
+		span:   span,
+	}
+	trackPinnedConn(pc)
+
+	return pc, nil
+}
+
+// Release returns the connection to the pool. Calling it more than
+// once is a no-op.
+func (pc *PinnedConn) Release() {
+	pc.releaseOnce.Do(
+		func() {
+			pc.released = true
+			untrackPinnedConn(pc)
+
+			pc.conn.Release()
+
+			if pc.span != nil {
+				pc.span.End()
+			}
+		},
+	)
+}
+
+// Close closes the connection instead of returning it to the pool, for
+// connections left in a state which must not leak to other users of
+// the pool, such as holding a session level lock. It releases the
+// PinnedConn: calling Release afterwards is a no-op.
+func (pc *PinnedConn) Close(ctx context.Context) error {
+	var err error
+
+	pc.releaseOnce.Do(
+		func() {
+			pc.released = true
+			untrackPinnedConn(pc)
+
+			err = pc.conn.Hijack().Close(ctx)
+
+			if pc.span != nil {
+				pc.span.End()
+			}
+		},
+	)
+
+	return err
+}
+
+// Begin starts a transaction on the pinned connection.
+func (pc *PinnedConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	return pc.conn.Begin(ctx)
+}
+
+// Exec executes sql with args on the pinned connection.
+func (pc *PinnedConn) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pc.conn.Exec(ctx, sql, args...)
+}
+
+// Query sends a query with args on the pinned connection.
+func (pc *PinnedConn) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return pc.conn.Query(ctx, sql, args...)
+}
+
+// QueryRow sends a query with args expected to return at most one row
+// on the pinned connection.
+func (pc *PinnedConn) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return pc.conn.QueryRow(ctx, sql, args...)
+}
+
+// CopyFrom uses the PostgreSQL copy protocol to perform bulk data
+// insertion on the pinned connection.
+func (pc *PinnedConn) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return pc.conn.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// SendBatch sends all queued queries of b on the pinned connection.
+func (pc *PinnedConn) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return pc.conn.SendBatch(ctx, b)
+}
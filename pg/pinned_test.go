@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestAcquire_Error(t *testing.T) {
+	var (
+		recorder = tracetest.NewSpanRecorder()
+		tp       = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	)
+
+	c, err := NewClient(
+		WithAddr("127.0.0.1:1"),
+		WithRegisterer(prometheus.NewRegistry()),
+		WithTracerProvider(tp),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, rootSpan := tp.Tracer("test").Start(context.Background(), "root")
+	defer rootSpan.End()
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	conn, err := c.Acquire(ctx)
+	assert.Nil(t, conn)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var span sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "PinnedConn" {
+			span = s
+		}
+	}
+	require.NotNil(t, span)
+	assert.Equal(t, codes.Error, span.Status().Code)
+}
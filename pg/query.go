@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Query executes the query on a connection from the pool and scans
+// every returned row into a T, matching the columns to the struct
+// fields by name as pgx.RowToStructByName does.
+//
+// Example:
+//
+//	type User struct {
+//	    ID    string
+//	    Email string
+//	}
+//
+//	users, err := pg.Query[User](ctx, client, "SELECT id, email FROM users")
+//
+// If tracing is enabled, this function creates a span named "Query"
+// and logs any errors.
+func Query[T any](ctx context.Context, c *Client, sql string, args ...any) ([]T, error) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+		result   []T
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"Query",
+			trace.WithSpanKind(trace.SpanKindClient),
+		)
+		defer span.End()
+	}
+
+	err := c.WithConn(
+		ctx,
+		func(conn Conn) error {
+			rows, err := conn.Query(ctx, sql, args...)
+			if err != nil {
+				return fmt.Errorf("cannot execute query: %w", err)
+			}
+
+			result, err = pgx.CollectRows(rows, pgx.RowToStructByName[T])
+			if err != nil {
+				return fmt.Errorf("cannot collect rows: %w", err)
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		if rootSpan.IsRecording() {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// QueryOne executes the query like Query but expects exactly one
+// row. It returns ErrNoRows when the query does not return any row
+// and pgx.ErrTooManyRows when it returns more than one.
+//
+// If tracing is enabled, this function creates a span named
+// "QueryOne" and logs any errors.
+func QueryOne[T any](ctx context.Context, c *Client, sql string, args ...any) (T, error) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+		result   T
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"QueryOne",
+			trace.WithSpanKind(trace.SpanKindClient),
+		)
+		defer span.End()
+	}
+
+	err := c.WithConn(
+		ctx,
+		func(conn Conn) error {
+			rows, err := conn.Query(ctx, sql, args...)
+			if err != nil {
+				return fmt.Errorf("cannot execute query: %w", err)
+			}
+
+			result, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[T])
+			if err != nil {
+				return fmt.Errorf("cannot collect row: %w", translateError(err))
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		// Not finding a row is an expected outcome, not a failure of
+		// the query.
+		if rootSpan.IsRecording() && !errors.Is(err, ErrNoRows) {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return result, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.gearno.de/kit/log"
+	"go.gearno.de/x/panicf"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// RetryOption configures the retries of WithConnRetry.
+	RetryOption func(o *retryOptions)
+
+	retryOptions struct {
+		maxAttempts    int
+		initialBackoff time.Duration
+		maxBackoff     time.Duration
+	}
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 50 * time.Millisecond
+	defaultRetryMaxBackoff     = time.Second
+)
+
+// WithRetryMaxAttempts sets the maximum number of times exec is run,
+// including the first attempt. It defaults to 3.
+func WithRetryMaxAttempts(n int) RetryOption {
+	return func(o *retryOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// WithRetryBackoff sets the delay before the first retry, doubled for
+// each following one up to max. Each retry waits a random duration
+// between half and the whole delay, so instances failing together do
+// not retry together. It defaults to 50ms and 1s. It panics if initial
+// is not positive or max is lower than initial.
+func WithRetryBackoff(initial, max time.Duration) RetryOption {
+	if initial <= 0 {
+		panicf.Panic("invalid initial retry backoff %s: must be positive", initial)
+	}
+
+	if max < initial {
+		panicf.Panic("invalid max retry backoff %s: must not be lower than the initial backoff %s", max, initial)
+	}
+
+	return func(o *retryOptions) {
+		o.initialBackoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// WithConnRetry executes the given ExecFunc like WithConn, running it
+// again on another connection when it fails with a transient
+// connection error, such as a connection reset or closed by the server
+// during a failover. Query errors, such as constraint violations, are
+// returned right away.
+//
+// A connection error may occur after the server executed the
+// statements sent by exec, so it is only safe for read-only or
+// idempotent operations. Writes must go through WithTx, which is never
+// retried.
+//
+// Example:
+//
+//	var count int
+//	err := client.WithConnRetry(
+//	    ctx,
+//	    func(conn pg.Conn) error {
+//	        return conn.QueryRow(ctx, "SELECT count(*) FROM users").Scan(&count)
+//	    },
+//	    pg.WithRetryMaxAttempts(5),
+//	)
+//
+// If tracing is enabled, this method creates a span named
+// "WithConnRetry", parent of the span of each attempt, and logs any
+// errors.
+func (c *Client) WithConnRetry(
+	ctx context.Context,
+	exec ExecFunc,
+	options ...RetryOption,
+) error {
+	opts := retryOptions{
+		maxAttempts:    defaultRetryMaxAttempts,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+	}
+
+	for _, o := range options {
+		o(&opts)
+	}
+
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"WithConnRetry",
+			trace.WithSpanKind(trace.SpanKindClient),
+		)
+		defer span.End()
+	}
+
+	backoff := opts.initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := c.WithConn(ctx, exec)
+
+		if rootSpan.IsRecording() {
+			span.SetAttributes(attribute.Int("db.retry.attempts", attempt))
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= opts.maxAttempts || ctx.Err() != nil || !isTransientError(err) {
+			if attempt > 1 {
+				err = fmt.Errorf("cannot execute after %d attempts: %w", attempt, err)
+			}
+
+			if rootSpan.IsRecording() {
+				recordError(span, err)
+			}
+
+			return err
+		}
+
+		c.logger.WarnCtx(
+			ctx,
+			"retrying after transient connection error",
+			log.Int("attempt", attempt),
+			log.Error(err),
+		)
+
+		delay := backoff/2 + rand.N(backoff/2+1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			err := fmt.Errorf("cannot execute after %d attempts: %w", attempt, errors.Join(err, ctx.Err()))
+			if rootSpan.IsRecording() {
+				recordError(span, err)
+			}
+
+			return err
+		case <-timer.C:
+		}
+
+		backoff = min(backoff*2, opts.maxBackoff)
+	}
+}
+
+// isTransientError reports whether err is a connection error which may
+// not happen again on another connection, as opposed to an error
+// returned by the server for the query itself.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrPoolExhausted) {
+		return false
+	}
+
+	if pgErr := asPgError(err); pgErr != nil {
+		switch {
+		case len(pgErr.Code) == 5 && pgErr.Code[:2] == "08":
+			// connection_exception class.
+			return true
+		case pgErr.Code == "57P01", pgErr.Code == "57P02", pgErr.Code == "57P03":
+			// admin_shutdown, crash_shutdown, cannot_connect_now.
+			return true
+		default:
+			return false
+		}
+	}
+
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	if errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"unexpected eof", fmt.Errorf("failed to receive message: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"no rows", ErrNoRows, false},
+		{"canceled", fmt.Errorf("query: %w", context.Canceled), false},
+		{"pool exhausted", &poolExhaustedError{context.DeadlineExceeded}, false},
+		{"other", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isTransientError(tt.err))
+		})
+	}
+}
+
+func TestWithConnRetry(t *testing.T) {
+	addr, _ := newFakeServer(t)
+
+	c, err := NewClient(
+		WithAddr(addr),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	backoff := WithRetryBackoff(time.Millisecond, time.Millisecond)
+
+	t.Run("transient error", func(t *testing.T) {
+		var attempts int
+		err := c.WithConnRetry(
+			context.Background(),
+			func(Conn) error {
+				attempts++
+				if attempts < 3 {
+					return io.ErrUnexpectedEOF
+				}
+
+				return nil
+			},
+			backoff,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		var attempts int
+		err := c.WithConnRetry(
+			context.Background(),
+			func(Conn) error {
+				attempts++
+				return io.ErrUnexpectedEOF
+			},
+			backoff,
+			WithRetryMaxAttempts(2),
+		)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		var attempts int
+		err := c.WithConnRetry(
+			context.Background(),
+			func(Conn) error {
+				attempts++
+				return &pgconn.PgError{Code: "23505"}
+			},
+			backoff,
+		)
+		var pgErr *pgconn.PgError
+		assert.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var attempts int
+		err := c.WithConnRetry(
+			ctx,
+			func(Conn) error {
+				attempts++
+				cancel()
+				return io.ErrUnexpectedEOF
+			},
+			backoff,
+		)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestWithRetryBackoff_Invalid(t *testing.T) {
+	assert.Panics(t, func() { WithRetryBackoff(0, time.Second) })
+	assert.Panics(t, func() { WithRetryBackoff(-time.Millisecond, time.Second) })
+	assert.Panics(t, func() { WithRetryBackoff(time.Second, time.Millisecond) })
+	assert.NotPanics(t, func() { WithRetryBackoff(time.Second, time.Second) })
+}
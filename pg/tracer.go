@@ -20,11 +20,14 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
@@ -34,6 +37,30 @@ import (
 type (
 	tracer struct {
 		tracer trace.Tracer
+
+		queriesTotal         *prometheus.CounterVec
+		queryDurationSeconds *prometheus.HistogramVec
+		copyRowsTotal        *prometheus.CounterVec
+		copyDurationSeconds  *prometheus.HistogramVec
+
+		// copyTables holds the tables whose COPY metrics are
+		// labeled with their name, the others being labeled
+		// otherCopyTable.
+		copyTables map[string]struct{}
+	}
+
+	queryStartKey struct{}
+
+	queryStart struct {
+		time      time.Time
+		operation string
+	}
+
+	copyStartKey struct{}
+
+	copyStart struct {
+		time  time.Time
+		table string
 	}
 )
 
@@ -49,6 +76,8 @@ var (
 const (
 	tracerName = "go.gearno.de/kit/pg"
 
+	otherCopyTable = "other"
+
 	// BatchSizeKey represents the batch size.
 	BatchSizeKey = attribute.Key("db.operation.batch.size")
 
@@ -58,11 +87,81 @@ const (
 	// RowsAffectedKey represents the number of rows affected.
 	RowsAffectedKey = attribute.Key("pgx.rows_affected")
 
+	// UpsertChunksKey represents the number of statements an upsert
+	// is split into.
+	UpsertChunksKey = attribute.Key("pgx.upsert.chunks")
+
 	// SQLStateKey represents PostgreSQL error code,
 	// see https://www.postgresql.org/docs/current/errcodes-appendix.html.
 	SQLStateKey = attribute.Key("db.response.status_code")
 )
 
+func newTracer(t trace.Tracer, registerer prometheus.Registerer, labels map[string]string) *tracer {
+	queriesTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem:   "pg",
+			Name:        "queries_total",
+			Help:        "Total number of queries executed.",
+			ConstLabels: labels,
+		},
+		[]string{"operation", "error"},
+	)
+	registerer.MustRegister(queriesTotal)
+
+	queryDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem:   "pg",
+			Name:        "query_duration_seconds",
+			Help:        "Duration of queries in seconds.",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: labels,
+		},
+		[]string{"operation"},
+	)
+	registerer.MustRegister(queryDurationSeconds)
+
+	copyRowsTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem:   "pg",
+			Name:        "copy_rows_total",
+			Help:        "Total number of rows copied with COPY FROM.",
+			ConstLabels: labels,
+		},
+		[]string{"table"},
+	)
+	registerer.MustRegister(copyRowsTotal)
+
+	copyDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem:   "pg",
+			Name:        "copy_duration_seconds",
+			Help:        "Duration of COPY FROM operations in seconds.",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: labels,
+		},
+		[]string{"table"},
+	)
+	registerer.MustRegister(copyDurationSeconds)
+
+	return &tracer{
+		tracer:               t,
+		queriesTotal:         queriesTotal,
+		queryDurationSeconds: queryDurationSeconds,
+		copyRowsTotal:        copyRowsTotal,
+		copyDurationSeconds:  copyDurationSeconds,
+	}
+}
+
+// copyTable returns the table label of the COPY metrics of table.
+func (t *tracer) copyTable(table pgx.Identifier) string {
+	name := strings.Join(table, ".")
+	if _, ok := t.copyTables[name]; ok {
+		return name
+	}
+
+	return otherCopyTable
+}
+
 func connectionConfigAttributes(config *pgx.ConnConfig) []trace.SpanStartOption {
 	if config != nil {
 		return []trace.SpanStartOption{
@@ -77,7 +176,23 @@ func connectionConfigAttributes(config *pgx.ConnConfig) []trace.SpanStartOption
 	return nil
 }
 
+// sqlOperationName returns the upper cased first keyword of sql,
+// skipping the leading comments such as the ones added by
+// WithConnTagged, which would otherwise make the operation label
+// unbounded.
 func sqlOperationName(sql string) string {
+	for {
+		sql = strings.TrimSpace(sql)
+
+		if rest, ok := strings.CutPrefix(sql, "/*"); ok {
+			_, sql, _ = strings.Cut(rest, "*/")
+		} else if rest, ok := strings.CutPrefix(sql, "--"); ok {
+			_, sql, _ = strings.Cut(rest, "\n")
+		} else {
+			break
+		}
+	}
+
 	fields := strings.Fields(sql)
 	if len(fields) > 0 {
 		return strings.ToUpper(fields[0])
@@ -109,11 +224,19 @@ func (t *tracer) TraceQueryStart(
 	conn *pgx.Conn,
 	data pgx.TraceQueryStartData,
 ) context.Context {
+	// The operation is the SQL verb rather than the query text to
+	// keep the metrics cardinality bounded.
+	operationName := sqlOperationName(data.SQL)
+	ctx = context.WithValue(
+		ctx,
+		queryStartKey{},
+		queryStart{time: time.Now(), operation: operationName},
+	)
+
 	if !trace.SpanFromContext(ctx).IsRecording() {
 		return ctx
 	}
 
-	operationName := sqlOperationName(data.SQL)
 	opts := []trace.SpanStartOption{
 		trace.WithSpanKind(trace.SpanKindClient),
 		trace.WithAttributes(
@@ -137,6 +260,18 @@ func (t *tracer) TraceQueryEnd(
 	conn *pgx.Conn,
 	data pgx.TraceQueryEndData,
 ) {
+	if qs, ok := ctx.Value(queryStartKey{}).(queryStart); ok {
+		t.queriesTotal.With(
+			prometheus.Labels{
+				"operation": qs.operation,
+				"error":     strconv.FormatBool(data.Err != nil),
+			},
+		).Inc()
+		t.queryDurationSeconds.With(
+			prometheus.Labels{"operation": qs.operation},
+		).Observe(time.Since(qs.time).Seconds())
+	}
+
 	span := trace.SpanFromContext(ctx)
 	if !span.IsRecording() {
 		return
@@ -233,6 +368,12 @@ func (t *tracer) TraceCopyFromStart(
 	conn *pgx.Conn,
 	data pgx.TraceCopyFromStartData,
 ) context.Context {
+	ctx = context.WithValue(
+		ctx,
+		copyStartKey{},
+		copyStart{time: time.Now(), table: t.copyTable(data.TableName)},
+	)
+
 	if !trace.SpanFromContext(ctx).IsRecording() {
 		return ctx
 	}
@@ -261,6 +402,17 @@ func (t *tracer) TraceCopyFromEnd(
 	conn *pgx.Conn,
 	data pgx.TraceCopyFromEndData,
 ) {
+	if cs, ok := ctx.Value(copyStartKey{}).(copyStart); ok {
+		if data.Err == nil {
+			t.copyRowsTotal.With(
+				prometheus.Labels{"table": cs.table},
+			).Add(float64(data.CommandTag.RowsAffected()))
+		}
+		t.copyDurationSeconds.With(
+			prometheus.Labels{"table": cs.table},
+		).Observe(time.Since(cs.time).Seconds())
+	}
+
 	span := trace.SpanFromContext(ctx)
 	if !span.IsRecording() {
 		return
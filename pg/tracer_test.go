@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTracerQueryMetrics(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	tr := newTracer(
+		noop.NewTracerProvider().Tracer("test"),
+		registry,
+		map[string]string{"database": "test"},
+	)
+
+	for _, q := range []struct {
+		sql string
+		err error
+	}{
+		{"SELECT 1", nil},
+		{"select * from users", nil},
+		{"INSERT INTO users (id) VALUES ($1)", errors.New("duplicate key")},
+		{"/*controller='users',traceparent='00-0af7'*/ SELECT 1", nil},
+		{"-- list users\n/* job='sync' */ select 1", nil},
+	} {
+		ctx := tr.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: q.sql})
+		tr.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: q.err})
+	}
+
+	assert.Equal(t, 4.0, testutil.ToFloat64(tr.queriesTotal.WithLabelValues("SELECT", "false")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tr.queriesTotal.WithLabelValues("INSERT", "true")))
+	assert.Equal(t, 2, testutil.CollectAndCount(tr.queryDurationSeconds))
+
+	_, err := registry.Gather()
+	assert.NoError(t, err)
+}
+
+func TestTracerCopyMetrics(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	tr := newTracer(
+		noop.NewTracerProvider().Tracer("test"),
+		registry,
+		map[string]string{"database": "test"},
+	)
+	tr.copyTables = map[string]struct{}{"audit.events": {}}
+
+	for _, c := range []struct {
+		table pgx.Identifier
+		tag   string
+		err   error
+	}{
+		{pgx.Identifier{"audit", "events"}, "COPY 100", nil},
+		{pgx.Identifier{"audit", "events"}, "COPY 20", nil},
+		{pgx.Identifier{"users"}, "COPY 3", nil},
+		{pgx.Identifier{"users"}, "", errors.New("duplicate key")},
+	} {
+		ctx := tr.TraceCopyFromStart(context.Background(), nil, pgx.TraceCopyFromStartData{TableName: c.table})
+		tr.TraceCopyFromEnd(ctx, nil, pgx.TraceCopyFromEndData{CommandTag: pgconn.NewCommandTag(c.tag), Err: c.err})
+	}
+
+	assert.Equal(t, 120.0, testutil.ToFloat64(tr.copyRowsTotal.WithLabelValues("audit.events")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(tr.copyRowsTotal.WithLabelValues("other")))
+	assert.Equal(t, 2, testutil.CollectAndCount(tr.copyDurationSeconds))
+
+	_, err := registry.Gather()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// Tx is a transaction begun with Begin, for code which must pass
+	// the transaction around and decide to commit or roll it back
+	// across several functions, which does not fit in a single
+	// WithTx callback. Prefer WithTx whenever possible, as it cannot
+	// leak the transaction.
+	//
+	// A Tx must be ended exactly once with Commit or Rollback, which
+	// return its connection to the pool. Rollback is a no-op once the
+	// transaction is ended, so it can be deferred right after Begin.
+	// As with PinnedConn, a transaction garbage collected without
+	// being ended is logged and rolled back; building with the
+	// pgdebug tag adds the stack of Begin to the log.
+	Tx struct {
+		tx     pgx.Tx
+		conn   *pgxpool.Conn
+		client *Client
+		span   trace.Span
+
+		endOnce sync.Once
+		ended   bool
+	}
+)
+
+var (
+	_ Conn = (*Tx)(nil)
+)
+
+// Begin acquires a connection from the pool and begins a transaction
+// on it, held until Commit or Rollback is called on the returned Tx.
+//
+// Example:
+//
+//	tx, err := client.Begin(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	defer tx.Rollback(ctx)
+//
+//	if err := debit(ctx, tx, from, amount); err != nil {
+//	    return err
+//	}
+//
+//	if err := credit(ctx, tx, to, amount); err != nil {
+//	    return err
+//	}
+//
+//	return tx.Commit(ctx)
+//
+// If tracing is enabled, this method creates a span named "Tx" which
+// ends when the transaction is committed or rolled back.
+func (c *Client) Begin(ctx context.Context) (*Tx, error) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"Tx",
+			trace.WithSpanKind(trace.SpanKindClient),
+		)
+	}
+
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		err := fmt.Errorf("cannot acquire connection: %w", err)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+			span.End()
+		}
+
+		return nil, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		conn.Release()
+
+		err := fmt.Errorf("cannot begin transaction: %w", err)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+			span.End()
+		}
+
+		return nil, err
+	}
+
+	t := &Tx{
+		tx:     tx,
+		conn:   conn,
+		client: c,
+		span:   span,
+	}
+	trackTx(t)
+
+	return t, nil
+}
+
+// Commit commits the transaction and returns its connection to the
+// pool. It returns pgx.ErrTxClosed if the transaction is already
+// ended.
+func (t *Tx) Commit(ctx context.Context) error {
+	err := pgx.ErrTxClosed
+
+	t.endOnce.Do(
+		func() {
+			err = t.end(
+				func() error {
+					if err := t.tx.Commit(ctx); err != nil {
+						return fmt.Errorf("cannot commit transaction: %w", err)
+					}
+
+					return nil
+				},
+			)
+		},
+	)
+
+	return err
+}
+
+// Rollback rolls back the transaction and returns its connection to
+// the pool. As in WithTx, the rollback is not cancelled along with
+// ctx, but is bounded to a few seconds. It is a no-op if the
+// transaction is already ended.
+func (t *Tx) Rollback(ctx context.Context) error {
+	var err error
+
+	t.endOnce.Do(
+		func() {
+			err = t.end(
+				func() error {
+					rollbackCtx, cancel := context.WithTimeout(
+						context.WithoutCancel(ctx),
+						rollbackTimeout,
+					)
+					defer cancel()
+
+					if err := t.tx.Rollback(rollbackCtx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+						return fmt.Errorf("cannot rollback transaction: %w", err)
+					}
+
+					return nil
+				},
+			)
+		},
+	)
+
+	return err
+}
+
+func (t *Tx) end(f func() error) error {
+	t.ended = true
+	untrackTx(t)
+
+	err := f()
+
+	t.conn.Release()
+
+	if t.span != nil {
+		if err != nil {
+			recordError(t.span, err)
+		}
+
+		t.span.End()
+	}
+
+	return err
+}
+
+// Exec executes sql with args within the transaction.
+func (t *Tx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return t.tx.Exec(ctx, sql, args...)
+}
+
+// Query sends a query with args within the transaction.
+func (t *Tx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return t.tx.Query(ctx, sql, args...)
+}
+
+// QueryRow sends a query with args expected to return at most one row
+// within the transaction.
+func (t *Tx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return t.tx.QueryRow(ctx, sql, args...)
+}
+
+// CopyFrom uses the PostgreSQL copy protocol to perform bulk data
+// insertion within the transaction.
+func (t *Tx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return t.tx.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// SendBatch sends all queued queries of b within the transaction.
+func (t *Tx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return t.tx.SendBatch(ctx, b)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBegin(t *testing.T) {
+	addr, queries := newFakeServer(t)
+
+	var (
+		recorder = tracetest.NewSpanRecorder()
+		tp       = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	)
+
+	c, err := NewClient(
+		WithAddr(addr),
+		WithRegisterer(prometheus.NewRegistry()),
+		WithTracerProvider(tp),
+	)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, rootSpan := tp.Tracer("test").Start(context.Background(), "root")
+	defer rootSpan.End()
+
+	received := func() []string {
+		var received []string
+		for len(queries) > 0 {
+			received = append(received, <-queries)
+		}
+
+		return received
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		tx, err := c.Begin(ctx)
+		require.NoError(t, err)
+
+		err = c.WithSavepoint(ctx, tx, func(Conn) error { return nil })
+		require.NoError(t, err)
+
+		require.NoError(t, tx.Commit(ctx))
+		assert.ErrorIs(t, tx.Commit(ctx), pgx.ErrTxClosed)
+
+		// A deferred rollback is a no-op once committed.
+		assert.NoError(t, tx.Rollback(ctx))
+
+		assert.Equal(
+			t,
+			[]string{"begin", "savepoint sp_1", "release savepoint sp_1", "commit"},
+			received(),
+		)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		tx, err := c.Begin(ctx)
+		require.NoError(t, err)
+
+		require.NoError(t, tx.Rollback(ctx))
+		assert.NoError(t, tx.Rollback(ctx))
+		assert.ErrorIs(t, tx.Commit(ctx), pgx.ErrTxClosed)
+
+		assert.Equal(t, []string{"begin", "rollback"}, received())
+	})
+
+	t.Run("leaked", func(t *testing.T) {
+		_, err := c.Begin(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"begin"}, received())
+
+		// The transaction garbage collected without being ended is
+		// rolled back and its connection returned to the pool.
+		var rolledBack []string
+		require.Eventually(
+			t,
+			func() bool {
+				runtime.GC()
+				rolledBack = append(rolledBack, received()...)
+
+				stat := c.pool.Stat()
+				return len(rolledBack) > 0 && stat.TotalConns() == stat.IdleConns()
+			},
+			5*time.Second,
+			10*time.Millisecond,
+		)
+		assert.Equal(t, []string{"rollback"}, rolledBack)
+	})
+
+	// The connections went back to the pool.
+	stat := c.pool.Stat()
+	assert.Equal(t, stat.TotalConns(), stat.IdleConns())
+
+	var spans int
+	for _, s := range recorder.Ended() {
+		if s.Name() == "Tx" {
+			spans++
+		}
+	}
+	assert.Equal(t, 3, spans)
+}
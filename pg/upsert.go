@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxParameters is the maximum number of parameters of a statement
+// supported by the PostgreSQL protocol.
+const maxParameters = 65535
+
+// Upsert inserts rows into table, updating the updateCols columns of
+// the existing rows conflicting on the conflictCols columns. When
+// updateCols is empty, conflicting rows are left untouched. Each row
+// holds one value per column, in the order of columns. The table may
+// be schema-qualified; all names are quoted.
+//
+// The rows are sent in as many statements as required to stay below
+// the protocol limit on the number of parameters, all within a single
+// transaction. Upsert returns the number of rows inserted or updated.
+//
+// Example:
+//
+//	n, err := client.Upsert(
+//	    ctx,
+//	    "users",
+//	    []string{"id", "email", "name"},
+//	    []string{"id"},
+//	    []string{"email", "name"},
+//	    [][]any{
+//	        {1, "alice@example.com", "Alice"},
+//	        {2, "bob@example.com", "Bob"},
+//	    },
+//	)
+//
+// If tracing is enabled, this method creates a span named "Upsert"
+// recording the number of rows and statements and logs any errors.
+func (c *Client) Upsert(
+	ctx context.Context,
+	table string,
+	columns []string,
+	conflictCols []string,
+	updateCols []string,
+	rows [][]any,
+) (int64, error) {
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("cannot upsert into %q: no columns", table)
+	}
+
+	if len(conflictCols) == 0 {
+		return 0, fmt.Errorf("cannot upsert into %q: no conflict columns", table)
+	}
+
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return 0, fmt.Errorf(
+				"cannot upsert into %q: row %d has %d values, expected %d",
+				table,
+				i,
+				len(row),
+				len(columns),
+			)
+		}
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	var (
+		rootSpan  = trace.SpanFromContext(ctx)
+		span      trace.Span
+		chunkSize = maxParameters / len(columns)
+		chunks    = (len(rows) + chunkSize - 1) / chunkSize
+		affected  int64
+	)
+
+	if rootSpan.IsRecording() {
+		ctx, span = c.tracer.Start(
+			ctx,
+			"Upsert",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				BatchSizeKey.Int(len(rows)),
+				UpsertChunksKey.Int(chunks),
+			),
+		)
+		defer span.End()
+	}
+
+	err := c.WithTx(
+		ctx,
+		func(tx Conn) error {
+			for start := 0; start < len(rows); start += chunkSize {
+				chunk := rows[start:min(start+chunkSize, len(rows))]
+
+				sql, args := upsertStatement(table, columns, conflictCols, updateCols, chunk)
+
+				tag, err := tx.Exec(ctx, sql, args...)
+				if err != nil {
+					return fmt.Errorf("cannot upsert rows %d to %d: %w", start, start+len(chunk)-1, err)
+				}
+
+				affected += tag.RowsAffected()
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		err := fmt.Errorf("cannot upsert into %q: %w", table, err)
+		if rootSpan.IsRecording() {
+			recordError(span, err)
+		}
+
+		return 0, err
+	}
+
+	if rootSpan.IsRecording() {
+		span.SetAttributes(RowsAffectedKey.Int64(affected))
+	}
+
+	return affected, nil
+}
+
+// upsertStatement builds the INSERT ... ON CONFLICT statement of rows
+// along with its arguments.
+func upsertStatement(
+	table string,
+	columns []string,
+	conflictCols []string,
+	updateCols []string,
+	rows [][]any,
+) (string, []any) {
+	var (
+		b    strings.Builder
+		args = make([]any, 0, len(rows)*len(columns))
+	)
+
+	b.WriteString("INSERT INTO ")
+	b.WriteString(pgx.Identifier(strings.Split(table, ".")).Sanitize())
+	b.WriteString(" (")
+	writeIdentifiers(&b, columns)
+	b.WriteString(") VALUES ")
+
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		b.WriteByte('(')
+		for j, value := range row {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+
+			args = append(args, value)
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(len(args)))
+		}
+		b.WriteByte(')')
+	}
+
+	b.WriteString(" ON CONFLICT (")
+	writeIdentifiers(&b, conflictCols)
+	b.WriteString(") DO ")
+
+	if len(updateCols) == 0 {
+		b.WriteString("NOTHING")
+		return b.String(), args
+	}
+
+	b.WriteString("UPDATE SET ")
+	for i, column := range updateCols {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		name := pgx.Identifier{column}.Sanitize()
+		b.WriteString(name)
+		b.WriteString(" = EXCLUDED.")
+		b.WriteString(name)
+	}
+
+	return b.String(), args
+}
+
+func writeIdentifiers(b *strings.Builder, names []string) {
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		b.WriteString(pgx.Identifier{name}.Sanitize())
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertStatement(t *testing.T) {
+	t.Run("update", func(t *testing.T) {
+		sql, args := upsertStatement(
+			"public.users",
+			[]string{"id", "email", "name"},
+			[]string{"id"},
+			[]string{"email", "name"},
+			[][]any{
+				{1, "alice@example.com", "Alice"},
+				{2, "bob@example.com", "Bob"},
+			},
+		)
+
+		assert.Equal(
+			t,
+			`INSERT INTO "public"."users" ("id", "email", "name") VALUES ($1, $2, $3), ($4, $5, $6)`+
+				` ON CONFLICT ("id") DO UPDATE SET "email" = EXCLUDED."email", "name" = EXCLUDED."name"`,
+			sql,
+		)
+		assert.Equal(t, []any{1, "alice@example.com", "Alice", 2, "bob@example.com", "Bob"}, args)
+	})
+
+	t.Run("nothing to update", func(t *testing.T) {
+		sql, args := upsertStatement(
+			"users",
+			[]string{"id"},
+			[]string{"id"},
+			nil,
+			[][]any{{1}},
+		)
+
+		assert.Equal(t, `INSERT INTO "users" ("id") VALUES ($1) ON CONFLICT ("id") DO NOTHING`, sql)
+		assert.Equal(t, []any{1}, args)
+	})
+
+	t.Run("quoted identifiers", func(t *testing.T) {
+		sql, _ := upsertStatement(
+			"users",
+			[]string{`na"me`},
+			[]string{`na"me`},
+			[]string{`na"me`},
+			[][]any{{"x"}},
+		)
+
+		assert.Equal(
+			t,
+			`INSERT INTO "users" ("na""me") VALUES ($1) ON CONFLICT ("na""me") DO UPDATE SET "na""me" = EXCLUDED."na""me"`,
+			sql,
+		)
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	t.Run("invalid row", func(t *testing.T) {
+		c := &Client{}
+
+		_, err := c.Upsert(
+			context.Background(),
+			"users",
+			[]string{"id", "name"},
+			[]string{"id"},
+			[]string{"name"},
+			[][]any{{1, "Alice"}, {2}},
+		)
+		assert.EqualError(t, err, `cannot upsert into "users": row 1 has 1 values, expected 2`)
+	})
+
+	t.Run("chunks", func(t *testing.T) {
+		addr, queries := newFakeServer(t)
+
+		c, err := NewClient(
+			WithAddr(addr),
+			WithQueryExecMode(pgx.QueryExecModeSimpleProtocol),
+			WithRegisterer(prometheus.NewRegistry()),
+		)
+		require.NoError(t, err)
+		defer c.Close()
+
+		columns := make([]string, 1000)
+		row := make([]any, len(columns))
+		for i := range columns {
+			columns[i] = "c"
+			row[i] = i
+		}
+
+		rows := make([][]any, 100)
+		for i := range rows {
+			rows[i] = row
+		}
+
+		_, err = c.Upsert(context.Background(), "t", columns, columns[:1], nil, rows)
+		require.NoError(t, err)
+
+		assert.Equal(t, "begin", <-queries)
+		for range 2 {
+			assert.Contains(t, <-queries, `INSERT INTO "t"`)
+		}
+		assert.Equal(t, "commit", <-queries)
+	})
+}
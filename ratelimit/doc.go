@@ -0,0 +1,5 @@
+// Package ratelimit provides sliding window and generic cell rate
+// algorithm (GCRA) rate limiters sharing the RateLimiter interface, so
+// the code depending on them can run against an in-memory
+// implementation in tests and local development.
+package ratelimit
@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"time"
+)
+
+// allowGCRA checks n requests for key against every rate with the
+// generic cell rate algorithm. The requests are allowed when the
+// theoretical arrival time of each rate, pushed by n emission
+// intervals, stays within one window from now.
+func (l *MemoryLimiter) allowGCRA(now time.Time, key string, rates []Rate, n int) *Result {
+	var (
+		tats    = make([]time.Time, len(rates))
+		allowed = true
+		retryAt time.Time
+	)
+
+	for i, rate := range rates {
+		tats[i] = maxTime(l.tats[windowKey{key, rate}], now)
+
+		allowAt := tats[i].Add(time.Duration(n)*rate.emissionInterval() - rate.Window)
+		if now.Before(allowAt) {
+			allowed = false
+			retryAt = maxTime(retryAt, allowAt)
+		}
+	}
+
+	var result *Result
+	for i, rate := range rates {
+		interval := rate.emissionInterval()
+
+		tat := tats[i]
+		if allowed {
+			tat = tat.Add(time.Duration(n) * interval)
+			l.tats[windowKey{key, rate}] = tat
+		}
+
+		r := &Result{
+			Allowed:   allowed,
+			Limit:     rate.Limit,
+			Remaining: max(0, int(now.Sub(tat.Add(-rate.Window))/interval)),
+			ResetAt:   tat,
+			WindowEnd: tat,
+		}
+
+		if result == nil || moreRestrictive(r, result) {
+			result = r
+		}
+	}
+
+	if !exceedsLimit(rates, n) {
+		result.retryAt = retryAt
+	}
+
+	if !result.Allowed {
+		result.denialReason = denialReasonLimitExceeded
+	}
+
+	return result
+}
+
+// statsGCRA returns the stats of key for rate under the generic cell
+// rate algorithm. There is no window: Current and Previous are zero,
+// Effective is the number of emission intervals between now and the
+// theoretical arrival time, and ResetAt is the time the key is back to
+// a full burst.
+func (l *MemoryLimiter) statsGCRA(now time.Time, key string, rate Rate) *KeyStats {
+	tat := maxTime(l.tats[windowKey{key, rate}], now)
+
+	return &KeyStats{
+		Effective: float64(tat.Sub(now)) / float64(rate.emissionInterval()),
+		ResetAt:   tat,
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter_GCRA(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter(WithAlgorithm(AlgorithmGCRA))
+		rate  = Rate{Limit: 10, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	)
+
+	// An idle key is allowed a burst of Limit requests.
+	result, err := l.allowN(start, "key", []Rate{rate}, 10)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, start.Add(time.Minute), result.ResetAt)
+
+	// Then one request per emission interval, Window / Limit.
+	result, err = l.allowN(start, "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, start.Add(6*time.Second), result.retryAt)
+
+	result, err = l.allowN(start.Add(6*time.Second-time.Nanosecond), "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	result, err = l.allowN(start.Add(6*time.Second), "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = l.allowN(start.Add(6*time.Second), "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, start.Add(12*time.Second), result.retryAt)
+
+	// The key is now half a window ahead of its schedule: half of the
+	// burst is available.
+	now := start.Add(36 * time.Second)
+
+	stats, err := l.stats(now, "key", rate)
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, stats.Effective)
+	assert.Equal(t, start.Add(66*time.Second), stats.ResetAt)
+
+	result, err = l.allowN(now, "key", []Rate{rate}, 6)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 5, result.Remaining)
+
+	result, err = l.allowN(now, "key", []Rate{rate}, 5)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryLimiter_GCRAWindowBoundary(t *testing.T) {
+	var (
+		rate = Rate{Limit: 10, Window: time.Minute}
+
+		// The burst happens right before a fixed window boundary.
+		burstAt  = time.Date(2024, 1, 1, 0, 0, 59, 0, time.UTC)
+		boundary = time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)
+	)
+
+	for _, algorithm := range []Algorithm{AlgorithmSlidingWindow, AlgorithmGCRA} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			l := NewMemoryLimiter(WithAlgorithm(algorithm))
+
+			result, err := l.allowN(burstAt, "key", []Rate{rate}, 10)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			// Neither algorithm lets a second burst through the
+			// window boundary.
+			result, err = l.allowN(boundary, "key", []Rate{rate}, 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			switch algorithm {
+			case AlgorithmSlidingWindow:
+				// The previous window count is interpolated: the
+				// burst made at the end of the previous window is
+				// accounted for as if spread across it, which
+				// delays the next request past the emission
+				// interval.
+				assert.Equal(t, boundary.Add(6*time.Second), result.retryAt)
+
+				result, err = l.allowN(burstAt.Add(6*time.Second), "key", []Rate{rate}, 1)
+				require.NoError(t, err)
+				assert.False(t, result.Allowed)
+			case AlgorithmGCRA:
+				// The next request is allowed exactly one emission
+				// interval after the burst.
+				assert.Equal(t, burstAt.Add(6*time.Second), result.retryAt)
+
+				result, err = l.allowN(burstAt.Add(6*time.Second), "key", []Rate{rate}, 1)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter_GCRATiered(t *testing.T) {
+	var (
+		l         = NewMemoryLimiter(WithAlgorithm(AlgorithmGCRA))
+		burst     = Rate{Limit: 2, Window: time.Second}
+		sustained = Rate{Limit: 3, Window: time.Minute}
+		start     = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	for i := 0; i < 2; i++ {
+		result, err := l.allowN(start, "key", []Rate{burst, sustained}, 1)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, err := l.allowN(start, "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, start.Add(500*time.Millisecond), result.retryAt)
+
+	result, err = l.allowN(start.Add(time.Second), "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// The sustained rate denies the request, the burst rate would
+	// allow it: none of them counts it.
+	result, err = l.allowN(start.Add(2*time.Second), "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 3, result.Limit)
+	assert.Equal(t, start.Add(20*time.Second), result.retryAt)
+}
+
+func TestMemoryLimiter_GCRAWaitN(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		l    = NewMemoryLimiter(WithAlgorithm(AlgorithmGCRA))
+		rate = Rate{Limit: 2, Window: 100 * time.Millisecond}
+	)
+
+	require.NoError(t, l.WaitN(ctx, "key", rate, 2))
+
+	start := time.Now()
+	require.NoError(t, l.WaitN(ctx, "key", rate, 1))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestMemoryLimiter_GCRASweep(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter(WithAlgorithm(AlgorithmGCRA))
+		rate  = Rate{Limit: 10, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	_, err := l.allowN(start, "old", []Rate{rate}, 1)
+	require.NoError(t, err)
+
+	_, err = l.allowN(start.Add(2*time.Minute), "new", []Rate{rate}, 1)
+	require.NoError(t, err)
+
+	assert.NotContains(t, l.tats, windowKey{"old", rate})
+	assert.Contains(t, l.tats, windowKey{"new", rate})
+}
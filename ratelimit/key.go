@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"slices"
+	"strings"
+)
+
+type (
+	// Key builds a rate limit key from named attributes, e.g. a user
+	// and an endpoint, instead of formatting them by hand. The zero
+	// value is an empty key ready to use.
+	//
+	// The key is canonical: attributes are sorted by name, so the
+	// order they are added in does not matter, and the separators
+	// are escaped in names and values, so distinct attributes never
+	// produce the same key. Keys are passed to the rate limiters with
+	// String:
+	//
+	//	key := ratelimit.NewKey().
+	//	    Add("user", userID).
+	//	    Add("endpoint", endpoint)
+	//
+	//	result, err := limiter.Allow(ctx, key.String(), rate)
+	Key struct {
+		attrs []keyAttr
+	}
+
+	keyAttr struct {
+		name  string
+		value string
+	}
+)
+
+var (
+	keyEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "=", "%3D")
+)
+
+// NewKey returns an empty key.
+func NewKey() Key {
+	return Key{}
+}
+
+// Add returns a copy of k with the name attribute set to value,
+// replacing the previous value of the attribute if any.
+func (k Key) Add(name, value string) Key {
+	attrs := make([]keyAttr, 0, len(k.attrs)+1)
+	for _, attr := range k.attrs {
+		if attr.name != name {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	attrs = append(attrs, keyAttr{name: name, value: value})
+
+	slices.SortFunc(
+		attrs,
+		func(a, b keyAttr) int {
+			return strings.Compare(a.name, b.name)
+		},
+	)
+
+	return Key{attrs: attrs}
+}
+
+// String returns the canonical representation of k, its attributes
+// formatted as "name=value" and separated by colons, e.g.
+// "endpoint=search:user=42".
+func (k Key) String() string {
+	var b strings.Builder
+
+	for i, attr := range k.attrs {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+
+		keyEscaper.WriteString(&b, attr.name)
+		b.WriteByte('=')
+		keyEscaper.WriteString(&b, attr.value)
+	}
+
+	return b.String()
+}
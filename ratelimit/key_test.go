@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "", NewKey().String())
+
+	key := NewKey().Add("user", "42").Add("endpoint", "search")
+	assert.Equal(t, "endpoint=search:user=42", key.String())
+
+	// Attributes are sorted, the order they are added in does not
+	// matter.
+	assert.Equal(
+		t,
+		key.String(),
+		NewKey().Add("endpoint", "search").Add("user", "42").String(),
+	)
+
+	// Adding an attribute again replaces its value.
+	assert.Equal(t, "endpoint=search:user=43", key.Add("user", "43").String())
+
+	// Add does not modify the key it is called on.
+	assert.Equal(t, "endpoint=search:user=42", key.String())
+
+	// Separators are escaped, so values containing them do not
+	// collide with other attributes.
+	assert.NotEqual(
+		t,
+		NewKey().Add("a", "b:c=d").String(),
+		NewKey().Add("a", "b").Add("c", "d").String(),
+	)
+	assert.Equal(t, "a=b%3Ac%3Dd%25", NewKey().Add("a", "b:c=d%").String())
+}
@@ -0,0 +1,749 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.gearno.de/kit/internal/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// Option configures the MemoryLimiter during initialization.
+	Option func(l *MemoryLimiter)
+
+	// MemoryLimiter is a RateLimiter keeping its counters in
+	// memory. Counters are neither shared between processes nor
+	// persisted, which makes it suitable for tests and local
+	// development only.
+	MemoryLimiter struct {
+		mu        sync.Mutex
+		windows   map[windowKey]*window
+		tats      map[windowKey]time.Time
+		overrides map[windowKey]override
+		nextSweep time.Time
+
+		algorithm Algorithm
+
+		maxWait time.Duration
+		clock   Clock
+
+		tracerProvider trace.TracerProvider
+		tracer         trace.Tracer
+	}
+
+	windowKey struct {
+		key  string
+		rate Rate
+	}
+
+	window struct {
+		start    time.Time
+		current  int
+		previous int
+	}
+
+	// override is a block, or a force allow when allow is set, of a
+	// key for a rate until the given time.
+	override struct {
+		until time.Time
+		allow bool
+	}
+)
+
+const (
+	sweepInterval = time.Minute
+
+	// denialReasonLimitExceeded is the denial reason of the requests
+	// exceeding the effective count allowed by the rate. As the
+	// counters are kept in memory, there is no cached denial.
+	denialReasonLimitExceeded = "limit_exceeded"
+
+	// denialReasonBlocked is the denial reason of the requests for a
+	// key blocked with Block.
+	denialReasonBlocked = "blocked"
+)
+
+var (
+	_ RateLimiter = (*MemoryLimiter)(nil)
+)
+
+// WithTracerProvider configures OpenTelemetry tracing with the
+// provided tracer provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(l *MemoryLimiter) {
+		l.tracerProvider = tp
+	}
+}
+
+// WithMaxWait makes WaitN fail instead of sleeping longer than d
+// before retrying. By default, the wait is only bounded by the context.
+func WithMaxWait(d time.Duration) Option {
+	return func(l *MemoryLimiter) {
+		l.maxWait = d
+	}
+}
+
+// WithAlgorithm sets the algorithm the requests are checked with. It
+// defaults to AlgorithmSlidingWindow.
+func WithAlgorithm(a Algorithm) Option {
+	return func(l *MemoryLimiter) {
+		l.algorithm = a
+	}
+}
+
+// WithClock sets the clock the windows are computed from. It defaults
+// to the system clock. WaitN still sleeps in real time, for the delay
+// computed from the clock.
+func WithClock(clock Clock) Option {
+	return func(l *MemoryLimiter) {
+		l.clock = clock
+	}
+}
+
+// NewMemoryLimiter returns an in-memory sliding window rate limiter.
+func NewMemoryLimiter(options ...Option) *MemoryLimiter {
+	l := &MemoryLimiter{
+		windows:        make(map[windowKey]*window),
+		tats:           make(map[windowKey]time.Time),
+		overrides:      make(map[windowKey]override),
+		clock:          realClock{},
+		tracerProvider: otel.GetTracerProvider(),
+	}
+
+	for _, o := range options {
+		o(l)
+	}
+
+	l.tracer = l.tracerProvider.Tracer(
+		tracerName,
+		trace.WithInstrumentationVersion(
+			version.New(0).Alpha(1),
+		),
+	)
+
+	return l
+}
+
+// Allow reports whether one request for key is allowed by rate.
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rate Rate) (*Result, error) {
+	return l.AllowN(ctx, key, rate, 1)
+}
+
+// AllowN reports whether n requests for key are allowed by rate. The
+// requests are counted only when they are allowed.
+func (l *MemoryLimiter) AllowN(ctx context.Context, key string, rate Rate, n int) (*Result, error) {
+	return l.check(
+		ctx,
+		"AllowN",
+		key,
+		[]Rate{rate},
+		n,
+		attribute.Int("ratelimit.limit", rate.Limit),
+		attribute.String("ratelimit.window", rate.Window.String()),
+		attribute.Int("ratelimit.n", n),
+	)
+}
+
+// AllowTiered reports whether one request for key is allowed by every
+// rate, such as a short burst rate and a longer sustained rate. The
+// request is counted against all the rates when it is allowed, and
+// against none of them otherwise. The result describes the most
+// restrictive rate: the denying rate resetting last when the request
+// is denied, or the rate with the fewest remaining requests when it
+// is allowed.
+func (l *MemoryLimiter) AllowTiered(ctx context.Context, key string, rates ...Rate) (*Result, error) {
+	return l.check(
+		ctx,
+		"AllowTiered",
+		key,
+		rates,
+		1,
+		attribute.Int("ratelimit.tiers", len(rates)),
+	)
+}
+
+func (l *MemoryLimiter) check(
+	ctx context.Context,
+	spanName string,
+	key string,
+	rates []Rate,
+	n int,
+	attrs ...attribute.KeyValue,
+) (*Result, error) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		_, span = l.tracer.Start(
+			ctx,
+			spanName,
+			trace.WithAttributes(attribute.String("ratelimit.key", key)),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+	}
+
+	now := l.clock.Now()
+
+	result, err := l.allowN(now, key, rates, n)
+	if err != nil {
+		if rootSpan.IsRecording() {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return nil, err
+	}
+
+	if rootSpan.IsRecording() {
+		span.SetAttributes(
+			attribute.Bool("ratelimit.allowed", result.Allowed),
+			attribute.Int("ratelimit.remaining", result.Remaining),
+		)
+
+		if !result.Allowed {
+			span.SetAttributes(attribute.String("ratelimit.denial_reason", result.denialReason))
+
+			// Requests exceeding the limit have no time to retry at.
+			if !result.retryAt.IsZero() {
+				span.SetAttributes(
+					attribute.Int64("ratelimit.retry_after_ms", retryAfterMs(now, result.retryAt)),
+				)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (l *MemoryLimiter) allowN(now time.Time, key string, rates []Rate, n int) (*Result, error) {
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no rate to check")
+	}
+
+	for _, rate := range rates {
+		if err := rate.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid request count %d: must be positive", n)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	if result := l.blocked(now, key, rates); result != nil {
+		return result, nil
+	}
+
+	rates, forced := l.forceAllowed(now, key, rates)
+	if forced != nil {
+		return forced, nil
+	}
+
+	if l.algorithm == AlgorithmGCRA {
+		return l.allowGCRA(now, key, rates, n), nil
+	}
+
+	var (
+		windows    = make([]*window, len(rates))
+		effectives = make([]float64, len(rates))
+		allowed    = true
+	)
+
+	for i, rate := range rates {
+		windows[i] = l.window(now, key, rate)
+		effectives[i] = effectiveCount(
+			windows[i].previous,
+			windows[i].current,
+			now.Sub(windows[i].start),
+			rate.Window,
+		)
+
+		if effectives[i]+float64(n) > float64(rate.Limit) {
+			allowed = false
+		}
+	}
+
+	var (
+		result  *Result
+		retryAt time.Time
+	)
+
+	for i, rate := range rates {
+		if allowed {
+			windows[i].current += n
+			effectives[i] += float64(n)
+		}
+
+		windowEnd := windows[i].start.Add(rate.Window)
+		r := &Result{
+			Allowed:   allowed,
+			Limit:     rate.Limit,
+			Remaining: max(0, rate.Limit-int(math.Ceil(effectives[i]))),
+			ResetAt:   windowEnd,
+			WindowEnd: windowEnd,
+		}
+
+		denying := effectives[i]+float64(n) > float64(rate.Limit)
+		if !allowed && !denying {
+			continue
+		}
+
+		// The previous window count slides out continuously, so a
+		// denied request is allowed again before the end of the
+		// window, unless it exceeds the limit.
+		if denying && !allowed && n <= rate.Limit {
+			r.ResetAt = slidingWindowRetryAt(
+				windows[i].start,
+				rate.Window,
+				windows[i].previous,
+				windows[i].current,
+				rate.Limit,
+				n,
+			)
+			retryAt = maxTime(retryAt, r.ResetAt)
+		}
+
+		if result == nil || moreRestrictive(r, result) {
+			result = r
+		}
+	}
+
+	if !exceedsLimit(rates, n) {
+		result.retryAt = retryAt
+	}
+
+	if !result.Allowed {
+		result.denialReason = denialReasonLimitExceeded
+	}
+
+	return result, nil
+}
+
+// Block denies the requests for key checked against rate until the
+// given time, regardless of its counters, for instance to ban an
+// abusive client. Blocking an already blocked or force allowed key
+// replaces the end of the block or the force allow. While blocked,
+// requests are neither counted nor allowed, and their result resets
+// at the end of the block.
+//
+// As for the counters, blocks are kept in memory and only apply to
+// this limiter: they are neither shared between processes nor
+// persisted.
+func (l *MemoryLimiter) Block(ctx context.Context, key string, rate Rate, until time.Time) error {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		_, span = l.tracer.Start(
+			ctx,
+			"Block",
+			trace.WithAttributes(
+				attribute.String("ratelimit.key", key),
+				attribute.Int("ratelimit.limit", rate.Limit),
+				attribute.String("ratelimit.window", rate.Window.String()),
+				attribute.String("ratelimit.blocked_until", until.Format(time.RFC3339)),
+			),
+		)
+		defer span.End()
+	}
+
+	if err := l.override(l.clock.Now(), key, rate, override{until: until}); err != nil {
+		if rootSpan.IsRecording() {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// ForceAllow allows the requests for key checked against rate until
+// the given time, regardless of its counters, for instance to exempt
+// a trusted client. Force allowing an already blocked or force allowed
+// key replaces the end of the block or the force allow. While force
+// allowed, requests are not counted against rate; checked with other
+// rates with AllowTiered, they are only counted and checked against
+// those.
+//
+// As for blocks, force allows are kept in memory and only apply to
+// this limiter.
+func (l *MemoryLimiter) ForceAllow(ctx context.Context, key string, rate Rate, until time.Time) error {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		_, span = l.tracer.Start(
+			ctx,
+			"ForceAllow",
+			trace.WithAttributes(
+				attribute.String("ratelimit.key", key),
+				attribute.Int("ratelimit.limit", rate.Limit),
+				attribute.String("ratelimit.window", rate.Window.String()),
+				attribute.String("ratelimit.allowed_until", until.Format(time.RFC3339)),
+			),
+		)
+		defer span.End()
+	}
+
+	if err := l.override(l.clock.Now(), key, rate, override{until: until, allow: true}); err != nil {
+		if rootSpan.IsRecording() {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (l *MemoryLimiter) override(now time.Time, key string, rate Rate, o override) error {
+	if err := rate.validate(); err != nil {
+		return err
+	}
+
+	if !o.until.After(now) {
+		return fmt.Errorf("cannot override key until %s: must be in the future", o.until.Format(time.RFC3339))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.overrides[windowKey{key, rate}] = o
+
+	return nil
+}
+
+// Unblock lifts the block or the force allow set on key for rate with
+// Block or ForceAllow, if any. The requests are then checked against
+// the counters again, which were left untouched by the override.
+func (l *MemoryLimiter) Unblock(ctx context.Context, key string, rate Rate) error {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		_, span = l.tracer.Start(
+			ctx,
+			"Unblock",
+			trace.WithAttributes(
+				attribute.String("ratelimit.key", key),
+				attribute.Int("ratelimit.limit", rate.Limit),
+				attribute.String("ratelimit.window", rate.Window.String()),
+			),
+		)
+		defer span.End()
+	}
+
+	if err := rate.validate(); err != nil {
+		if rootSpan.IsRecording() {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.overrides, windowKey{key, rate})
+
+	return nil
+}
+
+// blocked returns the denied result of a request for key when one of
+// the rates is blocked at now, describing the block ending last, or
+// nil when none is.
+func (l *MemoryLimiter) blocked(now time.Time, key string, rates []Rate) *Result {
+	var result *Result
+
+	for _, rate := range rates {
+		o, ok := l.overrides[windowKey{key, rate}]
+		if !ok || o.allow || !o.until.After(now) {
+			continue
+		}
+
+		if result == nil || o.until.After(result.ResetAt) {
+			result = &Result{
+				Allowed:      false,
+				Limit:        rate.Limit,
+				Remaining:    0,
+				ResetAt:      o.until,
+				WindowEnd:    o.until,
+				retryAt:      o.until,
+				denialReason: denialReasonBlocked,
+			}
+		}
+	}
+
+	return result
+}
+
+// forceAllowed returns the rates which are not force allowed for key
+// at now, which the request must still be checked against. When all
+// of them are, it returns the allowed result of the request,
+// describing the force allow ending first.
+func (l *MemoryLimiter) forceAllowed(now time.Time, key string, rates []Rate) ([]Rate, *Result) {
+	var (
+		checked []Rate
+		result  *Result
+	)
+
+	for _, rate := range rates {
+		o, ok := l.overrides[windowKey{key, rate}]
+		if !ok || !o.allow || !o.until.After(now) {
+			checked = append(checked, rate)
+			continue
+		}
+
+		if result == nil || o.until.Before(result.ResetAt) {
+			result = &Result{
+				Allowed:   true,
+				Limit:     rate.Limit,
+				Remaining: rate.Limit,
+				ResetAt:   o.until,
+				WindowEnd: o.until,
+			}
+		}
+	}
+
+	if len(checked) > 0 {
+		return checked, nil
+	}
+
+	return nil, result
+}
+
+// Stats returns the counters of key for rate, without counting any
+// request nor creating any state for key.
+// With AlgorithmGCRA, Current and Previous are always zero, Effective
+// is the number of requests the key is ahead of its emission schedule,
+// and ResetAt is the time it is back to a full burst.
+func (l *MemoryLimiter) Stats(ctx context.Context, key string, rate Rate) (*KeyStats, error) {
+	var (
+		rootSpan = trace.SpanFromContext(ctx)
+		span     trace.Span
+	)
+
+	if rootSpan.IsRecording() {
+		_, span = l.tracer.Start(
+			ctx,
+			"Stats",
+			trace.WithAttributes(
+				attribute.String("ratelimit.key", key),
+				attribute.Int("ratelimit.limit", rate.Limit),
+				attribute.String("ratelimit.window", rate.Window.String()),
+			),
+		)
+		defer span.End()
+	}
+
+	stats, err := l.stats(l.clock.Now(), key, rate)
+	if err != nil {
+		if rootSpan.IsRecording() {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+		}
+
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (l *MemoryLimiter) stats(now time.Time, key string, rate Rate) (*KeyStats, error) {
+	if err := rate.validate(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.algorithm == AlgorithmGCRA {
+		return l.statsGCRA(now, key, rate), nil
+	}
+
+	var (
+		start = now.Truncate(rate.Window)
+		w     = l.windows[windowKey{key, rate}]
+		stats = &KeyStats{ResetAt: start.Add(rate.Window)}
+	)
+
+	// Roll the window over as l.window would, without modifying it.
+	if w != nil {
+		switch {
+		case w.start.Equal(start):
+			stats.Current = w.current
+			stats.Previous = w.previous
+		case start.Sub(w.start) == rate.Window:
+			stats.Previous = w.current
+		}
+	}
+
+	stats.Weight = previousWeight(now.Sub(start), rate.Window)
+	stats.Effective = effectiveCount(stats.Previous, stats.Current, now.Sub(start), rate.Window)
+
+	return stats, nil
+}
+
+// window returns the window of key for rate at now, rolling it over
+// when now is past its end.
+func (l *MemoryLimiter) window(now time.Time, key string, rate Rate) *window {
+	var (
+		start = now.Truncate(rate.Window)
+		k     = windowKey{key, rate}
+		w     = l.windows[k]
+	)
+
+	if w == nil {
+		w = &window{start: start}
+		l.windows[k] = w
+	}
+
+	if !w.start.Equal(start) {
+		if start.Sub(w.start) == rate.Window {
+			w.previous = w.current
+		} else {
+			w.previous = 0
+		}
+
+		w.current = 0
+		w.start = start
+	}
+
+	return w
+}
+
+// WaitN blocks until n requests for key are allowed by rate and
+// counts them. When the requests are denied, it sleeps until the
+// algorithm would allow them and tries again. It returns an error
+// if n exceeds the rate limit, or if the wait would exceed the maximum
+// wait or the context deadline.
+func (l *MemoryLimiter) WaitN(ctx context.Context, key string, rate Rate, n int) error {
+	if n > rate.Limit {
+		return fmt.Errorf("cannot wait for %d requests: exceeds the rate limit of %d", n, rate.Limit)
+	}
+
+	for {
+		result, err := l.AllowN(ctx, key, rate, n)
+		if err != nil {
+			return err
+		}
+
+		if result.Allowed {
+			return nil
+		}
+
+		delay := result.retryAt.Sub(l.clock.Now())
+		if l.maxWait > 0 && delay > l.maxWait {
+			return fmt.Errorf("cannot wait %s for %d requests: exceeds the maximum wait of %s", delay, n, l.maxWait)
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("cannot wait %s for %d requests: exceeds the context deadline", delay, n)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAfterMs returns the milliseconds from now to retryAt, rounded
+// up so a client waiting for it is allowed.
+func retryAfterMs(now, retryAt time.Time) int64 {
+	d := retryAt.Sub(now)
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+func moreRestrictive(a, b *Result) bool {
+	if !a.Allowed {
+		return a.ResetAt.After(b.ResetAt)
+	}
+
+	if a.Remaining != b.Remaining {
+		return a.Remaining < b.Remaining
+	}
+
+	return a.ResetAt.After(b.ResetAt)
+}
+
+// sweep drops the windows which no longer influence any decision, at
+// most once per sweepInterval, so the memory used by the limiter
+// stays bounded by the number of active keys.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+
+	for k, w := range l.windows {
+		if now.Sub(w.start) >= 2*k.rate.Window {
+			delete(l.windows, k)
+		}
+	}
+
+	for k, tat := range l.tats {
+		if !tat.After(now) {
+			delete(l.tats, k)
+		}
+	}
+
+	for k, o := range l.overrides {
+		if !o.until.After(now) {
+			delete(l.overrides, k)
+		}
+	}
+
+	l.nextSweep = now.Add(sweepInterval)
+}
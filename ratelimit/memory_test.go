@@ -0,0 +1,624 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestMemoryLimiter_AllowN(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		l    = NewMemoryLimiter()
+		rate = Rate{Limit: 3, Window: time.Hour}
+	)
+
+	for i := 0; i < 3; i++ {
+		result, err := l.Allow(ctx, "user:1", rate)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+	}
+
+	result, err := l.Allow(ctx, "user:1", rate)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, err = l.AllowN(ctx, "user:2", rate, 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+
+	result, err = l.AllowN(ctx, "user:2", rate, 2)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "denied requests must not be counted")
+	assert.Equal(t, 1, result.Remaining)
+}
+
+func TestMemoryLimiter_SlidingWindow(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter()
+		rate  = Rate{Limit: 10, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	result, err := l.allowN(start, "key", []Rate{rate}, 10)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, start.Add(time.Minute), result.ResetAt)
+
+	// A quarter into the next window, 75% of the previous window
+	// count is still accounted for.
+	now := start.Add(time.Minute + 15*time.Second)
+
+	result, err = l.allowN(now, "key", []Rate{rate}, 3)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	result, err = l.allowN(now, "key", []Rate{rate}, 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, start.Add(2*time.Minute), result.ResetAt)
+
+	// After a full idle window, the counters start over.
+	result, err = l.allowN(start.Add(5*time.Minute), "key", []Rate{rate}, 10)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryLimiter_WithClock(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		clock = &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 50, 0, time.UTC)}
+		l     = NewMemoryLimiter(WithClock(clock))
+		rate  = Rate{Limit: 10, Window: time.Minute}
+	)
+
+	result, err := l.AllowN(ctx, "key", rate, 8)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, clock.now.Add(10*time.Second), result.ResetAt)
+
+	// Crossing the window boundary, half of the previous window count
+	// is still accounted for.
+	clock.Advance(40 * time.Second)
+
+	stats, err := l.Stats(ctx, "key", rate)
+	require.NoError(t, err)
+	assert.Equal(t, 8, stats.Previous)
+	assert.Equal(t, 0, stats.Current)
+	assert.Equal(t, 4.0, stats.Effective)
+
+	result, err = l.AllowN(ctx, "key", rate, 7)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	result, err = l.AllowN(ctx, "key", rate, 6)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// Two windows later, the counters start over.
+	clock.Advance(2 * time.Minute)
+
+	result, err = l.AllowN(ctx, "key", rate, 10)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryLimiter_Sweep(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter()
+		rate  = Rate{Limit: 1, Window: time.Second}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	_, err := l.allowN(start, "old", []Rate{rate}, 1)
+	require.NoError(t, err)
+
+	_, err = l.allowN(start.Add(2*time.Minute), "new", []Rate{rate}, 1)
+	require.NoError(t, err)
+
+	assert.Len(t, l.windows, 1)
+}
+
+func TestMemoryLimiter_InvalidArguments(t *testing.T) {
+	l := NewMemoryLimiter()
+
+	_, err := l.Allow(context.Background(), "key", Rate{Limit: 0, Window: time.Second})
+	assert.Error(t, err)
+
+	_, err = l.Allow(context.Background(), "key", Rate{Limit: 1})
+	assert.Error(t, err)
+
+	_, err = l.AllowN(context.Background(), "key", Rate{Limit: 1, Window: time.Second}, 0)
+	assert.Error(t, err)
+
+	// More than one request per nanosecond has no GCRA emission
+	// interval.
+	for _, algorithm := range []Algorithm{AlgorithmSlidingWindow, AlgorithmGCRA} {
+		l := NewMemoryLimiter(WithAlgorithm(algorithm))
+
+		_, err = l.Allow(context.Background(), "key", Rate{Limit: 2_000_000_000, Window: time.Second})
+		assert.Error(t, err, algorithm.String())
+
+		_, err = l.Allow(context.Background(), "key", Rate{Limit: 1_000_000_000, Window: time.Second})
+		assert.NoError(t, err, algorithm.String())
+	}
+}
+
+func TestMemoryLimiter_AllowTiered(t *testing.T) {
+	var (
+		l         = NewMemoryLimiter()
+		burst     = Rate{Limit: 2, Window: time.Second}
+		sustained = Rate{Limit: 3, Window: time.Minute}
+		start     = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	result, err := l.allowN(start, "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.Equal(t, 2, result.Limit)
+
+	result, err = l.allowN(start, "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// The burst rate denies the request.
+	result, err = l.allowN(start, "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, burst.Limit, result.Limit)
+	assert.Equal(t, start.Add(1500*time.Millisecond), result.ResetAt)
+	assert.Equal(t, start.Add(time.Second), result.WindowEnd)
+
+	// Once the burst windows are over, the sustained rate denies the
+	// request.
+	now := start.Add(10 * time.Second)
+
+	result, err = l.allowN(now, "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = l.allowN(now.Add(10*time.Second), "key", []Rate{burst, sustained}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, sustained.Limit, result.Limit)
+	assert.Equal(t, start.Add(80*time.Second+time.Nanosecond), result.ResetAt)
+	assert.Equal(t, start.Add(time.Minute), result.WindowEnd)
+
+	// Denied requests are not counted against any rate.
+	result, err = l.allowN(now.Add(10*time.Second), "key", []Rate{burst}, 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryLimiter_RetryAt(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter()
+		rate  = Rate{Limit: 2, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	for i := 0; i < 2; i++ {
+		_, err := l.allowN(start, "key", []Rate{rate}, 1)
+		require.NoError(t, err)
+	}
+
+	result, err := l.allowN(start, "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+
+	// The two requests of the first window weigh one request half
+	// way through the next window.
+	retryAt := start.Add(90 * time.Second)
+	assert.Equal(t, retryAt, result.retryAt)
+
+	result, err = l.allowN(retryAt.Add(-time.Second), "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, retryAt, result.retryAt)
+
+	result, err = l.allowN(retryAt, "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryLimiter_RetryAtExceedingLimit(t *testing.T) {
+	var (
+		rate  = Rate{Limit: 5, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	for _, algorithm := range []Algorithm{AlgorithmSlidingWindow, AlgorithmGCRA} {
+		t.Run(
+			algorithm.String(),
+			func(t *testing.T) {
+				l := NewMemoryLimiter(WithAlgorithm(algorithm))
+
+				result, err := l.allowN(start, "fresh", []Rate{rate}, 6)
+				require.NoError(t, err)
+				assert.False(t, result.Allowed)
+				assert.True(t, result.retryAt.IsZero())
+				assert.Equal(t, result.WindowEnd, result.ResetAt)
+				assert.False(t, result.ResetAt.Before(start))
+
+				_, err = l.allowN(start, "used", []Rate{rate}, 2)
+				require.NoError(t, err)
+
+				result, err = l.allowN(start.Add(time.Second), "used", []Rate{rate}, 6)
+				require.NoError(t, err)
+				assert.False(t, result.Allowed)
+				assert.True(t, result.retryAt.IsZero())
+				assert.Equal(t, result.WindowEnd, result.ResetAt)
+			},
+		)
+	}
+}
+
+func TestMemoryLimiter_ResetAtSteadyLoad(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter()
+		rate  = Rate{Limit: 10, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		now   = start
+	)
+
+	// A steady load of one request every 6 seconds fills the limit
+	// without exceeding it.
+	for now.Before(start.Add(time.Minute)) {
+		result, err := l.allowN(now, "key", []Rate{rate}, 1)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+
+		now = now.Add(6 * time.Second)
+	}
+
+	// A burst early in the next window is denied: the previous window
+	// requests still weigh too much.
+	now = start.Add(time.Minute + 12*time.Second)
+
+	var result *Result
+	for {
+		var err error
+		result, err = l.allowN(now, "key", []Rate{rate}, 1)
+		require.NoError(t, err)
+
+		if !result.Allowed {
+			break
+		}
+	}
+
+	// The request fits again as soon as enough of the previous window
+	// slides out, well before the end of the fixed window.
+	assert.Equal(t, start.Add(2*time.Minute), result.WindowEnd)
+	assert.True(t, result.ResetAt.Before(result.WindowEnd))
+	assert.Equal(t, result.retryAt, result.ResetAt)
+
+	// ResetAt is rounded up to the nanosecond.
+	assert.WithinDuration(t, start.Add(78*time.Second), result.ResetAt, time.Nanosecond)
+
+	denied, err := l.allowN(result.ResetAt.Add(-time.Millisecond), "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.False(t, denied.Allowed)
+
+	allowed, err := l.allowN(result.ResetAt, "key", []Rate{rate}, 1)
+	require.NoError(t, err)
+	assert.True(t, allowed.Allowed)
+}
+
+func TestMemoryLimiter_WaitN(t *testing.T) {
+	t.Run("waits for the window", func(t *testing.T) {
+		var (
+			l    = NewMemoryLimiter()
+			rate = Rate{Limit: 1, Window: 50 * time.Millisecond}
+			ctx  = context.Background()
+		)
+
+		require.NoError(t, l.WaitN(ctx, "key", rate, 1))
+		require.NoError(t, l.WaitN(ctx, "key", rate, 1))
+
+		result, err := l.Allow(ctx, "key", rate)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+	})
+
+	t.Run("more requests than the limit", func(t *testing.T) {
+		l := NewMemoryLimiter()
+
+		err := l.WaitN(context.Background(), "key", Rate{Limit: 1, Window: time.Second}, 2)
+		assert.Error(t, err)
+	})
+
+	t.Run("maximum wait", func(t *testing.T) {
+		var (
+			l    = NewMemoryLimiter(WithMaxWait(time.Millisecond))
+			rate = Rate{Limit: 1, Window: time.Hour}
+			ctx  = context.Background()
+		)
+
+		require.NoError(t, l.WaitN(ctx, "key", rate, 1))
+		assert.Error(t, l.WaitN(ctx, "key", rate, 1))
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		var (
+			l    = NewMemoryLimiter()
+			rate = Rate{Limit: 1, Window: time.Hour}
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		require.NoError(t, l.WaitN(ctx, "key", rate, 1))
+
+		start := time.Now()
+		assert.Error(t, l.WaitN(ctx, "key", rate, 1))
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		var (
+			l    = NewMemoryLimiter()
+			rate = Rate{Limit: 1, Window: time.Hour}
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		require.NoError(t, l.WaitN(ctx, "key", rate, 1))
+
+		time.AfterFunc(10*time.Millisecond, cancel)
+		assert.ErrorIs(t, l.WaitN(ctx, "key", rate, 1), context.Canceled)
+	})
+}
+
+func TestMemoryLimiter_Stats(t *testing.T) {
+	var (
+		l     = NewMemoryLimiter()
+		rate  = Rate{Limit: 10, Window: time.Minute}
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	stats, err := l.stats(start, "key", rate)
+	require.NoError(t, err)
+	assert.Equal(t, &KeyStats{Weight: 1, ResetAt: start.Add(time.Minute)}, stats)
+	assert.Empty(t, l.windows, "stats does not create any state")
+
+	_, err = l.allowN(start, "key", []Rate{rate}, 4)
+	require.NoError(t, err)
+
+	now := start.Add(time.Minute + 15*time.Second)
+
+	stats, err = l.stats(now, "key", rate)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		&KeyStats{
+			Current:   0,
+			Previous:  4,
+			Weight:    0.75,
+			Effective: 3,
+			ResetAt:   start.Add(2 * time.Minute),
+		},
+		stats,
+	)
+
+	// Reading the stats does not roll the window over.
+	assert.Equal(t, start, l.windows[windowKey{"key", rate}].start)
+
+	_, err = l.allowN(now, "key", []Rate{rate}, 2)
+	require.NoError(t, err)
+
+	stats, err = l.stats(now, "key", rate)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Current)
+	assert.Equal(t, 4, stats.Previous)
+	assert.Equal(t, 5.0, stats.Effective)
+
+	stats, err = l.stats(start.Add(time.Hour), "key", rate)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Current)
+	assert.Zero(t, stats.Previous)
+
+	_, err = l.Stats(context.Background(), "key", Rate{})
+	assert.Error(t, err)
+}
+
+func TestMemoryLimiter_DenialSpan(t *testing.T) {
+	var (
+		recorder = tracetest.NewSpanRecorder()
+		tp       = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		l        = NewMemoryLimiter(WithTracerProvider(tp))
+		rate     = Rate{Limit: 1, Window: time.Hour}
+	)
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "root")
+	defer span.End()
+
+	for i := 0; i < 2; i++ {
+		_, err := l.Allow(ctx, "key", rate)
+		require.NoError(t, err)
+	}
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.True(t, attrs["ratelimit.allowed"].AsBool())
+	assert.NotContains(t, attrs, attribute.Key("ratelimit.denial_reason"))
+
+	attrs = map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[1].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.False(t, attrs["ratelimit.allowed"].AsBool())
+	assert.Equal(t, "limit_exceeded", attrs["ratelimit.denial_reason"].AsString())
+	assert.Positive(t, attrs["ratelimit.retry_after_ms"].AsInt64())
+	assert.LessOrEqual(t, attrs["ratelimit.retry_after_ms"].AsInt64(), 2*time.Hour.Milliseconds())
+}
+
+func TestRetryAfterMs(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, int64(0), retryAfterMs(now, now))
+	assert.Equal(t, int64(1), retryAfterMs(now, now.Add(time.Microsecond)))
+	assert.Equal(t, int64(1500), retryAfterMs(now, now.Add(1500*time.Millisecond)))
+}
+
+func TestMemoryLimiter_Block(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmSlidingWindow, AlgorithmGCRA} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			var (
+				ctx   = context.Background()
+				clock = &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+				l     = NewMemoryLimiter(WithClock(clock), WithAlgorithm(algorithm))
+				rate  = Rate{Limit: 10, Window: time.Minute}
+				other = Rate{Limit: 100, Window: time.Hour}
+				until = clock.now.Add(time.Hour)
+			)
+
+			require.NoError(t, l.Block(ctx, "key", rate, until))
+
+			result, err := l.Allow(ctx, "key", rate)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, 0, result.Remaining)
+			assert.Equal(t, until, result.ResetAt)
+			assert.Equal(t, denialReasonBlocked, result.denialReason)
+
+			result, err = l.AllowTiered(ctx, "key", other, rate)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			// Other keys and rates are not blocked.
+			result, err = l.Allow(ctx, "other", rate)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			result, err = l.Allow(ctx, "key", other)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			require.NoError(t, l.Unblock(ctx, "key", rate))
+
+			result, err = l.AllowN(ctx, "key", rate, 10)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			// The block ends on its own.
+			require.NoError(t, l.Block(ctx, "key", rate, clock.now.Add(time.Minute)))
+			clock.Advance(2 * time.Minute)
+
+			result, err = l.Allow(ctx, "key", rate)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			assert.Error(t, l.Block(ctx, "key", rate, clock.now))
+		})
+	}
+}
+
+func TestMemoryLimiter_ForceAllow(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmSlidingWindow, AlgorithmGCRA} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			var (
+				ctx   = context.Background()
+				clock = &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+				l     = NewMemoryLimiter(WithClock(clock), WithAlgorithm(algorithm))
+				rate  = Rate{Limit: 1, Window: time.Minute}
+				other = Rate{Limit: 2, Window: time.Hour}
+				until = clock.now.Add(time.Hour)
+			)
+
+			require.NoError(t, l.ForceAllow(ctx, "key", rate, until))
+
+			for i := 0; i < 3; i++ {
+				result, err := l.Allow(ctx, "key", rate)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, 1, result.Remaining)
+				assert.Equal(t, until, result.ResetAt)
+			}
+
+			// Tiered rates which are not force allowed still apply.
+			for i := 0; i < 2; i++ {
+				result, err := l.AllowTiered(ctx, "key", rate, other)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+			}
+
+			result, err := l.AllowTiered(ctx, "key", rate, other)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, 2, result.Limit)
+
+			// A block replaces the force allow.
+			require.NoError(t, l.Block(ctx, "key", rate, until))
+			result, err = l.Allow(ctx, "key", rate)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			require.NoError(t, l.ForceAllow(ctx, "key", rate, until))
+			require.NoError(t, l.Unblock(ctx, "key", rate))
+
+			// The force allowed requests were not counted.
+			result, err = l.Allow(ctx, "key", rate)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			result, err = l.Allow(ctx, "key", rate)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			// The force allow ends on its own.
+			require.NoError(t, l.ForceAllow(ctx, "other", rate, clock.now.Add(time.Minute)))
+			clock.Advance(2 * time.Minute)
+
+			result, err = l.Allow(ctx, "other", rate)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			result, err = l.Allow(ctx, "other", rate)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			assert.Error(t, l.ForceAllow(ctx, "key", rate, clock.now))
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type (
+	// Rate is the number of requests allowed per window. Both must
+	// be positive, and the limit must not exceed one request per
+	// nanosecond of the window.
+	Rate struct {
+		Limit  int
+		Window time.Duration
+	}
+
+	// Result describes the outcome of a rate limit check.
+	Result struct {
+		// Allowed reports whether the request is allowed.
+		Allowed bool
+
+		// Limit is the limit of the rate the request was checked
+		// against.
+		Limit int
+
+		// Remaining is the number of requests still allowed in the
+		// current window.
+		Remaining int
+
+		// ResetAt is the time the limit resets. With
+		// AlgorithmSlidingWindow, it is the end of the current
+		// window for an allowed request, and the earliest time a
+		// denied request would be allowed, assuming no other
+		// request is made, as the previous window count decays
+		// continuously; a request exceeding the limit is never
+		// allowed and resets at the end of the current window.
+		// With AlgorithmGCRA, it is the time the key is back to a
+		// full burst.
+		ResetAt time.Time
+
+		// WindowEnd is the end of the current fixed window with
+		// AlgorithmSlidingWindow, and equals ResetAt with
+		// AlgorithmGCRA or when the key is blocked.
+		WindowEnd time.Time
+
+		// retryAt is the earliest time the denied request would
+		// be allowed, assuming no other request is made, or the
+		// zero time when it exceeds the limit.
+		retryAt time.Time
+
+		// denialReason is the reason the request is denied, traced
+		// as the ratelimit.denial_reason span attribute.
+		denialReason string
+	}
+
+	// KeyStats holds the sliding window counters of a key for a
+	// rate.
+	KeyStats struct {
+		// Current is the number of requests counted in the
+		// current window.
+		Current int
+
+		// Previous is the number of requests counted in the
+		// previous window.
+		Previous int
+
+		// Weight is the part of the previous window still covered
+		// by the sliding window, between 0 and 1.
+		Weight float64
+
+		// Effective is the estimated number of requests made
+		// during the last window, Previous * Weight + Current,
+		// which is checked against the rate limit.
+		Effective float64
+
+		// ResetAt is the end of the current window.
+		ResetAt time.Time
+	}
+
+	// Clock provides the current time to the rate limiters, so tests
+	// can control it.
+	Clock interface {
+		Now() time.Time
+	}
+
+	realClock struct{}
+
+	// Algorithm is the algorithm a rate limiter checks the requests
+	// with.
+	Algorithm int
+
+	// RateLimiter checks requests identified by a key against a
+	// rate.
+	RateLimiter interface {
+		// Allow reports whether one request for key is allowed
+		// by rate, and counts it when it is.
+		Allow(ctx context.Context, key string, rate Rate) (*Result, error)
+
+		// AllowN reports whether n requests for key are allowed
+		// by rate, and counts them when they are.
+		AllowN(ctx context.Context, key string, rate Rate, n int) (*Result, error)
+
+		// AllowTiered reports whether one request for key is
+		// allowed by every rate, and counts it against all of
+		// them when it is.
+		AllowTiered(ctx context.Context, key string, rates ...Rate) (*Result, error)
+
+		// WaitN blocks until n requests for key are allowed by
+		// rate and counts them, or returns an error if they
+		// cannot be allowed in time.
+		WaitN(ctx context.Context, key string, rate Rate, n int) error
+
+		// Stats returns the counters of key for rate, without
+		// counting any request.
+		Stats(ctx context.Context, key string, rate Rate) (*KeyStats, error)
+	}
+)
+
+const (
+	// AlgorithmSlidingWindow estimates the number of requests made
+	// during the last window from the counts of the current and
+	// previous fixed windows, the latter weighted by the part of it
+	// still covered by the sliding window.
+	AlgorithmSlidingWindow Algorithm = iota
+
+	// AlgorithmGCRA is the generic cell rate algorithm, storing a
+	// single theoretical arrival time per key and rate. A Rate maps
+	// to an emission interval of Window / Limit, the exact spacing
+	// between two requests once the burst is used, and to a burst of
+	// Limit requests: a key idle for a whole window can make Limit
+	// requests at once. Unlike the sliding window, the time a denied
+	// request must wait is exact.
+	AlgorithmGCRA
+)
+
+const (
+	tracerName = "go.gearno.de/kit/ratelimit"
+)
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmSlidingWindow:
+		return "sliding_window"
+	case AlgorithmGCRA:
+		return "gcra"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+}
+
+// emissionInterval returns the GCRA spacing between two requests
+// allowed by r.
+func (r Rate) emissionInterval() time.Duration {
+	return r.Window / time.Duration(r.Limit)
+}
+
+func (r Rate) validate() error {
+	if r.Limit <= 0 {
+		return fmt.Errorf("invalid rate limit %d: must be positive", r.Limit)
+	}
+
+	if r.Window <= 0 {
+		return fmt.Errorf("invalid rate window %s: must be positive", r.Window)
+	}
+
+	// The GCRA emission interval would be zero.
+	if r.emissionInterval() == 0 {
+		return fmt.Errorf("invalid rate limit %d: exceeds one request per nanosecond over %s", r.Limit, r.Window)
+	}
+
+	return nil
+}
+
+// exceedsLimit reports whether n requests exceed the limit of one of
+// rates, in which case they are never allowed.
+func exceedsLimit(rates []Rate, n int) bool {
+	for _, rate := range rates {
+		if n > rate.Limit {
+			return true
+		}
+	}
+
+	return false
+}
+
+// effectiveCount returns the sliding window estimation of the number
+// of requests made during the last window: the previous window count
+// weighted by the part of it still covered by the sliding window,
+// plus the current window count.
+func effectiveCount(previous, current int, elapsed, window time.Duration) float64 {
+	return float64(previous)*previousWeight(elapsed, window) + float64(current)
+}
+
+// previousWeight returns the part of the previous window still covered
+// by the sliding window, elapsed into the current window.
+func previousWeight(elapsed, window time.Duration) float64 {
+	return 1 - float64(elapsed)/float64(window)
+}
+
+// slidingWindowRetryAt returns the earliest time n requests are
+// allowed by a sliding window starting at start with the given counts,
+// assuming no other request is made in the meantime, or the zero time
+// when n exceeds the limit as they are never allowed.
+func slidingWindowRetryAt(start time.Time, window time.Duration, previous, current, limit, n int) time.Time {
+	if n > limit {
+		return time.Time{}
+	}
+
+	if current+n > limit {
+		// The current window count alone is too high: wait for the
+		// next window, in which it decays as the previous count.
+		elapsed := float64(window) * (1 - float64(limit-n)/float64(current))
+		return start.Add(window + time.Duration(math.Ceil(elapsed)))
+	}
+
+	elapsed := float64(window) * (1 - float64(limit-n-current)/float64(previous))
+	return start.Add(time.Duration(math.Ceil(elapsed)))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// loadConfigurationFromEnv overlays the configuration with environment
+// variables. The unit configuration uses the UNIT prefix and each
+// Configurable runnable uses its name upper-cased, with dashes
+// replaced by underscores, as prefix.
+func (u *Unit) loadConfigurationFromEnv() error {
+	if err := applyEnv("UNIT", u.config); err != nil {
+		return fmt.Errorf("cannot decode %q config section: %w", "unit", err)
+	}
+
+	for _, r := range u.runnables {
+		configurable, ok := r.main.(Configurable)
+		if !ok {
+			continue
+		}
+
+		if err := applyEnv(envName(r.name), configurable.GetConfiguration()); err != nil {
+			return fmt.Errorf("cannot decode %q config section: %w", r.name, err)
+		}
+	}
+
+	return nil
+}
+
+// applyEnv sets the fields of the struct pointed by v from the
+// environment variables named after their JSON path, e.g. the
+// "max-batch-size" field of the "tracing" section of the unit
+// configuration is read from UNIT_TRACING_MAX_BATCH_SIZE. String
+// values are used verbatim, other values are decoded as JSON.
+func applyEnv(prefix string, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	return applyEnvToStruct(prefix, rv.Elem())
+}
+
+func applyEnvToStruct(prefix string, v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		key := prefix + "_" + envName(name)
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			if err := applyEnvToStruct(key, value); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+
+		if value.Kind() == reflect.String {
+			value.SetString(raw)
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(raw), value.Addr().Interface()); err != nil {
+			return fmt.Errorf("cannot decode %s environment variable: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
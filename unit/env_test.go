@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigurationPrecedence(t *testing.T) {
+	filename := writeConfigFile(t, `
+unit:
+  metrics:
+    addr: ":9191"
+  tracing:
+    max-batch-size: 10
+test-service:
+  greeting: "hello"
+`)
+
+	t.Setenv("UNIT_METRICS_ADDR", ":9292")
+	t.Setenv("UNIT_TRACING_ENABLED", "false")
+	t.Setenv("UNIT_TRACING_HEADERS", `{"api-key":"secret"}`)
+	t.Setenv("TEST_SERVICE_GREETING", "bonjour")
+
+	svc := &testService{}
+	u := NewUnit(svc, "test-service", "1.0.0", "test")
+
+	assert.NoError(t, u.loadConfigurationFromFiles(filename))
+	assert.NoError(t, u.loadConfigurationFromEnv())
+
+	assert.Equal(t, ":9292", u.config.Metrics.Addr)
+	assert.Equal(t, 10, u.config.Tracing.MaxBatchSize)
+	assert.Equal(t, 5000, u.config.Tracing.MaxQueueSize)
+	assert.False(t, u.config.Tracing.Enabled)
+	assert.Equal(t, map[string]string{"api-key": "secret"}, u.config.Tracing.Headers)
+	assert.Equal(t, "bonjour", svc.config.Greeting)
+}
+
+func TestLoadConfigurationFromEnvInvalidValue(t *testing.T) {
+	t.Setenv("UNIT_TRACING_MAX_BATCH_SIZE", "many")
+
+	u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+	assert.ErrorContains(t, u.loadConfigurationFromEnv(), "UNIT_TRACING_MAX_BATCH_SIZE")
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// group tracks named goroutines so a shutdown can report which
+	// ones are still running once its deadline elapses.
+	group struct {
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running map[string]struct{}
+	}
+)
+
+func newGroup() *group {
+	return &group{running: make(map[string]struct{})}
+}
+
+func (g *group) Go(name string, f func()) {
+	g.mu.Lock()
+	g.running[name] = struct{}{}
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			g.mu.Lock()
+			delete(g.running, name)
+			g.mu.Unlock()
+		}()
+
+		f()
+	}()
+}
+
+// Wait blocks until all goroutines return, the deadline elapses or
+// abort is closed. It returns the sorted names of the goroutines still
+// running, if any. A nil deadline and a nil abort wait forever.
+func (g *group) Wait(deadline <-chan time.Time, abort <-chan struct{}) []string {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-deadline:
+	case <-abort:
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.running))
+	for name := range g.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+type (
+	// HealthCheck reports whether a component of the service is
+	// ready to serve traffic. It returns a non-nil error when the
+	// component is not ready.
+	HealthCheck func(context.Context) error
+
+	healthChecks struct {
+		mu     sync.RWMutex
+		checks map[string]HealthCheck
+	}
+
+	healthResponse struct {
+		Status  string            `json:"status"`
+		Failing map[string]string `json:"failing,omitempty"`
+	}
+)
+
+// RegisterHealthCheck adds a named check to the readiness endpoint
+// served by the metrics server on "/readyz". The endpoint responds
+// with 503 and the list of failing components as soon as one check
+// fails. It is safe to call RegisterHealthCheck while the unit runs.
+func (u *Unit) RegisterHealthCheck(name string, check HealthCheck) {
+	u.healthChecks.mu.Lock()
+	defer u.healthChecks.mu.Unlock()
+
+	if u.healthChecks.checks == nil {
+		u.healthChecks.checks = make(map[string]HealthCheck)
+	}
+
+	u.healthChecks.checks[name] = check
+}
+
+func (hc *healthChecks) run(ctx context.Context) map[string]string {
+	hc.mu.RLock()
+	checks := make(map[string]HealthCheck, len(hc.checks))
+	for name, check := range hc.checks {
+		checks[name] = check
+	}
+	hc.mu.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failing = make(map[string]string)
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				failing[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return failing
+}
+
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	renderHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func (hc *healthChecks) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	failing := hc.run(r.Context())
+	if len(failing) > 0 {
+		renderHealth(
+			w,
+			http.StatusServiceUnavailable,
+			healthResponse{Status: "unavailable", Failing: failing},
+		)
+		return
+	}
+
+	renderHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func renderHealth(w http.ResponseWriter, statusCode int, v healthResponse) {
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type (
+	// lazyTracerProvider is a trace.TracerProvider delegating to a
+	// noop provider until the traces exporter is started, and to the
+	// provider of the exporter afterwards. Tracers obtained before the
+	// swap start recording as soon as it happens.
+	lazyTracerProvider struct {
+		embedded.TracerProvider
+
+		next atomic.Pointer[trace.TracerProvider]
+	}
+
+	lazyTracer struct {
+		embedded.Tracer
+
+		provider *lazyTracerProvider
+		name     string
+		options  []trace.TracerOption
+
+		resolved atomic.Pointer[resolvedTracer]
+	}
+
+	// resolvedTracer is the tracer obtained from next, the provider
+	// set when it was resolved. Each swap stores a new pointer in
+	// lazyTracerProvider, so comparing next with the current one
+	// tells whether the tracer must be resolved again.
+	resolvedTracer struct {
+		next   *trace.TracerProvider
+		tracer trace.Tracer
+	}
+)
+
+var (
+	_ trace.TracerProvider = (*lazyTracerProvider)(nil)
+	_ trace.Tracer         = (*lazyTracer)(nil)
+)
+
+func newLazyTracerProvider() *lazyTracerProvider {
+	tp := &lazyTracerProvider{}
+	tp.set(noop.NewTracerProvider())
+
+	return tp
+}
+
+func (tp *lazyTracerProvider) set(next trace.TracerProvider) {
+	tp.next.Store(&next)
+}
+
+func (tp *lazyTracerProvider) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return &lazyTracer{
+		provider: tp,
+		name:     name,
+		options:  options,
+	}
+}
+
+func (t *lazyTracer) Start(
+	ctx context.Context,
+	name string,
+	options ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	return t.tracer().Start(ctx, name, options...)
+}
+
+func (t *lazyTracer) tracer() trace.Tracer {
+	next := t.provider.next.Load()
+
+	resolved := t.resolved.Load()
+	if resolved == nil || resolved.next != next {
+		resolved = &resolvedTracer{
+			next:   next,
+			tracer: (*next).Tracer(t.name, t.options...),
+		}
+		t.resolved.Store(resolved)
+	}
+
+	return resolved.tracer
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type countingTracerProvider struct {
+	embedded.TracerProvider
+
+	calls int
+}
+
+func (tp *countingTracerProvider) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	tp.calls++
+	return noop.NewTracerProvider().Tracer(name, options...)
+}
+
+func TestLazyTracer(t *testing.T) {
+	var (
+		tp     = newLazyTracerProvider()
+		tracer = tp.Tracer("test")
+		first  = &countingTracerProvider{}
+		second = &countingTracerProvider{}
+	)
+
+	tp.set(first)
+	for range 3 {
+		_, span := tracer.Start(context.Background(), "span")
+		span.End()
+	}
+	assert.Equal(t, 1, first.calls)
+
+	tp.set(second)
+	for range 3 {
+		_, span := tracer.Start(context.Background(), "span")
+		span.End()
+	}
+	assert.Equal(t, 1, first.calls)
+	assert.Equal(t, 1, second.calls)
+}
@@ -17,17 +17,23 @@
 package unit
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	stdlog "log"
+	"maps"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -35,11 +41,17 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.gearno.de/kit/log"
+	"go.gearno.de/kit/otelutils"
+	"go.gearno.de/x/panicf"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/sdk/resource"
 	traceSdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/credentials"
 	"sigs.k8s.io/yaml"
 )
 
@@ -49,41 +61,220 @@ type (
 		version     string
 		environment string
 
-		logger *log.Logger
-		config *Config
-		main   Runnable
+		logger    *log.Logger
+		config    *Config
+		runnables []*runnable
+
+		shutdownTimeout time.Duration
+		shutdownSignals []os.Signal
+		metricsGrace    time.Duration
+		healthChecks    healthChecks
+		profiling       bool
+
+		registerer     prometheus.Registerer
+		tracerProvider trace.TracerProvider
+
+		newTracesExporter       func(TracingConfig) (tracesExporter, error)
+		tracesExporterRetryWait time.Duration
+	}
+
+	// tracesExporter is the part of otlptrace.Exporter used by the
+	// unit, so tests can replace it.
+	tracesExporter interface {
+		traceSdk.SpanExporter
+		Start(context.Context) error
+	}
+
+	// Option configures the Unit during initialization.
+	Option func(u *Unit)
+
+	runnable struct {
+		name string
+		main Runnable
 	}
 
+	// filenamesFlag is the value of the cfg-file flag, holding the
+	// configuration files to merge.
+	filenamesFlag []string
+
 	Runnable interface {
 		Run(context.Context, *log.Logger, prometheus.Registerer, trace.TracerProvider) error
 	}
 
+	// Configurable is implemented by runnables having their own
+	// configuration section. GetConfiguration must return a pointer
+	// to the configuration struct.
+	//
+	// The section keyed by the runnable name is loaded from the
+	// configuration files, then overridden by environment variables
+	// prefixed by the runnable name upper-cased with dashes replaced
+	// by underscores. For example, the "listen-addr" field of the
+	// "api-server" runnable is read from API_SERVER_LISTEN_ADDR. The
+	// unit section uses the UNIT prefix, e.g. UNIT_METRICS_ADDR.
+	//
+	// Environment variables take precedence over the configuration
+	// files, later files taking precedence over earlier ones, which
+	// take precedence over the defaults.
 	Configurable interface {
 		GetConfiguration() any
 	}
 
+	// Validator is optionally implemented by Configurable runnables to
+	// check their configuration once loaded. The unit does not start
+	// when Validate returns an error.
+	Validator interface {
+		Validate() error
+	}
+
 	Config struct {
 		Metrics MetricsConfig `json:"metrics"`
 		Tracing TracingConfig `json:"tracing"`
 	}
 
 	MetricsConfig struct {
-		Addr string `json:"addr"`
+		Enabled bool   `json:"enabled"`
+		Addr    string `json:"addr"`
 	}
 
+	// TracingConfig configures the traces exporter.
+	//
+	// Protocol is "grpc" or "http", and Addr defaults to the local
+	// collector port of the protocol, localhost:4317 for grpc and
+	// localhost:4318 for http. An Addr on the conventional port of
+	// the other protocol is rejected.
+	//
+	// Sampler selects the sampling strategy of root spans: "always",
+	// "never" or "ratio", the latter sampling the SamplerRatio
+	// fraction of the traces. The sampler is parent based: spans with
+	// a parent, including the server spans of requests carrying a
+	// traceparent header extracted by the httpserver package, follow
+	// the sampling decision of the parent regardless of the
+	// configured strategy.
+	//
+	// Headers are sent with every export, e.g. to authenticate
+	// against a hosted backend; their values are redacted by
+	// -print-cfg. CAFile is the PEM encoded CA certificate verifying
+	// the backend, and CertFile and KeyFile are the PEM encoded client
+	// certificate and key for mutual TLS. They cannot be used along
+	// with Insecure.
 	TracingConfig struct {
-		Addr          string `json:"addr"`
-		MaxBatchSize  int    `json:"max-batch-size"`
-		BatchTimeout  int    `json:"batch-timeout"`
-		ExportTimeout int    `json:"export-timeout"`
-		MaxQueueSize  int    `json:"max-queue-size"`
+		Enabled       bool              `json:"enabled"`
+		Protocol      string            `json:"protocol"`
+		Addr          string            `json:"addr"`
+		Insecure      bool              `json:"insecure"`
+		Headers       map[string]string `json:"headers"`
+		CAFile        string            `json:"ca-file"`
+		CertFile      string            `json:"cert-file"`
+		KeyFile       string            `json:"key-file"`
+		MaxBatchSize  int               `json:"max-batch-size"`
+		BatchTimeout  int               `json:"batch-timeout"`
+		ExportTimeout int               `json:"export-timeout"`
+		MaxQueueSize  int               `json:"max-queue-size"`
+		Sampler       string            `json:"sampler"`
+		SamplerRatio  float64           `json:"sampler-ratio"`
 	}
 )
 
-func NewUnit(main Runnable, name, version, environment string) *Unit {
-	return &Unit{
+const (
+	// TracingProtocolGRPC exports traces with OTLP over gRPC,
+	// conventionally on port 4317.
+	TracingProtocolGRPC = "grpc"
+
+	// TracingProtocolHTTP exports traces with OTLP over HTTP,
+	// conventionally on port 4318.
+	TracingProtocolHTTP = "http"
+
+	defaultTracingGRPCAddr = "localhost:4317"
+	defaultTracingHTTPAddr = "localhost:4318"
+
+	// TracingSamplerAlways samples all root spans.
+	TracingSamplerAlways = "always"
+
+	// TracingSamplerNever samples no root span.
+	TracingSamplerNever = "never"
+
+	// TracingSamplerRatio samples a fraction of the root spans.
+	TracingSamplerRatio = "ratio"
+
+	redactedValue = "REDACTED"
+
+	tracesExporterMaxRetryWait = time.Minute
+)
+
+// WithShutdownTimeout bounds the graceful shutdown of the unit. Once
+// the timeout elapses, the unit logs the goroutines still running and
+// Run returns an error instead of waiting for them forever. By
+// default, the unit waits without limit.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(u *Unit) {
+		u.shutdownTimeout = d
+	}
+}
+
+// WithShutdownSignals sets the signals triggering the graceful
+// shutdown of the unit, for platforms asking to drain with another
+// signal than SIGTERM. It replaces the default signals, SIGINT and
+// SIGTERM, which are kept when the option is not used.
+//
+// The first signal received cancels the context of the runnables,
+// which must stop accepting new work and return. A second one forces
+// the shutdown: Run returns right away with an error listing the
+// runnables still running, without waiting for them.
+func WithShutdownSignals(sig ...os.Signal) Option {
+	return func(u *Unit) {
+		u.shutdownSignals = sig
+	}
+}
+
+// WithMetricsShutdownGrace keeps the metrics server serving for d
+// once the runnables have stopped, so the final scrape collects the
+// metrics they updated while shutting down. The wait ends early when
+// the shutdown timeout elapses or the shutdown is forced. By default,
+// the metrics server stops along with the runnables.
+func WithMetricsShutdownGrace(d time.Duration) Option {
+	return func(u *Unit) {
+		u.metricsGrace = d
+	}
+}
+
+// WithProfiling serves the net/http/pprof handlers under
+// /debug/pprof/ on the metrics server, which must be enabled. It is
+// disabled by default.
+//
+// Profiles expose the internals of the process, such as the command
+// line and memory contents, and profiling has a runtime cost: the
+// metrics server must only listen on an internal interface when this
+// option is used.
+func WithProfiling() Option {
+	return func(u *Unit) {
+		u.profiling = true
+	}
+}
+
+// WithRegisterer hands r to the runnables instead of the registry of
+// the metrics server, which is not started, for units embedded in a
+// process already exposing its own metrics. The /livez and /readyz
+// endpoints and the profiling handlers of the metrics server are not
+// served either. The metrics configuration is ignored.
+func WithRegisterer(r prometheus.Registerer) Option {
+	return func(u *Unit) {
+		u.registerer = r
+	}
+}
+
+// WithTracerProvider hands tp to the runnables instead of the tracer
+// provider of the traces exporter, which is not started, for units
+// embedded in a process already exporting its own traces. The tracing
+// configuration is ignored.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(u *Unit) {
+		u.tracerProvider = tp
+	}
+}
+
+func NewUnit(main Runnable, name, version, environment string, options ...Option) *Unit {
+	u := &Unit{
 		name: name,
-		main: main,
 		logger: log.NewLogger(
 			log.WithName(name),
 			log.WithAttributes(
@@ -93,17 +284,56 @@ func NewUnit(main Runnable, name, version, environment string) *Unit {
 		),
 		config: &Config{
 			Metrics: MetricsConfig{
-				Addr: ":9090",
+				Enabled: true,
+				Addr:    ":9090",
 			},
 			Tracing: TracingConfig{
-				Addr:          ":4317",
+				Enabled:       true,
+				Protocol:      TracingProtocolHTTP,
 				MaxBatchSize:  1024,
 				BatchTimeout:  10,
 				ExportTimeout: 15,
 				MaxQueueSize:  5000,
+				Sampler:       TracingSamplerAlways,
+				SamplerRatio:  1,
 			},
 		},
+		newTracesExporter: func(config TracingConfig) (tracesExporter, error) {
+			return newTracesExporter(config)
+		},
+		tracesExporterRetryWait: time.Second,
+	}
+
+	for _, o := range options {
+		o(u)
+	}
+
+	u.Register(name, main)
+
+	return u
+}
+
+// Register adds a Runnable to the unit. All registered runnables
+// start concurrently and share the unit logger, metrics registry and
+// tracer provider. When one of them returns an error, the others are
+// cancelled and the unit shuts down. If the Runnable implements
+// Configurable, its configuration is loaded from the section keyed by
+// name.
+//
+// Register must be called before Run and panics if the name is
+// already used.
+func (u *Unit) Register(name string, r Runnable) {
+	if name == "unit" {
+		panicf.Panic("cannot register runnable: %q is reserved", name)
+	}
+
+	for _, existing := range u.runnables {
+		if existing.name == name {
+			panicf.Panic("cannot register runnable: %q already registered", name)
+		}
 	}
+
+	u.runnables = append(u.runnables, &runnable{name: name, main: r})
 }
 
 func (u *Unit) Run() error {
@@ -111,8 +341,14 @@ func (u *Unit) Run() error {
 }
 
 func (u *Unit) RunContext(parentCtx context.Context) error {
-	filename := flag.String("cfg-file", "", "the path of the configuration file")
+	var filenames filenamesFlag
+	flag.Var(
+		&filenames,
+		"cfg-file",
+		"the path of the configuration file, can be repeated or comma separated to merge several files in order",
+	)
 	printCfg := flag.Bool("print-cfg", false, "print the loaded cfg and exit")
+	validate := flag.Bool("validate", false, "validate the loaded cfg, print the problems found and exit")
 	help := flag.Bool("help", false, "show this help message")
 	version := flag.Bool("version", false, "show the service version")
 
@@ -128,16 +364,20 @@ func (u *Unit) RunContext(parentCtx context.Context) error {
 		return nil
 	}
 
-	if *filename != "" {
-		if err := u.loadConfigurationFromFile(*filename); err != nil {
-			return fmt.Errorf("cannot load configuration from %q file: %w", *filename, err)
-		}
+	if *validate {
+		return u.validate(os.Stdout, filenames)
+	}
+
+	if err := u.loadConfiguration(filenames); err != nil {
+		return err
 	}
 
 	if *printCfg {
-		config := map[string]any{"unit": u.config}
-		if configurable, ok := u.main.(Configurable); ok {
-			config[u.name] = configurable.GetConfiguration()
+		config := map[string]any{"unit": u.config.redacted()}
+		for _, r := range u.runnables {
+			if configurable, ok := r.main.(Configurable); ok {
+				config[r.name] = configurable.GetConfiguration()
+			}
 		}
 
 		encoder := json.NewEncoder(os.Stdout)
@@ -150,76 +390,259 @@ func (u *Unit) RunContext(parentCtx context.Context) error {
 		return nil
 	}
 
+	if err := u.validateConfiguration(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return u.run(parentCtx)
+}
+
+func (u *Unit) loadConfiguration(filenames []string) error {
+	if len(filenames) > 0 {
+		if err := u.loadConfigurationFromFiles(filenames...); err != nil {
+			return fmt.Errorf("cannot load configuration from files: %w", err)
+		}
+	}
+
+	if err := u.loadConfigurationFromEnv(); err != nil {
+		return fmt.Errorf("cannot load configuration from environment: %w", err)
+	}
+
+	u.config.Tracing.setDefaultAddr()
+
+	return nil
+}
+
+// setDefaultAddr sets the address of the traces exporter, when not
+// configured, to the local collector port of the protocol.
+func (c *TracingConfig) setDefaultAddr() {
+	if c.Addr != "" {
+		return
+	}
+
+	switch c.Protocol {
+	case TracingProtocolGRPC:
+		c.Addr = defaultTracingGRPCAddr
+	case TracingProtocolHTTP:
+		c.Addr = defaultTracingHTTPAddr
+	}
+}
+
+func (u *Unit) run(parentCtx context.Context) error {
 	logger := u.logger.Named("unit")
 
 	ctx, cancel := context.WithCancelCause(parentCtx)
 	defer cancel(context.Canceled)
 
-	wg := sync.WaitGroup{}
-	metricsInitialized := make(chan prometheus.Registerer)
-	tracingInitialized := make(chan trace.TracerProvider)
+	forced, stopSignals := u.notifyShutdown(ctx, logger, cancel)
+	defer stopSignals()
+
+	var (
+		telemetry          = newGroup()
+		runnables          = newGroup()
+		metricsInitialized = make(chan prometheus.Registerer)
+		tracingInitialized = make(chan struct{})
+	)
 
 	metricsServerCtx, stopMetricsServer := context.WithCancel(context.Background())
 	defer stopMetricsServer()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := u.runMetricsServer(metricsServerCtx, metricsInitialized); err != nil {
-			cancel(fmt.Errorf("metrics server crashed: %w", err))
+	var registry prometheus.Registerer = prometheus.NewPedanticRegistry()
+	if u.registerer != nil {
+		registry = u.registerer
+		logger.Info("metrics server disabled, using the provided registerer")
+
+		if u.profiling {
+			logger.Warn("profiling requires the metrics server, profiling disabled")
 		}
+	} else if u.config.Metrics.Enabled {
+		telemetry.Go("metrics server", func() {
+			if err := u.runMetricsServer(metricsServerCtx, metricsInitialized); err != nil {
+				cancel(fmt.Errorf("metrics server crashed: %w", err))
+			}
 
-		logger.Info("metrics server shutdown")
-	}()
+			logger.Info("metrics server shutdown")
+		})
+
+		select {
+		case registry = <-metricsInitialized:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	} else {
+		logger.Info("metrics server disabled")
+
+		if u.profiling {
+			logger.Warn("profiling requires the metrics server, profiling disabled")
+		}
+	}
 
 	tracingExporterCtx, stopTracingExporter := context.WithCancel(context.Background())
 	defer stopTracingExporter()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := u.runTracingExporter(tracingExporterCtx, tracingInitialized); err != nil {
-			cancel(fmt.Errorf("traces exporter crashed: %w", err))
+	// The runnables get a provider swapped to the one of the traces
+	// exporter once started, so a collector outage does not prevent
+	// the unit from starting. The first start attempt is awaited so
+	// the spans of the runnables are recorded from the beginning in
+	// the common case.
+	var traceProvider trace.TracerProvider = noop.NewTracerProvider()
+	if u.tracerProvider != nil {
+		traceProvider = u.tracerProvider
+		logger.Info("traces exporter disabled, using the provided tracer provider")
+	} else if u.config.Tracing.Enabled {
+		lazyTraceProvider := newLazyTracerProvider()
+		traceProvider = lazyTraceProvider
+
+		telemetry.Go("traces exporter", func() {
+			if err := u.runTracingExporter(tracingExporterCtx, lazyTraceProvider, tracingInitialized); err != nil {
+				cancel(fmt.Errorf("traces exporter crashed: %w", err))
+			}
+
+			logger.Info("traces exporter shutdown")
+		})
+
+		select {
+		case <-tracingInitialized:
+		case <-ctx.Done():
+			return context.Cause(ctx)
 		}
+	} else {
+		logger.Info("traces exporter disabled")
+	}
 
-		logger.Info("metrics server shutdown")
-	}()
+	for _, r := range u.runnables {
+		runnables.Go(r.name, func() {
+			if err := r.main.Run(ctx, u.logger, registry, traceProvider); err != nil {
+				cancel(fmt.Errorf("%s crashed: %w", r.name, err))
+			}
+		})
+	}
 
-	var registry prometheus.Registerer
-	var traceProvider trace.TracerProvider
+	<-ctx.Done()
 
-	select {
-	case registry = <-metricsInitialized:
-	case <-ctx.Done():
-		return context.Cause(ctx)
+	var deadline <-chan time.Time
+	if u.shutdownTimeout > 0 {
+		timer := time.NewTimer(u.shutdownTimeout)
+		defer timer.Stop()
+		deadline = timer.C
 	}
 
-	select {
-	case traceProvider = <-tracingInitialized:
-	case <-ctx.Done():
-		return context.Cause(ctx)
+	// Runnables are stopped before the telemetry so the spans and
+	// metrics they produce while stopping are still exported.
+	logger.Info("stopping runnables")
+	if running := runnables.Wait(deadline, forced); len(running) > 0 {
+		stopMetricsServer()
+		stopTracingExporter()
+
+		return u.shutdownError(logger, forced, running)
+	}
+
+	// The traces exporter flushes the remaining spans when stopped;
+	// the metrics are pulled, so the metrics server is kept for the
+	// grace period to let the final scrape happen.
+	stopTracingExporter()
+
+	if u.registerer == nil && u.config.Metrics.Enabled && u.metricsGrace > 0 {
+		logger.Info("waiting for the final metrics scrape", log.Duration("grace", u.metricsGrace))
+
+		grace := time.NewTimer(u.metricsGrace)
+		defer grace.Stop()
+
+		select {
+		case <-grace.C:
+		case t := <-deadline:
+			// The timeout elapsed; the telemetry is not waited for
+			// either.
+			expired := make(chan time.Time, 1)
+			expired <- t
+			deadline = expired
+		case <-forced:
+		}
 	}
 
-	wg.Add(1)
+	stopMetricsServer()
+
+	if running := telemetry.Wait(deadline, forced); len(running) > 0 {
+		return u.shutdownError(logger, forced, running)
+	}
+
+	return context.Cause(ctx)
+}
+
+// notifyShutdown cancels the unit on the first shutdown signal
+// received and closes the returned channel on the second one, forcing
+// the shutdown. Signals are handled until the returned function is
+// called.
+func (u *Unit) notifyShutdown(
+	ctx context.Context,
+	logger *log.Logger,
+	cancel context.CancelCauseFunc,
+) (<-chan struct{}, func()) {
+	signals := u.shutdownSignals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	var (
+		ch     = make(chan os.Signal, 1)
+		forced = make(chan struct{})
+		done   = make(chan struct{})
+	)
+
+	signal.Notify(ch, signals...)
+
 	go func() {
-		defer wg.Done()
+		defer signal.Stop(ch)
+
+		select {
+		case sig := <-ch:
+			logger.Info("shutdown signal received", log.String("signal", sig.String()))
+			cancel(fmt.Errorf("%s signal received", sig))
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
 
-		if err := u.main.Run(ctx, u.logger, registry, traceProvider); err != nil {
-			cancel(err)
+		select {
+		case sig := <-ch:
+			logger.Warn(
+				"second shutdown signal received, forcing shutdown",
+				log.String("signal", sig.String()),
+			)
+			close(forced)
+		case <-done:
 		}
 	}()
 
-	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
-	defer stop()
-
-	<-ctx.Done()
+	return forced, func() { close(done) }
+}
 
-	stopMetricsServer()
-	stopTracingExporter()
+func (u *Unit) shutdownError(logger *log.Logger, forced <-chan struct{}, running []string) error {
+	select {
+	case <-forced:
+		logger.Error("shutdown forced", log.Any("running", running))
+
+		return fmt.Errorf(
+			"cannot shutdown gracefully: shutdown forced, %s still running",
+			strings.Join(running, ", "),
+		)
+	default:
+		return u.shutdownTimeoutError(logger, running)
+	}
+}
 
-	wg.Wait()
+func (u *Unit) shutdownTimeoutError(logger *log.Logger, running []string) error {
+	logger.Error(
+		"shutdown timeout exceeded",
+		log.Duration("timeout", u.shutdownTimeout),
+		log.Any("running", running),
+	)
 
-	return context.Cause(ctx)
+	return fmt.Errorf(
+		"cannot shutdown within %s: %s still running",
+		u.shutdownTimeout,
+		strings.Join(running, ", "),
+	)
 }
 
 func (u *Unit) runMetricsServer(ctx context.Context, initialized chan<- prometheus.Registerer) error {
@@ -239,16 +662,18 @@ func (u *Unit) runMetricsServer(ctx context.Context, initialized chan<- promethe
 	)
 
 	httpServer := &http.Server{
-		Addr: u.config.Metrics.Addr,
-		Handler: http.TimeoutHandler(
-			metricsHandler,
-			5*time.Second,
-			"request timed out",
-		),
+		Addr:         u.config.Metrics.Addr,
+		Handler:      u.metricsServerHandler(metricsHandler),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 	}
 
+	if u.profiling {
+		// CPU profiles and execution traces are streamed for a
+		// client chosen duration, 30 seconds by default.
+		httpServer.WriteTimeout = 0
+	}
+
 	logger.Info("starting metrics server", log.String("addr", httpServer.Addr))
 	listener, err := net.Listen("tcp", httpServer.Addr)
 	if err != nil {
@@ -287,30 +712,67 @@ func (u *Unit) runMetricsServer(ctx context.Context, initialized chan<- promethe
 	return ctx.Err()
 }
 
-func (u *Unit) runTracingExporter(ctx context.Context, initialized chan<- trace.TracerProvider) error {
+func (u *Unit) metricsServerHandler(metricsHandler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", livenessHandler)
+	mux.HandleFunc("/readyz", u.healthChecks.readinessHandler)
+	mux.Handle("/", metricsHandler)
+
+	handler := http.TimeoutHandler(
+		mux,
+		5*time.Second,
+		"request timed out",
+	)
+
+	if !u.profiling {
+		return handler
+	}
+
+	root := http.NewServeMux()
+	root.HandleFunc("/debug/pprof/", pprof.Index)
+	root.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	root.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	root.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	root.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	root.Handle("/", handler)
+
+	return root
+}
+
+// runTracingExporter starts the traces exporter and swaps provider to
+// its tracer provider. Starting the exporter is retried until ctx is
+// done, initialized being closed after the first attempt.
+func (u *Unit) runTracingExporter(
+	ctx context.Context,
+	provider *lazyTracerProvider,
+	initialized chan<- struct{},
+) error {
 	logger := u.logger.Named("unit.metrics")
 	config := u.config.Tracing
 
-	logger.InfoCtx(ctx, "starting traces exporter", log.String("addr", config.Addr))
-
-	exporter := otlptracehttp.NewUnstarted(
-		otlptracehttp.WithCompression(otlptracehttp.GzipCompression),
-		otlptracehttp.WithRetry(
-			otlptracehttp.RetryConfig{
-				Enabled:         true,
-				InitialInterval: 500 * time.Millisecond,
-				MaxInterval:     5 * time.Second,
-				MaxElapsedTime:  5 * time.Minute,
-			},
-		),
-		otlptracehttp.WithTimeout(15*time.Second),
+	logger.InfoCtx(
+		ctx,
+		"starting traces exporter",
+		log.String("protocol", config.Protocol),
+		log.String("addr", config.Addr),
 	)
 
-	if err := exporter.Start(ctx); err != nil {
-		return fmt.Errorf("cannot create otel exporter: %w", err)
+	sampler, err := newSampler(config)
+	if err != nil {
+		return fmt.Errorf("cannot create sampler: %w", err)
+	}
+
+	closeInitialized := sync.OnceFunc(func() { close(initialized) })
+
+	exporter, err := u.startTracesExporter(ctx, logger, closeInitialized)
+	if err != nil {
+		return err
 	}
 
 	traceProvider := traceSdk.NewTracerProvider(
+		// The override sampler honors the sampling decisions
+		// forced by the instrumentation, e.g. per HTTP route.
+		traceSdk.WithSampler(otelutils.NewOverrideSampler(sampler)),
 		traceSdk.WithBatcher(
 			exporter,
 			traceSdk.WithMaxExportBatchSize(config.MaxBatchSize),
@@ -328,7 +790,11 @@ func (u *Unit) runTracingExporter(ctx context.Context, initialized chan<- trace.
 		),
 	)
 
-	initialized <- traceProvider
+	// OTLP exporters reject a whole batch as soon as one string is
+	// not valid UTF-8, which happens easily with user input stored in
+	// span attributes.
+	provider.set(otelutils.WrapTracerProvider(traceProvider))
+	closeInitialized()
 
 	logger.Info("trace exporter started")
 
@@ -354,40 +820,299 @@ func (u *Unit) runTracingExporter(ctx context.Context, initialized chan<- trace.
 	return ctx.Err()
 }
 
-func (u *Unit) loadConfigurationFromFile(filename string) error {
-	file, err := os.Open(filename)
+// startTracesExporter creates and starts the traces exporter, retrying
+// with an exponential backoff while it fails to start. Configuration
+// errors are not retried. failed is called when an attempt fails, so
+// the runnables can start without traces in the meantime.
+func (u *Unit) startTracesExporter(
+	ctx context.Context,
+	logger *log.Logger,
+	failed func(),
+) (tracesExporter, error) {
+	wait := u.tracesExporterRetryWait
+
+	for attempt := 1; ; attempt++ {
+		// An exporter can only be started once, a new one is
+		// created for every attempt.
+		exporter, err := u.newTracesExporter(u.config.Tracing)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create otel exporter: %w", err)
+		}
+
+		err = exporter.Start(ctx)
+		if err == nil {
+			return exporter, nil
+		}
+
+		logger.WarnCtx(
+			ctx,
+			"cannot start traces exporter, spans are dropped until it starts",
+			log.Int("attempt", attempt),
+			log.Duration("retry_in", wait),
+			log.Error(err),
+		)
+
+		failed()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait = min(2*wait, tracesExporterMaxRetryWait)
+	}
+}
+
+func newSampler(config TracingConfig) (traceSdk.Sampler, error) {
+	switch config.Sampler {
+	case TracingSamplerAlways:
+		return traceSdk.ParentBased(traceSdk.AlwaysSample()), nil
+	case TracingSamplerNever:
+		return traceSdk.ParentBased(traceSdk.NeverSample()), nil
+	case TracingSamplerRatio:
+		if config.SamplerRatio < 0 || config.SamplerRatio > 1 {
+			return nil, fmt.Errorf("sampler ratio must be between 0 and 1, got %v", config.SamplerRatio)
+		}
+
+		return traceSdk.ParentBased(traceSdk.TraceIDRatioBased(config.SamplerRatio)), nil
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", config.Sampler)
+	}
+}
+
+func newTracesExporter(config TracingConfig) (*otlptrace.Exporter, error) {
+	tlsConfig, err := newTracesExporterTLSConfig(config)
 	if err != nil {
-		return fmt.Errorf("cannot open file: %w", err)
+		return nil, fmt.Errorf("invalid tls configuration: %w", err)
 	}
 
-	blob, err := io.ReadAll(file)
+	switch config.Protocol {
+	case TracingProtocolGRPC:
+		options := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(config.Addr),
+			otlptracegrpc.WithCompressor("gzip"),
+			otlptracegrpc.WithRetry(
+				otlptracegrpc.RetryConfig{
+					Enabled:         true,
+					InitialInterval: 500 * time.Millisecond,
+					MaxInterval:     5 * time.Second,
+					MaxElapsedTime:  5 * time.Minute,
+				},
+			),
+			otlptracegrpc.WithTimeout(15 * time.Second),
+		}
+
+		if config.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+
+		if len(config.Headers) > 0 {
+			options = append(options, otlptracegrpc.WithHeaders(config.Headers))
+		}
+
+		if tlsConfig != nil {
+			options = append(options, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+		}
+
+		return otlptracegrpc.NewUnstarted(options...), nil
+	case TracingProtocolHTTP:
+		options := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(config.Addr),
+			otlptracehttp.WithCompression(otlptracehttp.GzipCompression),
+			otlptracehttp.WithRetry(
+				otlptracehttp.RetryConfig{
+					Enabled:         true,
+					InitialInterval: 500 * time.Millisecond,
+					MaxInterval:     5 * time.Second,
+					MaxElapsedTime:  5 * time.Minute,
+				},
+			),
+			otlptracehttp.WithTimeout(15 * time.Second),
+		}
+
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		if len(config.Headers) > 0 {
+			options = append(options, otlptracehttp.WithHeaders(config.Headers))
+		}
+
+		if tlsConfig != nil {
+			options = append(options, otlptracehttp.WithTLSClientConfig(tlsConfig))
+		}
+
+		return otlptracehttp.NewUnstarted(options...), nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", config.Protocol)
+	}
+}
+
+// newTracesExporterTLSConfig returns the TLS configuration of the
+// traces exporter, or nil when the exporter uses the system defaults.
+func newTracesExporterTLSConfig(config TracingConfig) (*tls.Config, error) {
+	if config.CAFile == "" && config.CertFile == "" && config.KeyFile == "" {
+		return nil, nil
+	}
+
+	if config.Insecure {
+		return nil, fmt.Errorf("cannot use tls files with an insecure exporter")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ca file: %w", err)
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cannot parse ca file %q: no valid certificate", config.CAFile)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// redacted returns a copy of the configuration safe to print, with
+// the secret values replaced.
+func (c *Config) redacted() *Config {
+	c2 := *c
+
+	if c.Tracing.Headers != nil {
+		c2.Tracing.Headers = make(map[string]string, len(c.Tracing.Headers))
+		for k := range c.Tracing.Headers {
+			c2.Tracing.Headers[k] = redactedValue
+		}
+	}
+
+	return &c2
+}
+
+// loadConfigurationFromFiles loads the configuration from the given
+// YAML files, deep merged in order: maps are merged key by key while
+// the other values, including arrays, of a later file replace the
+// ones of the earlier files.
+func (u *Unit) loadConfigurationFromFiles(filenames ...string) error {
+	config := map[string]any{}
+	for _, filename := range filenames {
+		overlay, err := readConfigurationFile(filename)
+		if err != nil {
+			return fmt.Errorf("cannot load %q file: %w", filename, err)
+		}
+
+		mergeConfiguration(config, overlay)
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(config)) {
+		section := config[name]
+
+		if name == "unit" {
+			if err := decodeConfigurationSection(section, u.config); err != nil {
+				errs = append(errs, fmt.Errorf("cannot decode %q config section: %w", name, err))
+			}
+
+			continue
+		}
+
+		i := slices.IndexFunc(u.runnables, func(r *runnable) bool { return r.name == name })
+		if i == -1 {
+			errs = append(errs, fmt.Errorf("unknown %q config section", name))
+			continue
+		}
+
+		configurable, ok := u.runnables[i].main.(Configurable)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unexpected %q config section: runnable is not configurable", name))
+			continue
+		}
+
+		if err := decodeConfigurationSection(section, configurable.GetConfiguration()); err != nil {
+			errs = append(errs, fmt.Errorf("cannot decode %q config section: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// decodeConfigurationSection decodes section into v, rejecting the
+// keys not matching any field of v so typos do not go unnoticed.
+func decodeConfigurationSection(section any, v any) error {
+	encoded, _ := json.Marshal(section)
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+func readConfigurationFile(filename string) (map[string]any, error) {
+	blob, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("cannot read file: %w", err)
+		return nil, fmt.Errorf("cannot read file: %w", err)
 	}
 
 	blob, err = yaml.YAMLToJSON(blob)
 	if err != nil {
-		return fmt.Errorf("cannot convert yaml to json: %w", err)
+		return nil, fmt.Errorf("cannot convert yaml to json: %w", err)
 	}
 
 	config := map[string]any{}
 	if err := json.Unmarshal(blob, &config); err != nil {
-		return fmt.Errorf("cannot decode file: %w", err)
+		return nil, fmt.Errorf("cannot decode file: %w", err)
 	}
 
-	if _, ok := config["unit"]; ok {
-		encoded, _ := json.Marshal(config["unit"])
-		if err := json.Unmarshal(encoded, u.config); err != nil {
-			return fmt.Errorf("cannot decode %q config section: %w", "uniq", err)
+	return config, nil
+}
+
+// mergeConfiguration deep merges src into dst. Maps present on both
+// sides are merged recursively, any other src value replaces the dst
+// one.
+func mergeConfiguration(dst, src map[string]any) {
+	for k, v := range src {
+		srcMap, ok := v.(map[string]any)
+		if !ok {
+			dst[k] = v
+			continue
 		}
+
+		dstMap, ok := dst[k].(map[string]any)
+		if !ok {
+			dstMap = map[string]any{}
+			dst[k] = dstMap
+		}
+
+		mergeConfiguration(dstMap, srcMap)
 	}
+}
 
-	if configurable, ok := u.main.(Configurable); ok {
-		if _, ok := config[u.name]; ok {
-			encoded, _ := json.Marshal(config[u.name])
-			if err := json.Unmarshal(encoded, configurable.GetConfiguration()); err != nil {
-				return fmt.Errorf("cannot decode %q config section: %w", u.name, err)
-			}
+// String implements flag.Value.
+func (f *filenamesFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set implements flag.Value, accepting a comma separated list of
+// filenames and appending them to the ones of the previous
+// occurrences of the flag.
+func (f *filenamesFlag) Set(value string) error {
+	for _, filename := range strings.Split(value, ",") {
+		if filename = strings.TrimSpace(filename); filename != "" {
+			*f = append(*f, filename)
 		}
 	}
 
@@ -0,0 +1,739 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.gearno.de/kit/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	testService struct {
+		config testServiceConfig
+	}
+
+	testServiceConfig struct {
+		Greeting string `json:"greeting"`
+	}
+)
+
+func (s *testService) GetConfiguration() any {
+	return &s.config
+}
+
+func (s *testService) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	_ prometheus.Registerer,
+	_ trace.TracerProvider,
+) error {
+	<-ctx.Done()
+	return nil
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(filename, []byte(content), 0o600)
+	assert.NoError(t, err)
+
+	return filename
+}
+
+func TestLoadConfigurationFromFile(t *testing.T) {
+	filename := writeConfigFile(t, `
+unit:
+  metrics:
+    addr: ":9191"
+test-service:
+  greeting: "hello"
+`)
+
+	svc := &testService{}
+	u := NewUnit(svc, "test-service", "1.0.0", "test")
+
+	err := u.loadConfiguration([]string{filename})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9191", u.config.Metrics.Addr)
+	assert.Equal(t, "localhost:4318", u.config.Tracing.Addr)
+	assert.Equal(t, "hello", svc.config.Greeting)
+}
+
+func TestLoadConfigurationFromFileMultipleRunnables(t *testing.T) {
+	filename := writeConfigFile(t, `
+test-service:
+  greeting: "hello"
+test-worker:
+  greeting: "bonjour"
+`)
+
+	svc := &testService{}
+	worker := &testService{}
+	u := NewUnit(svc, "test-service", "1.0.0", "test")
+	u.Register("test-worker", worker)
+
+	err := u.loadConfigurationFromFiles(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", svc.config.Greeting)
+	assert.Equal(t, "bonjour", worker.config.Greeting)
+}
+
+func TestLoadConfigurationFromFilesOverlay(t *testing.T) {
+	base := writeConfigFile(t, `
+unit:
+  metrics:
+    addr: ":9191"
+  tracing:
+    addr: "collector:4318"
+    headers:
+      x-team: "core"
+      x-env: "dev"
+test-service:
+  greeting: "hello"
+`)
+	overlay := writeConfigFile(t, `
+unit:
+  tracing:
+    headers:
+      x-env: "prod"
+test-service:
+  greeting: "bonjour"
+`)
+
+	svc := &testService{}
+	u := NewUnit(svc, "test-service", "1.0.0", "test")
+
+	err := u.loadConfigurationFromFiles(base, overlay)
+	assert.NoError(t, err)
+	assert.Equal(t, ":9191", u.config.Metrics.Addr)
+	assert.Equal(t, "collector:4318", u.config.Tracing.Addr)
+	assert.Equal(
+		t,
+		map[string]string{"x-team": "core", "x-env": "prod"},
+		u.config.Tracing.Headers,
+	)
+	assert.Equal(t, "bonjour", svc.config.Greeting)
+}
+
+func TestMergeConfiguration(t *testing.T) {
+	dst := map[string]any{
+		"map":    map[string]any{"a": 1.0, "b": 2.0},
+		"array":  []any{1.0, 2.0},
+		"scalar": "base",
+		"kept":   true,
+		"mixed":  map[string]any{"a": 1.0},
+	}
+
+	mergeConfiguration(
+		dst,
+		map[string]any{
+			"map":    map[string]any{"b": 3.0, "c": 4.0},
+			"array":  []any{3.0},
+			"scalar": "overlay",
+			"mixed":  "replaced",
+			"added":  map[string]any{"a": 1.0},
+		},
+	)
+
+	assert.Equal(
+		t,
+		map[string]any{
+			"map":    map[string]any{"a": 1.0, "b": 3.0, "c": 4.0},
+			"array":  []any{3.0},
+			"scalar": "overlay",
+			"kept":   true,
+			"mixed":  "replaced",
+			"added":  map[string]any{"a": 1.0},
+		},
+		dst,
+	)
+}
+
+func TestFilenamesFlag(t *testing.T) {
+	var f filenamesFlag
+
+	assert.NoError(t, f.Set("base.yaml"))
+	assert.NoError(t, f.Set("env.yaml, secrets.yaml,"))
+
+	assert.Equal(t, filenamesFlag{"base.yaml", "env.yaml", "secrets.yaml"}, f)
+	assert.Equal(t, "base.yaml,env.yaml,secrets.yaml", f.String())
+}
+
+func TestRegisterDuplicateName(t *testing.T) {
+	u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+
+	assert.Panics(t, func() { u.Register("test-service", &testService{}) })
+	assert.Panics(t, func() { u.Register("unit", &testService{}) })
+}
+
+func TestNewTracesExporter(t *testing.T) {
+	for _, protocol := range []string{TracingProtocolGRPC, TracingProtocolHTTP} {
+		exporter, err := newTracesExporter(TracingConfig{Protocol: protocol, Addr: "localhost:4317"})
+		assert.NoError(t, err)
+		assert.NotNil(t, exporter)
+	}
+
+	_, err := newTracesExporter(TracingConfig{Protocol: "thrift"})
+	assert.Error(t, err)
+}
+
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestNewTracesExporterTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	tlsConfig, err := newTracesExporterTLSConfig(TracingConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	tlsConfig, err = newTracesExporterTLSConfig(
+		TracingConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile},
+	)
+	require.NoError(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+
+	for _, protocol := range []string{TracingProtocolGRPC, TracingProtocolHTTP} {
+		exporter, err := newTracesExporter(
+			TracingConfig{Protocol: protocol, Addr: "localhost:4317", CAFile: certFile},
+		)
+		assert.NoError(t, err)
+		assert.NotNil(t, exporter)
+	}
+
+	_, err = newTracesExporterTLSConfig(TracingConfig{CAFile: certFile, Insecure: true})
+	assert.Error(t, err)
+
+	_, err = newTracesExporterTLSConfig(TracingConfig{CAFile: keyFile})
+	assert.Error(t, err)
+
+	_, err = newTracesExporterTLSConfig(TracingConfig{CertFile: certFile})
+	assert.Error(t, err)
+}
+
+func TestConfigRedacted(t *testing.T) {
+	config := &Config{
+		Tracing: TracingConfig{
+			Addr:    "api.vendor.com:443",
+			Headers: map[string]string{"api-key": "secret"},
+		},
+	}
+
+	redacted := config.redacted()
+	assert.Equal(t, map[string]string{"api-key": "REDACTED"}, redacted.Tracing.Headers)
+	assert.Equal(t, "api.vendor.com:443", redacted.Tracing.Addr)
+	assert.Equal(t, "secret", config.Tracing.Headers["api-key"])
+}
+
+type recordingService struct {
+	registerer     prometheus.Registerer
+	tracerProvider trace.TracerProvider
+}
+
+func (s *recordingService) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	r prometheus.Registerer,
+	tp trace.TracerProvider,
+) error {
+	s.registerer = r
+	s.tracerProvider = tp
+
+	return errors.New("stop")
+}
+
+func TestRunWithTelemetryDisabled(t *testing.T) {
+	svc := &recordingService{}
+	u := NewUnit(svc, "test-service", "1.0.0", "test")
+	u.config.Metrics.Enabled = false
+	u.config.Tracing.Enabled = false
+
+	err := u.run(context.Background())
+	assert.ErrorContains(t, err, "stop")
+	assert.NotNil(t, svc.registerer)
+	assert.NotNil(t, svc.tracerProvider)
+
+	_, span := svc.tracerProvider.Tracer("test").Start(context.Background(), "test")
+	assert.False(t, span.IsRecording())
+}
+
+type embeddingTracerProvider struct {
+	trace.TracerProvider
+}
+
+func TestRunWithProvidedTelemetry(t *testing.T) {
+	var (
+		svc            = &recordingService{}
+		registerer     = prometheus.NewRegistry()
+		tracerProvider = &embeddingTracerProvider{}
+	)
+
+	u := NewUnit(
+		svc,
+		"test-service",
+		"1.0.0",
+		"test",
+		WithRegisterer(registerer),
+		WithTracerProvider(tracerProvider),
+	)
+	u.config.Metrics.Addr = "invalid address"
+	u.newTracesExporter = func(TracingConfig) (tracesExporter, error) {
+		t.Error("traces exporter created")
+		return nil, errors.New("unexpected traces exporter")
+	}
+
+	err := u.run(context.Background())
+	assert.EqualError(t, err, "test-service crashed: stop")
+	assert.Same(t, registerer, svc.registerer)
+	assert.Same(t, tracerProvider, svc.tracerProvider)
+}
+
+type stubbornService struct {
+	release chan struct{}
+}
+
+func (s *stubbornService) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	_ prometheus.Registerer,
+	_ trace.TracerProvider,
+) error {
+	<-s.release
+	return nil
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	svc := &stubbornService{release: make(chan struct{})}
+	t.Cleanup(func() { close(svc.release) })
+
+	u := NewUnit(svc, "test-service", "1.0.0", "test", WithShutdownTimeout(50*time.Millisecond))
+	u.config.Metrics.Enabled = false
+	u.config.Tracing.Enabled = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := u.run(ctx)
+	assert.ErrorContains(t, err, "test-service still running")
+}
+
+type countingService struct {
+	stopped prometheus.Counter
+}
+
+func (s *countingService) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	r prometheus.Registerer,
+	_ trace.TracerProvider,
+) error {
+	s.stopped = prometheus.NewCounter(prometheus.CounterOpts{Name: "test_stopped_total", Help: "Stopped."})
+	if err := r.Register(s.stopped); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	s.stopped.Inc()
+
+	return nil
+}
+
+func TestRunMetricsShutdownGrace(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	svc := &countingService{}
+	u := NewUnit(svc, "test-service", "1.0.0", "test", WithMetricsShutdownGrace(time.Second))
+	u.config.Metrics.Addr = addr
+	u.config.Tracing.Enabled = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- u.run(ctx) }()
+
+	scrape := func() (string, error) {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	require.Eventually(
+		t,
+		func() bool {
+			body, err := scrape()
+			return err == nil && strings.Contains(body, "test_stopped_total 0")
+		},
+		5*time.Second,
+		10*time.Millisecond,
+	)
+
+	cancel()
+	cancelled := time.Now()
+
+	// The metrics updated by the service while stopping can still be
+	// scraped.
+	require.Eventually(
+		t,
+		func() bool {
+			body, err := scrape()
+			return err == nil && strings.Contains(body, "test_stopped_total 1")
+		},
+		5*time.Second,
+		10*time.Millisecond,
+	)
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.GreaterOrEqual(t, time.Since(cancelled), time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("unit not stopped after the grace period")
+	}
+}
+
+type drainingService struct {
+	started  chan struct{}
+	stopping chan struct{}
+	release  chan struct{}
+}
+
+func (s *drainingService) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	_ prometheus.Registerer,
+	_ trace.TracerProvider,
+) error {
+	close(s.started)
+	<-ctx.Done()
+	close(s.stopping)
+	<-s.release
+	return nil
+}
+
+func TestRunShutdownSignals(t *testing.T) {
+	signalSelf := func(t *testing.T, sig os.Signal) {
+		t.Helper()
+
+		p, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		require.NoError(t, p.Signal(sig))
+	}
+
+	newService := func() *drainingService {
+		return &drainingService{
+			started:  make(chan struct{}),
+			stopping: make(chan struct{}),
+			release:  make(chan struct{}),
+		}
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		svc := newService()
+		u := NewUnit(svc, "test-service", "1.0.0", "test", WithShutdownSignals(syscall.SIGUSR1))
+		u.config.Metrics.Enabled = false
+		u.config.Tracing.Enabled = false
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- u.run(context.Background()) }()
+
+		<-svc.started
+		signalSelf(t, syscall.SIGUSR1)
+		<-svc.stopping
+		close(svc.release)
+
+		err := <-errCh
+		assert.ErrorContains(t, err, "user defined signal 1 signal received")
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		svc := newService()
+		t.Cleanup(func() { close(svc.release) })
+
+		u := NewUnit(svc, "test-service", "1.0.0", "test", WithShutdownSignals(syscall.SIGUSR1))
+		u.config.Metrics.Enabled = false
+		u.config.Tracing.Enabled = false
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- u.run(context.Background()) }()
+
+		<-svc.started
+		signalSelf(t, syscall.SIGUSR1)
+		<-svc.stopping
+		signalSelf(t, syscall.SIGUSR1)
+
+		select {
+		case err := <-errCh:
+			assert.ErrorContains(t, err, "shutdown forced, test-service still running")
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not return after the second signal")
+		}
+	})
+}
+
+type invalidUTF8Service struct{}
+
+func (s *invalidUTF8Service) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	_ prometheus.Registerer,
+	tp trace.TracerProvider,
+) error {
+	_, span := tp.Tracer("test").Start(
+		ctx,
+		"invalid",
+		trace.WithAttributes(attribute.String("user_input", "bad\xff\xfebytes")),
+	)
+	span.End()
+
+	return errors.New("stop")
+}
+
+func TestRunExportsSpansWithInvalidUTF8(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*coltracepb.ExportTraceServiceRequest
+	)
+
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, err := gzip.NewReader(r.Body)
+				assert.NoError(t, err)
+
+				blob, err := io.ReadAll(body)
+				assert.NoError(t, err)
+
+				request := &coltracepb.ExportTraceServiceRequest{}
+				assert.NoError(t, proto.Unmarshal(blob, request))
+
+				mu.Lock()
+				requests = append(requests, request)
+				mu.Unlock()
+
+				w.WriteHeader(http.StatusOK)
+			},
+		),
+	)
+	defer server.Close()
+
+	u := NewUnit(&invalidUTF8Service{}, "test-service", "1.0.0", "test")
+	u.config.Metrics.Enabled = false
+	u.config.Tracing.Addr = server.Listener.Addr().String()
+	u.config.Tracing.Insecure = true
+
+	err := u.run(context.Background())
+	assert.ErrorContains(t, err, "stop")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !assert.Len(t, requests, 1) {
+		return
+	}
+
+	span := requests[0].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "bad�bytes", span.Attributes[0].Value.GetStringValue())
+}
+
+func TestNewSampler(t *testing.T) {
+	for _, sampler := range []string{TracingSamplerAlways, TracingSamplerNever, TracingSamplerRatio} {
+		s, err := newSampler(TracingConfig{Sampler: sampler, SamplerRatio: 0.5})
+		assert.NoError(t, err)
+		assert.NotNil(t, s)
+	}
+
+	_, err := newSampler(TracingConfig{Sampler: TracingSamplerRatio, SamplerRatio: 2})
+	assert.Error(t, err)
+
+	_, err = newSampler(TracingConfig{Sampler: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestMetricsServerHandlerProfiling(t *testing.T) {
+	metricsHandler := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("metrics"))
+		},
+	)
+
+	u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+	w := httptest.NewRecorder()
+	u.metricsServerHandler(metricsHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	assert.Equal(t, "metrics", w.Body.String(), "pprof must not be served by default")
+
+	u = NewUnit(&testService{}, "test-service", "1.0.0", "test", WithProfiling())
+	h := u.metricsServerHandler(metricsHandler)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "heap")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "metrics", w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+type failingTracesExporter struct {
+	tracesExporter
+}
+
+func (e *failingTracesExporter) Start(context.Context) error {
+	return errors.New("connection refused")
+}
+
+type tracingService struct {
+	started        chan struct{}
+	tracerProvider trace.TracerProvider
+}
+
+func (s *tracingService) Run(
+	ctx context.Context,
+	_ *log.Logger,
+	_ prometheus.Registerer,
+	tp trace.TracerProvider,
+) error {
+	s.tracerProvider = tp
+	close(s.started)
+	<-ctx.Done()
+	return nil
+}
+
+func TestRunTracesExporterStartFailure(t *testing.T) {
+	t.Run("never starts", func(t *testing.T) {
+		svc := &recordingService{}
+		u := NewUnit(svc, "test-service", "1.0.0", "test")
+		u.config.Metrics.Enabled = false
+		u.newTracesExporter = func(TracingConfig) (tracesExporter, error) {
+			return &failingTracesExporter{}, nil
+		}
+
+		err := u.run(context.Background())
+		assert.ErrorContains(t, err, "test-service crashed: stop")
+		assert.NotContains(t, err.Error(), "traces exporter")
+
+		_, span := svc.tracerProvider.Tracer("test").Start(context.Background(), "test")
+		assert.False(t, span.IsRecording())
+	})
+
+	t.Run("starts after a failure", func(t *testing.T) {
+		svc := &tracingService{started: make(chan struct{})}
+
+		attempts := 0
+		u := NewUnit(svc, "test-service", "1.0.0", "test")
+		u.config.Metrics.Enabled = false
+		u.tracesExporterRetryWait = time.Millisecond
+		u.newTracesExporter = func(config TracingConfig) (tracesExporter, error) {
+			attempts++
+			if attempts == 1 {
+				return &failingTracesExporter{}, nil
+			}
+
+			return newTracesExporter(config)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() { errCh <- u.run(ctx) }()
+
+		<-svc.started
+		tracer := svc.tracerProvider.Tracer("test")
+
+		assert.Eventually(
+			t,
+			func() bool {
+				_, span := tracer.Start(context.Background(), "test")
+				defer span.End()
+
+				return span.IsRecording()
+			},
+			5*time.Second,
+			time.Millisecond,
+		)
+
+		cancel()
+		assert.ErrorIs(t, <-errCh, context.Canceled)
+	})
+}
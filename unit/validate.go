@@ -0,0 +1,194 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+var (
+	errInvalidConfiguration = errors.New("invalid configuration")
+)
+
+// validate loads the configuration and validates it without starting
+// anything, for the -validate flag. It writes the problems found to w,
+// one per line, and returns errInvalidConfiguration if there is any.
+func (u *Unit) validate(w io.Writer, filenames []string) error {
+	var errs []error
+
+	if len(filenames) > 0 {
+		if err := u.loadConfigurationFromFiles(filenames...); err != nil {
+			errs = append(errs, problems(err)...)
+		}
+	}
+
+	if err := u.loadConfigurationFromEnv(); err != nil {
+		errs = append(errs, problems(err)...)
+	}
+
+	u.config.Tracing.setDefaultAddr()
+
+	// The configuration is not validated when it cannot be loaded,
+	// the problems found would be misleading.
+	if len(errs) == 0 {
+		if err := u.validateConfiguration(); err != nil {
+			errs = problems(err)
+		}
+	}
+
+	if len(errs) == 0 {
+		fmt.Fprintln(w, "configuration is valid")
+		return nil
+	}
+
+	fmt.Fprintln(w, "configuration is invalid:")
+	for _, err := range errs {
+		fmt.Fprintf(w, "  - %s\n", err)
+	}
+
+	return errInvalidConfiguration
+}
+
+// problems flattens the errors joined with errors.Join, keeping the
+// message of the others as is.
+func problems(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, problems(err)...)
+	}
+
+	return errs
+}
+
+// validateConfiguration checks the unit configuration and the
+// configuration of the runnables implementing Validator, returning all
+// the problems found.
+func (u *Unit) validateConfiguration() error {
+	errs := u.config.validate()
+
+	for _, r := range u.runnables {
+		validator, ok := r.main.(Validator)
+		if !ok {
+			continue
+		}
+
+		if err := validator.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks the configuration of the enabled metrics server and
+// traces exporter.
+func (c *Config) validate() []error {
+	var errs []error
+
+	if c.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("unit.metrics.addr: %w", err))
+		}
+	}
+
+	if c.Tracing.Enabled {
+		errs = append(errs, c.Tracing.validate()...)
+	}
+
+	return errs
+}
+
+func (c TracingConfig) validate() []error {
+	var errs []error
+
+	c.setDefaultAddr()
+
+	conventionalPorts := map[string]string{
+		TracingProtocolGRPC: "4317",
+		TracingProtocolHTTP: "4318",
+	}
+
+	_, supported := conventionalPorts[c.Protocol]
+	if !supported {
+		errs = append(errs, fmt.Errorf("unit.tracing.protocol: unsupported protocol %q", c.Protocol))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Addr); err != nil {
+		// Without a supported protocol, there is no default address
+		// and a missing one is not worth reporting.
+		if c.Addr != "" || supported {
+			errs = append(errs, fmt.Errorf("unit.tracing.addr: %w", err))
+		}
+	} else {
+		for protocol, conventionalPort := range conventionalPorts {
+			if protocol != c.Protocol && port == conventionalPort {
+				errs = append(
+					errs,
+					fmt.Errorf(
+						"unit.tracing.addr: port %s is the %s protocol port, not the %s one",
+						port,
+						protocol,
+						c.Protocol,
+					),
+				)
+			}
+		}
+	}
+
+	if c.Insecure && (c.CAFile != "" || c.CertFile != "" || c.KeyFile != "") {
+		errs = append(errs, errors.New("unit.tracing.insecure: cannot be used with tls files"))
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("unit.tracing.cert-file: must be set along with unit.tracing.key-file"))
+	}
+
+	positives := []struct {
+		name  string
+		value int
+	}{
+		{"max-batch-size", c.MaxBatchSize},
+		{"batch-timeout", c.BatchTimeout},
+		{"export-timeout", c.ExportTimeout},
+		{"max-queue-size", c.MaxQueueSize},
+	}
+	for _, p := range positives {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("unit.tracing.%s: must be positive, got %d", p.name, p.value))
+		}
+	}
+
+	switch c.Sampler {
+	case TracingSamplerAlways, TracingSamplerNever:
+	case TracingSamplerRatio:
+		if c.SamplerRatio < 0 || c.SamplerRatio > 1 {
+			errs = append(errs, fmt.Errorf("unit.tracing.sampler-ratio: must be between 0 and 1, got %v", c.SamplerRatio))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unit.tracing.sampler: unsupported sampler %q", c.Sampler))
+	}
+
+	return errs
+}
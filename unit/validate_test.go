@@ -0,0 +1,180 @@
+// Copyright (c) 2024 Bryan Frimin <bryan@frimin.fr>.
+//
+// Permission to use, copy, modify, and/or distribute this software
+// for any purpose with or without fee is hereby granted, provided
+// that the above copyright notice and this permission notice appear
+// in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR
+// CONSEQUENTIAL DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS
+// OF USE, DATA OR PROFITS, WHETHER IN AN ACTION OF CONTRACT,
+// NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF OR IN
+// CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package unit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validatedService struct {
+	testService
+}
+
+func (s *validatedService) Validate() error {
+	if s.config.Greeting == "" {
+		return errors.New("greeting: must not be empty")
+	}
+
+	return nil
+}
+
+func TestLoadConfigurationFromFilesUnknownKeys(t *testing.T) {
+	filename := writeConfigFile(t, `
+unit:
+  metrics:
+    address: ":9191"
+test-service:
+  greting: "hello"
+test-typo:
+  greeting: "hello"
+`)
+
+	u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+
+	err := u.loadConfigurationFromFiles(filename)
+	assert.ErrorContains(t, err, `cannot decode "test-service" config section: json: unknown field "greting"`)
+	assert.ErrorContains(t, err, `unknown "test-typo" config section`)
+	assert.ErrorContains(t, err, `cannot decode "unit" config section: json: unknown field "address"`)
+}
+
+func TestValidateConfiguration(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+
+		assert.NoError(t, u.validateConfiguration())
+	})
+
+	t.Run("disabled telemetry", func(t *testing.T) {
+		u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+		u.config.Metrics = MetricsConfig{}
+		u.config.Tracing = TracingConfig{}
+
+		assert.NoError(t, u.validateConfiguration())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		u := NewUnit(&validatedService{}, "test-service", "1.0.0", "test")
+		u.config.Metrics.Addr = "9090"
+		u.config.Tracing.Protocol = "udp"
+		u.config.Tracing.MaxBatchSize = 0
+		u.config.Tracing.BatchTimeout = -1
+		u.config.Tracing.Sampler = TracingSamplerRatio
+		u.config.Tracing.SamplerRatio = 2
+
+		err := u.validateConfiguration()
+		assert.ErrorContains(t, err, "unit.metrics.addr: address 9090: missing port in address")
+		assert.ErrorContains(t, err, `unit.tracing.protocol: unsupported protocol "udp"`)
+		assert.ErrorContains(t, err, "unit.tracing.max-batch-size: must be positive, got 0")
+		assert.ErrorContains(t, err, "unit.tracing.batch-timeout: must be positive, got -1")
+		assert.ErrorContains(t, err, "unit.tracing.sampler-ratio: must be between 0 and 1, got 2")
+		assert.ErrorContains(t, err, "test-service: greeting: must not be empty")
+	})
+}
+
+func TestTracingConfigAddr(t *testing.T) {
+	for _, tc := range []struct {
+		protocol string
+		addr     string
+		expected string
+		err      string
+	}{
+		{TracingProtocolGRPC, "", "localhost:4317", ""},
+		{TracingProtocolHTTP, "", "localhost:4318", ""},
+		{TracingProtocolGRPC, "collector:4318", "collector:4318", "unit.tracing.addr: port 4318 is the http protocol port, not the grpc one"},
+		{TracingProtocolHTTP, "collector:4317", "collector:4317", "unit.tracing.addr: port 4317 is the grpc protocol port, not the http one"},
+		{TracingProtocolGRPC, "collector:443", "collector:443", ""},
+	} {
+		filename := writeConfigFile(t, fmt.Sprintf(`
+unit:
+  tracing:
+    protocol: %q
+    addr: %q
+`, tc.protocol, tc.addr))
+
+		u := NewUnit(&testService{}, "test-service", "1.0.0", "test")
+		require.NoError(t, u.loadConfiguration([]string{filename}))
+		assert.Equal(t, tc.expected, u.config.Tracing.Addr)
+
+		err := u.validateConfiguration()
+		if tc.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		filename := writeConfigFile(t, `
+test-service:
+  greeting: "hello"
+`)
+
+		var buf bytes.Buffer
+		u := NewUnit(&validatedService{}, "test-service", "1.0.0", "test")
+
+		require.NoError(t, u.validate(&buf, []string{filename}))
+		assert.Equal(t, "configuration is valid\n", buf.String())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		filename := writeConfigFile(t, `
+unit:
+  tracing:
+    protocol: "udp"
+    max-batch-size: 0
+`)
+
+		var buf bytes.Buffer
+		u := NewUnit(&validatedService{}, "test-service", "1.0.0", "test")
+
+		err := u.validate(&buf, []string{filename})
+		assert.ErrorIs(t, err, errInvalidConfiguration)
+		assert.Equal(
+			t,
+			"configuration is invalid:\n"+
+				"  - unit.tracing.protocol: unsupported protocol \"udp\"\n"+
+				"  - unit.tracing.max-batch-size: must be positive, got 0\n"+
+				"  - test-service: greeting: must not be empty\n",
+			buf.String(),
+		)
+	})
+
+	t.Run("cannot load", func(t *testing.T) {
+		filename := writeConfigFile(t, `
+test-service:
+  greting: "hello"
+test-typo: {}
+`)
+
+		var buf bytes.Buffer
+		u := NewUnit(&validatedService{}, "test-service", "1.0.0", "test")
+
+		err := u.validate(&buf, []string{filename})
+		assert.ErrorIs(t, err, errInvalidConfiguration)
+		assert.Contains(t, buf.String(), `  - cannot decode "test-service" config section: json: unknown field "greting"`)
+		assert.Contains(t, buf.String(), `  - unknown "test-typo" config section`)
+		assert.NotContains(t, buf.String(), "greeting: must not be empty")
+	})
+}